@@ -6,8 +6,11 @@ import (
 	"os"
 
 	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/database"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/proxy"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/router"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/server"
 )
@@ -73,11 +76,32 @@ func main() {
 
 	fmt.Println("Connected to database successfully")
 
+	// Start the authentication audit log writer
+	auditLogger := audit.NewLogger(db)
+	defer auditLogger.Close()
+
+	// Set up outgoing mail
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to set up mailer: %v", err)
+	}
+
+	// Set up the proxy to downstream services
+	proxyHandler, err := proxy.NewProxyHandler(cfg.Services, cfg.Proxy)
+	if err != nil {
+		log.Fatalf("Failed to set up proxy: %v", err)
+	}
+
+	proxyHandler.StartHealthChecks()
+
 	// Set up router
-	r := router.SetupRouter(db, cfg)
+	r := router.SetupRouter(db, cfg, auditLogger, mail, proxyHandler)
 
-	// Create and start server
+	// Create and start server. Proxied WebSocket connections are hijacked from
+	// the server, so they are closed separately on shutdown.
 	srv := server.NewServer(r, cfg)
+	srv.RegisterOnShutdown(proxyHandler.ShutdownTunnels)
+	srv.RegisterOnShutdown(proxyHandler.StopHealthChecks)
 
 	fmt.Printf("Starting mini-kiosk central gateway on port %d...\n", cfg.Server.Port)
 	if err := srv.Start(); err != nil {
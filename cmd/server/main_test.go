@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/proxy"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/router"
 	_ "github.com/lib/pq"
 )
@@ -57,7 +58,11 @@ func TestHealthEndpoint(t *testing.T) {
 	// Set up the router with test database and config
 	db := setupTestDB()
 	cfg := setupTestConfig()
-	r := router.SetupRouter(db, cfg)
+	proxyHandler, err := proxy.NewProxyHandler(cfg.Services, cfg.Proxy)
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	r := router.SetupRouter(db, cfg, nil, nil, proxyHandler)
 	if db != nil {
 		defer db.Close()
 	}
@@ -98,7 +103,11 @@ func TestReadyEndpoint(t *testing.T) {
 	// Set up the router with test database and config
 	db := setupTestDB()
 	cfg := setupTestConfig()
-	r := router.SetupRouter(db, cfg)
+	proxyHandler, err := proxy.NewProxyHandler(cfg.Services, cfg.Proxy)
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	r := router.SetupRouter(db, cfg, nil, nil, proxyHandler)
 	if db != nil {
 		defer db.Close()
 	}
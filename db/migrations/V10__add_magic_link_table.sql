@@ -0,0 +1,14 @@
+-- Description: Add single-use passwordless sign-in links
+-- V10__add_magic_link_table.sql
+
+-- Create magic link table, a row is deleted when its link is used
+CREATE TABLE IF NOT EXISTS "magic_link" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_magic_link_user_id ON "magic_link"(user_id);
+CREATE INDEX IF NOT EXISTS idx_magic_link_expires_at ON "magic_link"(expires_at);
@@ -0,0 +1,20 @@
+-- Description: Add invite codes for invite-only registration
+-- V11__add_user_invite_table.sql
+
+-- Create user invite table, only a hash of the invite code is stored
+CREATE TABLE IF NOT EXISTS "user_invite" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    code_hash VARCHAR(64) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL DEFAULT 'cashier',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_by UUID REFERENCES "user"(id) ON DELETE SET NULL,
+    used_by UUID REFERENCES "user"(id) ON DELETE SET NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_user_invite_email ON "user_invite"(lower(email));
+CREATE INDEX IF NOT EXISTS idx_user_invite_expires_at ON "user_invite"(expires_at);
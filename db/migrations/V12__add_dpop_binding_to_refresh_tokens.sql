@@ -0,0 +1,5 @@
+-- Description: Bind refresh tokens to the client's DPoP key
+-- V12__add_dpop_binding_to_refresh_tokens.sql
+
+-- Add the DPoP key thumbprint (RFC 7638) a refresh token is bound to, NULL for bearer tokens
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS dpop_jkt VARCHAR(64);
@@ -0,0 +1,8 @@
+-- Description: Add access token scopes to sessions and kiosk devices
+-- V13__add_token_scopes.sql
+
+-- Add the space-separated scope granted at login, kept across refreshes. NULL for sessions from before scopes
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT;
+
+-- Add the scopes sessions opened on a kiosk are limited to, NULL for no limit
+ALTER TABLE "kiosk_device" ADD COLUMN IF NOT EXISTS scopes TEXT[];
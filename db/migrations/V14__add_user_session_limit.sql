@@ -0,0 +1,8 @@
+-- Description: Add a per-user limit on concurrent sessions
+-- V14__add_user_session_limit.sql
+
+-- Add the maximum number of active sessions of the user, NULL to use the role or global limit and 0 for unlimited
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS max_sessions INTEGER CHECK (max_sessions >= 0);
+
+-- Add an index for finding a user's oldest sessions
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id_created_at ON refresh_tokens(user_id, created_at);
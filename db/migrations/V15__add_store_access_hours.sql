@@ -0,0 +1,8 @@
+-- Description: Add timezones and access hours to stores
+-- V15__add_store_access_hours.sql
+
+-- Add the IANA timezone access hours of the store are in
+ALTER TABLE "store" ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- Add the weekly windows users may authenticate in while working in the store, NULL for no limit
+ALTER TABLE "store" ADD COLUMN IF NOT EXISTS access_hours JSONB;
@@ -0,0 +1,10 @@
+-- Description: Count failed PIN attempts per kiosk device
+-- V16__add_kiosk_device_pin_lockout.sql
+
+-- Failed PIN attempts on the device across all operators, so a device token
+-- cannot be used to try a few PINs against every operator. The count covers
+-- the attempts since pin_failed_window_start and is not reset by a successful
+-- login, only by the window running out.
+ALTER TABLE "kiosk_device" ADD COLUMN IF NOT EXISTS pin_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "kiosk_device" ADD COLUMN IF NOT EXISTS pin_locked_until TIMESTAMP WITH TIME ZONE;
+ALTER TABLE "kiosk_device" ADD COLUMN IF NOT EXISTS pin_failed_window_start TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,33 @@
+-- Description: Add kiosk devices, user roles and operator PIN quick-login
+-- V4__add_kiosk_device_and_operator_pin.sql
+
+-- Add role and PIN columns to users table
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'cashier';
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS pin_hash VARCHAR(255);
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS pin_failed_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS pin_locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Create kiosk device table
+CREATE TABLE IF NOT EXISTS "kiosk_device" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create trigger for kiosk device table
+CREATE TRIGGER update_kiosk_device_timestamp
+  BEFORE UPDATE ON "kiosk_device"
+  FOR EACH ROW
+  EXECUTE FUNCTION update_modified_column();
+
+-- Track which kiosk device a session was opened on
+ALTER TABLE "refresh_tokens" ADD COLUMN IF NOT EXISTS device_id UUID REFERENCES "kiosk_device"(id) ON DELETE CASCADE;
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_user_role ON "user"(role);
+CREATE INDEX IF NOT EXISTS idx_kiosk_device_active ON "kiosk_device"(is_active);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_device_id ON "refresh_tokens"(device_id);
@@ -0,0 +1,55 @@
+-- Description: Add tenants (merchants), stores and user store membership
+-- V5__add_tenant_and_store_tables.sql
+
+-- Create tenant table
+CREATE TABLE IF NOT EXISTS "tenant" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    name VARCHAR(255) NOT NULL UNIQUE,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create store table
+CREATE TABLE IF NOT EXISTS "store" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    tenant_id UUID NOT NULL REFERENCES "tenant"(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    is_active BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (tenant_id, name)
+);
+
+-- Create user store membership table
+CREATE TABLE IF NOT EXISTS "user_store" (
+    user_id UUID NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    store_id UUID NOT NULL REFERENCES "store"(id) ON DELETE CASCADE,
+    is_default BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, store_id)
+);
+
+-- Link users, devices and sessions to tenants and stores
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS tenant_id UUID REFERENCES "tenant"(id) ON DELETE SET NULL;
+ALTER TABLE "kiosk_device" ADD COLUMN IF NOT EXISTS store_id UUID REFERENCES "store"(id) ON DELETE SET NULL;
+ALTER TABLE "refresh_tokens" ADD COLUMN IF NOT EXISTS store_id UUID REFERENCES "store"(id) ON DELETE SET NULL;
+
+-- Create triggers for updating timestamp
+CREATE TRIGGER update_tenant_timestamp
+  BEFORE UPDATE ON "tenant"
+  FOR EACH ROW
+  EXECUTE FUNCTION update_modified_column();
+
+CREATE TRIGGER update_store_timestamp
+  BEFORE UPDATE ON "store"
+  FOR EACH ROW
+  EXECUTE FUNCTION update_modified_column();
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_store_tenant_id ON "store"(tenant_id);
+CREATE INDEX IF NOT EXISTS idx_user_store_store_id ON "user_store"(store_id);
+CREATE INDEX IF NOT EXISTS idx_user_tenant_id ON "user"(tenant_id);
+
+-- Only one default store per user
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_store_default ON "user_store"(user_id) WHERE is_default;
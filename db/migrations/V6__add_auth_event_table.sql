@@ -0,0 +1,34 @@
+-- Description: Add append-only authentication audit log
+-- V6__add_auth_event_table.sql
+
+-- Create authentication event table
+CREATE TABLE IF NOT EXISTS "auth_event" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    event_type VARCHAR(50) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    reason VARCHAR(255),
+    user_id UUID,
+    username VARCHAR(255),
+    ip_address VARCHAR(64),
+    user_agent TEXT,
+    request_id VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit events can only be appended, never changed or removed
+CREATE OR REPLACE FUNCTION prevent_auth_event_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+   RAISE EXCEPTION 'auth_event is append-only';
+END;
+$$ LANGUAGE 'plpgsql';
+
+CREATE TRIGGER prevent_auth_event_update_delete
+  BEFORE UPDATE OR DELETE ON "auth_event"
+  FOR EACH ROW
+  EXECUTE FUNCTION prevent_auth_event_modification();
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_auth_event_created_at ON "auth_event"(created_at);
+CREATE INDEX IF NOT EXISTS idx_auth_event_user_id ON "auth_event"(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_auth_event_type ON "auth_event"(event_type, created_at);
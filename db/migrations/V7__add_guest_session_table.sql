@@ -0,0 +1,18 @@
+-- Description: Add anonymous guest sessions for kiosk customers
+-- V7__add_guest_session_table.sql
+
+-- Create guest session table
+CREATE TABLE IF NOT EXISTS "guest_session" (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    device_id UUID NOT NULL REFERENCES "kiosk_device"(id) ON DELETE CASCADE,
+    store_id UUID REFERENCES "store"(id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    upgraded_user_id UUID REFERENCES "user"(id) ON DELETE SET NULL,
+    upgraded_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_guest_session_device_id ON "guest_session"(device_id);
+CREATE INDEX IF NOT EXISTS idx_guest_session_upgraded_user_id ON "guest_session"(upgraded_user_id);
+CREATE INDEX IF NOT EXISTS idx_guest_session_expires_at ON "guest_session"(expires_at);
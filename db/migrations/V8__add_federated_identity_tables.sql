@@ -0,0 +1,27 @@
+-- Description: Add federated identity links and pending OIDC sign-in state
+-- V8__add_federated_identity_tables.sql
+
+-- Create user identity table linking provider subjects to users
+CREATE TABLE IF NOT EXISTS "user_identity" (
+    provider VARCHAR(100) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id UUID NOT NULL REFERENCES "user"(id) ON DELETE CASCADE,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+-- Create OIDC login state table for sign-ins in progress
+CREATE TABLE IF NOT EXISTS "oidc_login_state" (
+    state VARCHAR(255) PRIMARY KEY,
+    provider VARCHAR(100) NOT NULL,
+    nonce VARCHAR(255) NOT NULL,
+    code_verifier VARCHAR(255) NOT NULL,
+    store_id UUID,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create indexes for better performance
+CREATE INDEX IF NOT EXISTS idx_user_identity_user_id ON "user_identity"(user_id);
+CREATE INDEX IF NOT EXISTS idx_oidc_login_state_expires_at ON "oidc_login_state"(expires_at);
@@ -0,0 +1,11 @@
+-- Description: Add SCIM provisioning support to the user table
+-- V9__add_scim_provisioning_columns.sql
+
+-- Identifier of the user in the provisioning client (HR system)
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_external_id ON "user"(external_id) WHERE external_id IS NOT NULL;
+
+-- Users are created by registration, federated sign-in and SCIM without these columns
+ALTER TABLE "user" ALTER COLUMN is_active SET DEFAULT true;
+ALTER TABLE "user" ALTER COLUMN created_by SET DEFAULT 'system';
+ALTER TABLE "user" ALTER COLUMN updated_by SET DEFAULT 'system';
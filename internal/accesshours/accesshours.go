@@ -0,0 +1,145 @@
+// Package accesshours decides whether a point in time falls within weekly
+// access windows, such as the opening hours of a store.
+package accesshours
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// Store timezones must resolve on hosts without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// weekdays maps the day names used in windows to time.Weekday
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a weekly time range. Start and End are "HH:MM" in the local time
+// of the store. A window whose end is not after its start runs past midnight,
+// and belongs to the day it starts on. Without days it applies every day.
+type Window struct {
+	Days  []string `mapstructure:"days" json:"days,omitempty"` // mon, tue, wed, thu, fri, sat, sun
+	Start string   `mapstructure:"start" json:"start"`
+	End   string   `mapstructure:"end" json:"end"`
+}
+
+// Validate checks the day names and times of the window
+func (w Window) Validate() error {
+	for _, day := range w.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return fmt.Errorf("invalid day %q, must be one of mon, tue, wed, thu, fri, sat, sun", day)
+		}
+	}
+	if _, err := parseClock(w.Start); err != nil {
+		return fmt.Errorf("invalid start: %w", err)
+	}
+	if _, err := parseClock(w.End); err != nil {
+		return fmt.Errorf("invalid end: %w", err)
+	}
+	return nil
+}
+
+// ValidateAll checks every window
+func ValidateAll(windows []Window) error {
+	for i, w := range windows {
+		if err := w.Validate(); err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// maxChainedWindows bounds how many back-to-back windows Until follows
+const maxChainedWindows = 14
+
+// Until reports whether t falls within one of the windows, and if so when
+// access ends. Back-to-back windows, like 08:00-12:00 and 12:00-20:00, count
+// as one. Windows are evaluated in t's location. Invalid windows never match.
+func Until(windows []Window, t time.Time) (time.Time, bool) {
+	until, ok := closingTime(windows, t)
+	if !ok {
+		return time.Time{}, false
+	}
+	for i := 0; i < maxChainedWindows; i++ {
+		next, ok := closingTime(windows, until)
+		if !ok || !next.After(until) {
+			break
+		}
+		until = next
+	}
+	return until, true
+}
+
+// closingTime returns the latest closing time of the windows open at t
+func closingTime(windows []Window, t time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, w := range windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			continue
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			continue
+		}
+
+		// A window that runs past midnight may have started the day before
+		for _, daysAgo := range []int{0, 1} {
+			day := t.AddDate(0, 0, -daysAgo)
+			if !w.appliesOn(day.Weekday()) {
+				continue
+			}
+
+			opens := atClock(day, start)
+			closes := atClock(day, end)
+			if !closes.After(opens) {
+				closes = atClock(day.AddDate(0, 0, 1), end)
+			}
+			if !t.Before(opens) && t.Before(closes) && closes.After(until) {
+				until = closes
+			}
+		}
+	}
+	return until, !until.IsZero()
+}
+
+// appliesOn reports whether the window opens on the weekday
+func (w Window) appliesOn(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if weekdays[strings.ToLower(day)] == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "HH:MM" into the time since midnight. "24:00" is allowed
+// as the end of the day.
+func parseClock(value string) (time.Duration, error) {
+	if value == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	clock, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, errors.New("must be HH:MM")
+	}
+	return time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute, nil
+}
+
+// atClock returns the wall clock time on t's date
+func atClock(t time.Time, clock time.Duration) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, t.Location())
+}
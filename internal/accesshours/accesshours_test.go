@@ -0,0 +1,87 @@
+package accesshours
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUntil(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Fatalf("Failed to load location: %v", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		// 2024-07-01 is a Monday
+		return time.Date(2024, time.July, day, hour, minute, 0, 0, jakarta)
+	}
+
+	weekdays := []Window{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "22:00"}}
+	overnight := []Window{{Days: []string{"fri"}, Start: "20:00", End: "02:00"}}
+	split := []Window{{Start: "08:00", End: "12:00"}, {Start: "12:00", End: "20:00"}}
+
+	tests := []struct {
+		name      string
+		windows   []Window
+		t         time.Time
+		wantOK    bool
+		wantUntil time.Time
+	}{
+		{"within weekday hours", weekdays, at(1, 9, 30), true, at(1, 22, 0)},
+		{"at opening", weekdays, at(1, 8, 0), true, at(1, 22, 0)},
+		{"at closing", weekdays, at(1, 22, 0), false, time.Time{}},
+		{"at night", weekdays, at(2, 3, 0), false, time.Time{}},
+		{"on the weekend", weekdays, at(6, 9, 30), false, time.Time{}},
+		{"overnight before midnight", overnight, at(5, 23, 0), true, at(6, 2, 0)},
+		{"overnight after midnight", overnight, at(6, 1, 0), true, at(6, 2, 0)},
+		{"overnight on the wrong day", overnight, at(4, 23, 0), false, time.Time{}},
+		{"back-to-back windows", split, at(1, 10, 0), true, at(1, 20, 0)},
+		{"no windows", nil, at(1, 10, 0), false, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, ok := Until(tt.windows, tt.t)
+			if ok != tt.wantOK {
+				t.Fatalf("Expected ok %v, got %v", tt.wantOK, ok)
+			}
+			if !until.Equal(tt.wantUntil) {
+				t.Errorf("Expected until %v, got %v", tt.wantUntil, until)
+			}
+		})
+	}
+}
+
+func TestUntil_Timezone(t *testing.T) {
+	jakarta, _ := time.LoadLocation("Asia/Jakarta")
+	windows := []Window{{Start: "08:00", End: "22:00"}}
+
+	// 02:00 UTC is 09:00 in Jakarta
+	utc := time.Date(2024, time.July, 1, 2, 0, 0, 0, time.UTC)
+	if _, ok := Until(windows, utc); ok {
+		t.Error("Expected 02:00 UTC to be outside the windows")
+	}
+	if _, ok := Until(windows, utc.In(jakarta)); !ok {
+		t.Error("Expected 09:00 in Jakarta to be within the windows")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		window  Window
+		wantErr bool
+	}{
+		{"valid", Window{Days: []string{"mon", "Sat"}, Start: "08:00", End: "24:00"}, false},
+		{"unknown day", Window{Days: []string{"monday"}, Start: "08:00", End: "22:00"}, true},
+		{"invalid start", Window{Start: "8am", End: "22:00"}, true},
+		{"missing end", Window{Start: "08:00"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package audit
+
+import (
+	"database/sql"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventRegister       = "register"
+	EventLogin          = "login"
+	EventLogout         = "logout"
+	EventRefresh        = "refresh"
+	EventPinLogin       = "pin_login"
+	EventSetPin         = "set_pin"
+	EventDeviceToken    = "device_token"
+	EventSwitchStore    = "switch_store"
+	EventGuestStart     = "guest_session"
+	EventGuestUpgrade   = "guest_upgrade"
+	EventOIDCLogin      = "oidc_login"
+	EventMagicLink      = "magic_link_request"
+	EventMagicLogin     = "magic_link_login"
+	EventSessionEvicted = "session_evicted"
+)
+
+// Event outcomes
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Event represents a single authentication event
+type Event struct {
+	Type      string
+	Outcome   string
+	Reason    string
+	UserID    string
+	Username  string
+	IPAddress string
+	UserAgent string
+	RequestID string
+	CreatedAt time.Time
+}
+
+// Logger writes authentication events to the auth_event table in the background
+// so recording an event never blocks the request that produced it
+type Logger struct {
+	db     *sql.DB
+	events chan Event
+	wg     sync.WaitGroup
+}
+
+// defaultBufferSize is the number of events that can wait to be written
+const defaultBufferSize = 1024
+
+// NewLogger creates a new audit logger and starts its writer
+func NewLogger(db *sql.DB) *Logger {
+	l := &Logger{
+		db:     db,
+		events: make(chan Event, defaultBufferSize),
+	}
+
+	l.wg.Add(1)
+	go l.run()
+
+	return l
+}
+
+// Record queues an event for writing. If the buffer is full the event is
+// dropped and logged rather than slowing down the caller.
+// Recording on a nil logger is a no-op.
+func (l *Logger) Record(event Event) {
+	if l == nil {
+		return
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	select {
+	case l.events <- event:
+	default:
+		log.Printf("Audit buffer full, dropping %s event (outcome: %s, user: %s)", event.Type, event.Outcome, event.Username)
+	}
+}
+
+// Close stops accepting events and waits for queued events to be written
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+
+	close(l.events)
+	l.wg.Wait()
+}
+
+// run writes queued events until the logger is closed
+func (l *Logger) run() {
+	defer l.wg.Done()
+
+	for event := range l.events {
+		if err := l.write(event); err != nil {
+			log.Printf("Failed to write %s audit event: %v", event.Type, err)
+		}
+	}
+}
+
+// write inserts a single event
+func (l *Logger) write(event Event) error {
+	_, err := l.db.Exec(`
+		INSERT INTO "auth_event" (event_type, outcome, reason, user_id, username, ip_address, user_agent, request_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		event.Type,
+		event.Outcome,
+		nullString(event.Reason),
+		nullString(event.UserID),
+		nullString(event.Username),
+		nullString(event.IPAddress),
+		nullString(event.UserAgent),
+		nullString(event.RequestID),
+		event.CreatedAt,
+	)
+	return err
+}
+
+// nullString converts an empty string to a NULL database value
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Load balancing policies across the endpoints of a service
+const (
+	BalanceRoundRobin       = "round_robin"       // in turn, in proportion to the weights
+	BalanceLeastConnections = "least_connections" // to the endpoint with the fewest requests in flight for its weight
+	BalanceConsistentHash   = "consistent_hash"   // the same user or store to the same endpoint
+)
+
+// Keys the consistent_hash policy can hash by
+const (
+	HashByUser  = "user"  // the user, or the guest session
+	HashByStore = "store" // the active store
+)
+
+// EndpointConfig is an instance of a service
+type EndpointConfig struct {
+	URL    string `mapstructure:"url"`
+	Weight int    `mapstructure:"weight"` // share of the requests relative to the other endpoints, defaults to 1
+}
+
+// LoadBalancingConfig holds how requests are spread across the endpoints of a service
+type LoadBalancingConfig struct {
+	Policy  string `mapstructure:"policy"`   // round_robin, least_connections or consistent_hash, defaults to round_robin
+	HashKey string `mapstructure:"hash_key"` // user or store, for consistent_hash, defaults to user
+}
+
+// HealthCheckConfig holds the active health checks of the endpoints of a service
+type HealthCheckConfig struct {
+	Path               string `mapstructure:"path"`                // requested on every endpoint, health checks are off without one
+	Interval           int    `mapstructure:"interval"`            // in seconds, defaults to 10
+	Timeout            int    `mapstructure:"timeout"`             // in seconds, defaults to 2
+	UnhealthyThreshold int    `mapstructure:"unhealthy_threshold"` // failed checks in a row that take an endpoint out of rotation, defaults to 3
+	HealthyThreshold   int    `mapstructure:"healthy_threshold"`   // passed checks in a row that put it back, defaults to 2
+}
+
+// defaultHealthCheck is the health check of services that do not set one
+var defaultHealthCheck = HealthCheckConfig{
+	Interval:           10,
+	Timeout:            2,
+	UnhealthyThreshold: 3,
+	HealthyThreshold:   2,
+}
+
+// validateEndpoints checks the endpoints, load balancing and health checks of
+// a service and fills in their defaults. A base_url becomes the service's only
+// endpoint.
+func validateEndpoints(name string, service *ServiceConfig) error {
+	if service.BaseURL != "" {
+		if len(service.Endpoints) > 0 {
+			return fmt.Errorf("services.%s: set either base_url or endpoints", name)
+		}
+		service.Endpoints = []EndpointConfig{{URL: service.BaseURL}}
+		service.BaseURL = ""
+	}
+	if len(service.Endpoints) == 0 {
+		return fmt.Errorf("services.%s: set base_url or endpoints", name)
+	}
+
+	for i := range service.Endpoints {
+		endpoint := &service.Endpoints[i]
+		target, err := url.Parse(endpoint.URL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("services.%s.endpoints[%d]: invalid url %q", name, i, endpoint.URL)
+		}
+		if endpoint.Weight < 0 {
+			return fmt.Errorf("services.%s.endpoints[%d]: weight must not be negative", name, i)
+		}
+		if endpoint.Weight == 0 {
+			endpoint.Weight = 1
+		}
+	}
+
+	balancing := &service.LoadBalancing
+	balancing.Policy = strings.ToLower(balancing.Policy)
+	if balancing.Policy == "" {
+		balancing.Policy = BalanceRoundRobin
+	}
+	switch balancing.Policy {
+	case BalanceRoundRobin, BalanceLeastConnections, BalanceConsistentHash:
+	default:
+		return fmt.Errorf("services.%s.load_balancing: invalid policy %q, must be round_robin, least_connections or consistent_hash", name, balancing.Policy)
+	}
+	if balancing.HashKey == "" {
+		balancing.HashKey = HashByUser
+	}
+	if balancing.HashKey != HashByUser && balancing.HashKey != HashByStore {
+		return fmt.Errorf("services.%s.load_balancing: invalid hash_key %q, must be user or store", name, balancing.HashKey)
+	}
+
+	check := &service.HealthCheck
+	if check.Path != "" && !strings.HasPrefix(check.Path, "/") {
+		return fmt.Errorf("services.%s.health_check: path must start with /", name)
+	}
+	if check.Interval < 0 || check.Timeout < 0 || check.UnhealthyThreshold < 0 || check.HealthyThreshold < 0 {
+		return fmt.Errorf("services.%s.health_check: interval, timeout and thresholds must not be negative", name)
+	}
+	if check.Interval == 0 {
+		check.Interval = defaultHealthCheck.Interval
+	}
+	if check.Timeout == 0 {
+		check.Timeout = defaultHealthCheck.Timeout
+	}
+	if check.UnhealthyThreshold == 0 {
+		check.UnhealthyThreshold = defaultHealthCheck.UnhealthyThreshold
+	}
+	if check.HealthyThreshold == 0 {
+		check.HealthyThreshold = defaultHealthCheck.HealthyThreshold
+	}
+	if check.Timeout > check.Interval {
+		return fmt.Errorf("services.%s.health_check: timeout must not be longer than the interval", name)
+	}
+	return nil
+}
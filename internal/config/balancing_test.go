@@ -0,0 +1,53 @@
+package config
+
+import "testing"
+
+func TestValidateEndpoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		service ServiceConfig
+		wantErr bool
+	}{
+		{"no endpoints", ServiceConfig{}, true},
+		{"base url", ServiceConfig{BaseURL: "http://inventory:8080"}, false},
+		{"endpoints", ServiceConfig{Endpoints: []EndpointConfig{{URL: "http://inventory-1:8080", Weight: 2}, {URL: "https://inventory-2"}}}, false},
+		{"base url and endpoints", ServiceConfig{BaseURL: "http://inventory:8080", Endpoints: []EndpointConfig{{URL: "http://inventory-1:8080"}}}, true},
+		{"relative url", ServiceConfig{Endpoints: []EndpointConfig{{URL: "inventory-1:8080"}}}, true},
+		{"negative weight", ServiceConfig{Endpoints: []EndpointConfig{{URL: "http://inventory-1", Weight: -1}}}, true},
+		{"consistent hash by store", ServiceConfig{BaseURL: "http://inventory:8080", LoadBalancing: LoadBalancingConfig{Policy: "Consistent_Hash", HashKey: HashByStore}}, false},
+		{"unknown policy", ServiceConfig{BaseURL: "http://inventory:8080", LoadBalancing: LoadBalancingConfig{Policy: "random"}}, true},
+		{"unknown hash key", ServiceConfig{BaseURL: "http://inventory:8080", LoadBalancing: LoadBalancingConfig{Policy: BalanceConsistentHash, HashKey: "device"}}, true},
+		{"health check", ServiceConfig{BaseURL: "http://inventory:8080", HealthCheck: HealthCheckConfig{Path: "/health", Interval: 5}}, false},
+		{"relative health check path", ServiceConfig{BaseURL: "http://inventory:8080", HealthCheck: HealthCheckConfig{Path: "health"}}, true},
+		{"health check timeout over interval", ServiceConfig{BaseURL: "http://inventory:8080", HealthCheck: HealthCheckConfig{Path: "/health", Interval: 2, Timeout: 5}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service
+			if err := validateEndpoints("inventory_service", &service); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateEndpoints_Defaults(t *testing.T) {
+	service := ServiceConfig{BaseURL: "http://inventory:8080"}
+	if err := validateEndpoints("inventory_service", &service); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(service.Endpoints) != 1 || service.Endpoints[0] != (EndpointConfig{URL: "http://inventory:8080", Weight: 1}) {
+		t.Errorf("Expected the base url as the only endpoint, got %+v", service.Endpoints)
+	}
+	if service.BaseURL != "" {
+		t.Errorf("Expected the base url moved to the endpoints, got %q", service.BaseURL)
+	}
+	if service.LoadBalancing != (LoadBalancingConfig{Policy: BalanceRoundRobin, HashKey: HashByUser}) {
+		t.Errorf("Expected round robin by default, got %+v", service.LoadBalancing)
+	}
+	if service.HealthCheck != defaultHealthCheck {
+		t.Errorf("Expected the default health check, got %+v", service.HealthCheck)
+	}
+}
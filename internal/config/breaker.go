@@ -0,0 +1,60 @@
+package config
+
+import "fmt"
+
+// CircuitBreakerConfig holds the circuit breaker of a service. The breaker
+// opens when too many of the service's requests over the last 10 seconds fail
+// or are slow, and requests then fail fast until it has stayed open for
+// open_duration. After that a few trial requests decide whether it closes again.
+type CircuitBreakerConfig struct {
+	FailureRate      int `mapstructure:"failure_rate"`       // percentage of failed requests that opens the breaker, defaults to 50
+	SlowCallRate     int `mapstructure:"slow_call_rate"`     // percentage of slow requests that opens the breaker, defaults to 80
+	SlowCallDuration int `mapstructure:"slow_call_duration"` // in milliseconds before the response headers for a request to count as slow, defaults to 5000
+	MinRequests      int `mapstructure:"min_requests"`       // requests over the last 10 seconds before the rates count, defaults to 20
+	OpenDuration     int `mapstructure:"open_duration"`      // in seconds, defaults to 30
+	HalfOpenRequests int `mapstructure:"half_open_requests"` // trial requests that must succeed to close the breaker, defaults to 3
+}
+
+// defaultCircuitBreaker is the circuit breaker of services that do not set one
+var defaultCircuitBreaker = CircuitBreakerConfig{
+	FailureRate:      50,
+	SlowCallRate:     80,
+	SlowCallDuration: 5000,
+	MinRequests:      20,
+	OpenDuration:     30,
+	HalfOpenRequests: 3,
+}
+
+// withDefaults fills in the unset fields of a circuit breaker
+func (b CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if b.FailureRate == 0 {
+		b.FailureRate = defaultCircuitBreaker.FailureRate
+	}
+	if b.SlowCallRate == 0 {
+		b.SlowCallRate = defaultCircuitBreaker.SlowCallRate
+	}
+	if b.SlowCallDuration == 0 {
+		b.SlowCallDuration = defaultCircuitBreaker.SlowCallDuration
+	}
+	if b.MinRequests == 0 {
+		b.MinRequests = defaultCircuitBreaker.MinRequests
+	}
+	if b.OpenDuration == 0 {
+		b.OpenDuration = defaultCircuitBreaker.OpenDuration
+	}
+	if b.HalfOpenRequests == 0 {
+		b.HalfOpenRequests = defaultCircuitBreaker.HalfOpenRequests
+	}
+	return b
+}
+
+// validateCircuitBreaker checks the thresholds of a circuit breaker
+func validateCircuitBreaker(name string, breaker CircuitBreakerConfig) error {
+	if breaker.FailureRate < 0 || breaker.FailureRate > 100 || breaker.SlowCallRate < 0 || breaker.SlowCallRate > 100 {
+		return fmt.Errorf("%s: failure_rate and slow_call_rate must be between 0 and 100", name)
+	}
+	if breaker.SlowCallDuration < 0 || breaker.MinRequests < 0 || breaker.OpenDuration < 0 || breaker.HalfOpenRequests < 0 {
+		return fmt.Errorf("%s: slow_call_duration, min_requests, open_duration and half_open_requests must not be negative", name)
+	}
+	return nil
+}
@@ -3,8 +3,12 @@ package config
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/accesshours"
 	"github.com/spf13/viper"
 )
 
@@ -16,6 +20,11 @@ type Config struct {
 	Gin      GinConfig        `mapstructure:"gin"`
 	Flyway   FlywayConfig     `mapstructure:"flyway"`
 	Keys     PublicPrivateKey `mapstructure:"keys"`
+	Auth     AuthConfig       `mapstructure:"auth"`
+	Mail     MailConfig       `mapstructure:"mail"`
+	Routes   []RouteConfig    `mapstructure:"routes"`
+	Proxy    ProxyConfig      `mapstructure:"proxy"`
+	Metrics  MetricsConfig    `mapstructure:"metrics"`
 }
 
 // ServerConfig holds server configuration
@@ -36,18 +45,65 @@ type DatabaseConfig struct {
 	SSLMode  string `mapstructure:"sslmode"`
 }
 
-// ServicesConfig holds downstream services configuration
-type ServicesConfig struct {
-	AuthService      ServiceConfig `mapstructure:"auth_service"`
-	OrderService     ServiceConfig `mapstructure:"order_service"`
-	InventoryService ServiceConfig `mapstructure:"inventory_service"`
-	PaymentService   ServiceConfig `mapstructure:"payment_service"`
-}
+// ServicesConfig holds downstream services configuration, keyed by service name
+type ServicesConfig map[string]ServiceConfig
 
 // ServiceConfig holds individual service configuration
 type ServiceConfig struct {
-	BaseURL string `mapstructure:"base_url"`
-	Timeout int    `mapstructure:"timeout"`
+	BaseURL         string               `mapstructure:"base_url"`  // the only endpoint of a service with a single instance
+	Endpoints       []EndpointConfig     `mapstructure:"endpoints"` // the instances of a service with several
+	LoadBalancing   LoadBalancingConfig  `mapstructure:"load_balancing"`
+	HealthCheck     HealthCheckConfig    `mapstructure:"health_check"`
+	Timeout         int                  `mapstructure:"timeout"`          // in seconds the service has to start responding, defaults to 30
+	IdentityHeaders []string             `mapstructure:"identity_headers"` // identity headers forwarded to the service, defaults to all
+	ReservedHeaders []string             `mapstructure:"reserved_headers"` // further headers clients may not send to the service
+	Retry           RetryConfig          `mapstructure:"retry"`
+	CircuitBreaker  CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+}
+
+// DefaultServiceTimeout is the timeout in seconds of services that do not set one
+const DefaultServiceTimeout = 30
+
+// Authentication requirements of proxied routes
+const (
+	RouteAuthNone  = "none"  // no token needed
+	RouteAuthGuest = "guest" // user or anonymous guest session token
+	RouteAuthUser  = "user"  // user token
+)
+
+// RouteConfig declares a gateway route proxied to a downstream service
+type RouteConfig struct {
+	Method       string       `mapstructure:"method"`
+	Path         string       `mapstructure:"path"`          // gin pattern, e.g. /api/v1/orders/:id
+	Service      string       `mapstructure:"service"`       // key in services
+	UpstreamPath string       `mapstructure:"upstream_path"` // e.g. /orders/:id, filled in from the path parameters
+	Auth         string       `mapstructure:"auth"`          // none, guest or user
+	Roles        []string     `mapstructure:"roles"`         // any one of them is required
+	Scopes       []string     `mapstructure:"scopes"`        // all of them are required
+	Timeout      int          `mapstructure:"timeout"`       // in seconds, defaults to the service's timeout
+	Retry        *RetryConfig `mapstructure:"retry"`         // overrides fields of the service's retry policy
+}
+
+// ProxyConfig holds configuration of the proxy to downstream services
+type ProxyConfig struct {
+	TrustedProxies []string            `mapstructure:"trusted_proxies"` // IPs or CIDRs of load balancers in front of the gateway
+	WebSocket      WebSocketConfig     `mapstructure:"websocket"`
+	InternalToken  InternalTokenConfig `mapstructure:"internal_token"`
+}
+
+// WebSocketConfig holds configuration of proxied WebSocket connections
+type WebSocketConfig struct {
+	IdleTimeout           int `mapstructure:"idle_timeout"`             // in seconds, 0 for none
+	MaxConnectionsPerUser int `mapstructure:"max_connections_per_user"` // 0 for unlimited
+}
+
+// InternalTokenConfig holds the gateway-signed identity tokens attached to proxied requests
+type InternalTokenConfig struct {
+	Enabled           bool     `mapstructure:"enabled"`
+	PrivateKeyPath    string   `mapstructure:"private_key_path"`    // kept apart from the access token key
+	PublishedKeyPaths []string `mapstructure:"published_key_paths"` // public keys of retired signing keys, still published during rotation
+	Issuer            string   `mapstructure:"issuer"`
+	TTL               int      `mapstructure:"ttl"` // in seconds
 }
 
 // GinConfig holds Gin framework manual configured value
@@ -71,6 +127,120 @@ type PublicPrivateKey struct {
 	PublicKeyPath  string `mapstructure:"public_key_path"`
 }
 
+// AuthConfig holds authentication policy configuration
+type AuthConfig struct {
+	DeviceTokenTTL int                           `mapstructure:"device_token_ttl"` // in minutes
+	GuestTokenTTL  int                           `mapstructure:"guest_token_ttl"`  // in minutes
+	Pin            PinConfig                     `mapstructure:"pin"`
+	OIDC           map[string]OIDCProviderConfig `mapstructure:"oidc"` // keyed by provider name
+	SCIM           SCIMConfig                    `mapstructure:"scim"`
+	MagicLink      MagicLinkConfig               `mapstructure:"magic_link"`
+	Registration   RegistrationConfig            `mapstructure:"registration"`
+	Scopes         ScopesConfig                  `mapstructure:"scopes"`
+	SessionCookie  SessionCookieConfig           `mapstructure:"session_cookie"`
+	Sessions       SessionLimitConfig            `mapstructure:"sessions"`
+	AccessHours    AccessHoursConfig             `mapstructure:"access_hours"`
+}
+
+// PinConfig holds operator PIN quick-login configuration
+type PinConfig struct {
+	MaxAttempts       int `mapstructure:"max_attempts"`
+	DeviceMaxAttempts int `mapstructure:"device_max_attempts"` // across all operators on one kiosk device
+	LockoutMinutes    int `mapstructure:"lockout_minutes"`
+}
+
+// OIDCProviderConfig holds a federated OpenID Connect provider configuration
+type OIDCProviderConfig struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+	DefaultRole  string   `mapstructure:"default_role"` // role for users provisioned on first login
+}
+
+// MetricsConfig holds the metrics endpoint configuration
+type MetricsConfig struct {
+	BearerToken string `mapstructure:"bearer_token"` // the endpoint is disabled when empty
+}
+
+// SCIMConfig holds SCIM provisioning API configuration
+type SCIMConfig struct {
+	BearerToken string `mapstructure:"bearer_token"` // the API is disabled when empty
+}
+
+// MagicLinkConfig holds passwordless magic-link login configuration
+type MagicLinkConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	TTL          int    `mapstructure:"ttl"`           // in minutes
+	LinkURL      string `mapstructure:"link_url"`      // page that receives ?token= and calls the verify endpoint
+	SigningKey   string `mapstructure:"signing_key"`   // HMAC key for signing links
+	SecureCookie bool   `mapstructure:"secure_cookie"` // send the nonce cookie over HTTPS only
+	MaxPerEmail  int    `mapstructure:"max_per_email"` // link requests per email per hour, 0 for no limit
+	MaxPerIP     int    `mapstructure:"max_per_ip"`    // link requests per client IP per hour, 0 for no limit
+}
+
+// Registration modes for /api/v1/auth/register
+const (
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite_only"
+	RegistrationDisabled   = "disabled"
+)
+
+// RegistrationConfig holds self-registration configuration
+type RegistrationConfig struct {
+	Mode      string `mapstructure:"mode"`       // open, invite_only or disabled
+	InviteTTL int    `mapstructure:"invite_ttl"` // default invite lifetime in hours
+}
+
+// ScopesConfig holds the access token scopes each kind of subject may be granted
+type ScopesConfig struct {
+	Roles map[string][]string `mapstructure:"roles"` // keyed by user role, unlisted roles may get every scope
+	Guest []string            `mapstructure:"guest"` // scopes of anonymous guest sessions
+}
+
+// SessionCookieConfig holds the cookie session mode for browser clients
+type SessionCookieConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	Secure   bool   `mapstructure:"secure"`    // send the cookies over HTTPS only
+	SameSite string `mapstructure:"same_site"` // strict or lax
+	Domain   string `mapstructure:"domain"`    // defaults to the gateway host
+}
+
+// Policies for logins beyond the concurrent session limit
+const (
+	SessionPolicyReject      = "reject"
+	SessionPolicyEvictOldest = "evict_oldest"
+)
+
+// SessionLimitConfig holds the limit on concurrent sessions per user
+type SessionLimitConfig struct {
+	MaxPerUser int            `mapstructure:"max_per_user"` // 0 for unlimited
+	Roles      map[string]int `mapstructure:"roles"`        // keyed by user role, overrides max_per_user
+	Policy     string         `mapstructure:"policy"`       // reject or evict_oldest
+}
+
+// AccessHoursConfig holds the times of day users of each role may authenticate
+type AccessHoursConfig struct {
+	Timezone string                          `mapstructure:"timezone"` // for sessions without a store
+	Roles    map[string][]accesshours.Window `mapstructure:"roles"`    // keyed by user role, unlisted roles are not limited
+}
+
+// MailConfig holds outgoing mail configuration
+type MailConfig struct {
+	Driver string     `mapstructure:"driver"` // log or smtp, no mail is sent when empty
+	From   string     `mapstructure:"from"`
+	SMTP   SMTPConfig `mapstructure:"smtp"`
+}
+
+// SMTPConfig holds SMTP relay configuration
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
+
 // Load loads configuration from file and environment variables
 func Load() (*Config, error) {
 	// Determine config file based on environment variable
@@ -109,9 +279,91 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	// Service and route errors name the file to fix
+	source := viper.ConfigFileUsed()
+	if source == "" {
+		source = "environment " + configEnv
+	}
+	if err := validateServices(config.Services); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+	if err := validateRoutes(config.Routes, config.Services); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	if _, err := ParseTrustedProxies(config.Proxy.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid proxy.trusted_proxies: %w", err)
+	}
+	if config.Proxy.InternalToken.Enabled && config.Proxy.InternalToken.TTL <= 0 {
+		return nil, fmt.Errorf("proxy.internal_token.ttl must be positive")
+	}
+	if config.Proxy.WebSocket.IdleTimeout < 0 || config.Proxy.WebSocket.MaxConnectionsPerUser < 0 {
+		return nil, fmt.Errorf("proxy.websocket.idle_timeout and proxy.websocket.max_connections_per_user must not be negative")
+	}
+
+	switch config.Auth.Sessions.Policy {
+	case SessionPolicyReject, SessionPolicyEvictOldest:
+	default:
+		return nil, fmt.Errorf("invalid auth.sessions.policy %q, must be reject or evict_oldest", config.Auth.Sessions.Policy)
+	}
+
+	if _, err := time.LoadLocation(config.Auth.AccessHours.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid auth.access_hours.timezone %q: %w", config.Auth.AccessHours.Timezone, err)
+	}
+	for role, windows := range config.Auth.AccessHours.Roles {
+		if err := accesshours.ValidateAll(windows); err != nil {
+			return nil, fmt.Errorf("invalid auth.access_hours.roles.%s: %w", role, err)
+		}
+	}
+
+	switch config.Auth.Registration.Mode {
+	case RegistrationOpen, RegistrationInviteOnly, RegistrationDisabled:
+	default:
+		return nil, fmt.Errorf("invalid auth.registration.mode %q, must be open, invite_only or disabled", config.Auth.Registration.Mode)
+	}
+
+	if err := validateMail(&config); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
 
+// validateMail checks that the features that only reach users by mail have a
+// mail driver to send it with
+func validateMail(config *Config) error {
+	if config.Auth.MagicLink.Enabled && config.Mail.Driver == "" {
+		return fmt.Errorf("auth.magic_link.enabled requires mail.driver")
+	}
+	return nil
+}
+
+// ParseTrustedProxies parses a list of IP addresses and CIDR ranges
+func ParseTrustedProxies(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
 // setDefaults sets default configuration values
 func setDefaults() {
 	// Server defaults
@@ -128,10 +380,44 @@ func setDefaults() {
 	viper.SetDefault("database.dbname", "central_gateway_mini_kiosk")
 	viper.SetDefault("database.sslmode", "disable")
 
+	// Route defaults, services get theirs in validateServices
+	viper.SetDefault("routes", defaultRoutes)
+
+	// Proxy defaults
+	viper.SetDefault("proxy.websocket.idle_timeout", 300)
+	viper.SetDefault("proxy.websocket.max_connections_per_user", 5)
+	viper.SetDefault("proxy.internal_token.enabled", false)
+	viper.SetDefault("proxy.internal_token.private_key_path", "internalPrivateKey.pem")
+	viper.SetDefault("proxy.internal_token.issuer", "mini-kiosk-central-gateway")
+	viper.SetDefault("proxy.internal_token.ttl", 60)
+
 	// Gin defaults
 	viper.SetDefault("gin.mode", "debug")
 
 	// Key defaults
 	viper.SetDefault("keys.private_key_path", "privateKey.pem")
 	viper.SetDefault("keys.public_key_path", "publicKey.pem")
+
+	// Auth defaults
+	viper.SetDefault("auth.device_token_ttl", 720)
+	viper.SetDefault("auth.guest_token_ttl", 30)
+	viper.SetDefault("auth.pin.max_attempts", 5)
+	viper.SetDefault("auth.pin.device_max_attempts", 10)
+	viper.SetDefault("auth.pin.lockout_minutes", 15)
+	viper.SetDefault("auth.magic_link.enabled", false)
+	viper.SetDefault("auth.magic_link.ttl", 15)
+	viper.SetDefault("auth.magic_link.secure_cookie", true)
+	viper.SetDefault("auth.magic_link.max_per_email", 3)
+	viper.SetDefault("auth.magic_link.max_per_ip", 20)
+	viper.SetDefault("auth.registration.mode", RegistrationOpen)
+	viper.SetDefault("auth.registration.invite_ttl", 72)
+	viper.SetDefault("auth.session_cookie.enabled", false)
+	viper.SetDefault("auth.session_cookie.secure", true)
+	viper.SetDefault("auth.session_cookie.same_site", "strict")
+	viper.SetDefault("auth.sessions.max_per_user", 0)
+	viper.SetDefault("auth.sessions.policy", SessionPolicyEvictOldest)
+	viper.SetDefault("auth.access_hours.timezone", "UTC")
+
+	// Mail defaults
+	viper.SetDefault("mail.smtp.port", 587)
 }
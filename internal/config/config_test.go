@@ -0,0 +1,175 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.10", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("Expected valid entries, got %v", err)
+	}
+
+	want := []string{"10.0.0.0/8", "192.0.2.10/32", "2001:db8::1/128"}
+	for i, ipNet := range nets {
+		if ipNet.String() != want[i] {
+			t.Errorf("Expected %s, got %s", want[i], ipNet)
+		}
+	}
+
+	for _, entry := range []string{"", "10.0.0.0/33", "gateway.local"} {
+		if _, err := ParseTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("Expected %q to be rejected", entry)
+		}
+	}
+}
+
+func TestValidateServices_Defaults(t *testing.T) {
+	services := ServicesConfig{
+		"loyalty_service": {BaseURL: "http://loyalty:8080"},
+		"payment_service": {BaseURL: "http://payments:8080", Timeout: 10, Retry: RetryConfig{MaxAttempts: 1, Budget: RetryBudgetConfig{Percent: 5}}},
+	}
+	if err := validateServices(services); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := services["loyalty_service"].Timeout; got != DefaultServiceTimeout {
+		t.Errorf("Expected the default timeout %d, got %d", DefaultServiceTimeout, got)
+	}
+	if got := services["payment_service"].Timeout; got != 10 {
+		t.Errorf("Expected the configured timeout 10, got %d", got)
+	}
+
+	if got := services["loyalty_service"].Retry; !reflect.DeepEqual(got, defaultRetry) {
+		t.Errorf("Expected the default retry policy, got %+v", got)
+	}
+	retry := services["payment_service"].Retry
+	if retry.MaxAttempts != 1 || retry.Budget.Percent != 5 {
+		t.Errorf("Expected the configured retry settings kept, got %+v", retry)
+	}
+	if retry.Backoff != defaultRetry.Backoff || retry.Budget.MinPerSecond != defaultRetry.Budget.MinPerSecond {
+		t.Errorf("Expected the unset retry settings defaulted, got %+v", retry)
+	}
+
+	if got := services["loyalty_service"].CircuitBreaker; got != defaultCircuitBreaker {
+		t.Errorf("Expected the default circuit breaker, got %+v", got)
+	}
+}
+
+func TestValidateServices(t *testing.T) {
+	tests := []struct {
+		name    string
+		service ServiceConfig
+		wantErr bool
+	}{
+		{"defaults", ServiceConfig{}, false},
+		{"identity headers", ServiceConfig{IdentityHeaders: []string{"X-User-ID", "x-tenant-id"}}, false},
+		{"reserved headers", ServiceConfig{ReservedHeaders: []string{"X-Payment-Signature"}}, false},
+		{"unknown identity header", ServiceConfig{IdentityHeaders: []string{"X-Payment-Signature"}}, true},
+		{"identity header reserved", ServiceConfig{ReservedHeaders: []string{"X-User-ID"}}, true},
+		{"negative timeout", ServiceConfig{Timeout: -1}, true},
+		{"retry policy", ServiceConfig{Retry: RetryConfig{MaxAttempts: 3, StatusCodes: []int{429, 503}, Methods: []string{"get"}}}, false},
+		{"negative max attempts", ServiceConfig{Retry: RetryConfig{MaxAttempts: -1}}, true},
+		{"max backoff below backoff", ServiceConfig{Retry: RetryConfig{Backoff: 500, MaxBackoff: 100}}, true},
+		{"retry on success status", ServiceConfig{Retry: RetryConfig{StatusCodes: []int{200}}}, true},
+		{"unknown retry error", ServiceConfig{Retry: RetryConfig{Errors: []string{"timeout"}}}, true},
+		{"unknown retry method", ServiceConfig{Retry: RetryConfig{Methods: []string{"FETCH"}}}, true},
+		{"retry budget over 100 percent", ServiceConfig{Retry: RetryConfig{Budget: RetryBudgetConfig{Percent: 150}}}, true},
+		{"circuit breaker", ServiceConfig{CircuitBreaker: CircuitBreakerConfig{FailureRate: 25, OpenDuration: 10}}, false},
+		{"failure rate over 100 percent", ServiceConfig{CircuitBreaker: CircuitBreakerConfig{FailureRate: 101}}, true},
+		{"negative open duration", ServiceConfig{CircuitBreaker: CircuitBreakerConfig{OpenDuration: -1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := tt.service
+			service.BaseURL = "http://payments:8080"
+			err := validateServices(ServicesConfig{"payment_service": service})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateMail(t *testing.T) {
+	tests := []struct {
+		name      string
+		magicLink bool
+		driver    string
+		wantErr   bool
+	}{
+		{"no mail features", false, "", false},
+		{"magic link with smtp", true, "smtp", false},
+		{"magic link with log", true, "log", false},
+		{"magic link without a driver", true, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{}
+			cfg.Auth.MagicLink.Enabled = tt.magicLink
+			cfg.Mail.Driver = tt.driver
+			if err := validateMail(cfg); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoad_ConfigFiles(t *testing.T) {
+	files, err := filepath.Glob("../../configs/*.config.yaml")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("Expected config files, got %v (%v)", files, err)
+	}
+	t.Cleanup(viper.Reset)
+
+	for _, file := range files {
+		env := strings.TrimSuffix(filepath.Base(file), ".config.yaml")
+		t.Run(env, func(t *testing.T) {
+			viper.Reset()
+			t.Setenv("GATEWAY_CONFIG_ENV", env)
+
+			cfg, err := Load()
+			if err != nil {
+				t.Fatalf("Failed to load %s: %v", file, err)
+			}
+			for _, route := range cfg.Routes {
+				if len(cfg.Services[route.Service].Endpoints) == 0 {
+					t.Errorf("Expected %s to have endpoints for %s %s", route.Service, route.Method, route.Path)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad_NamesFileOfMissingService(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "configs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "configs", "broken.config.yaml")
+	if err := os.WriteFile(file, []byte("services:\n  auth_service:\n    base_url: \"http://auth:8080\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("GATEWAY_CONFIG_ENV", "broken")
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+
+	_, err := Load()
+	if err == nil {
+		t.Fatal("Expected the default routes to need their services")
+	}
+	for _, want := range []string{"broken.config.yaml", "services.order_service"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to name %s, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// IdentityHeaders are the headers the gateway sets from verified token claims
+// for downstream services, and the internal token that carries the claims
+// signed. They are a reserved namespace: the gateway removes them from every
+// incoming request, so a service can trust them on any route.
+var IdentityHeaders = []string{
+	"X-Subject-Type",
+	"X-User-ID",
+	"X-User-Email",
+	"X-User-Name",
+	"X-User-Role",
+	"X-Guest-ID",
+	"X-Device-ID",
+	"X-Tenant-ID",
+	"X-Store-ID",
+	"X-Scope",
+	"X-Gateway-Token",
+}
+
+// IsIdentityHeader reports whether name is one of the identity headers
+func IsIdentityHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return slices.ContainsFunc(IdentityHeaders, func(header string) bool {
+		return http.CanonicalHeaderKey(header) == name
+	})
+}
+
+// validateServices checks the endpoints, timeouts, retry policies, circuit
+// breakers and header settings of the downstream services, and fills in the
+// defaults of unset ones
+func validateServices(services ServicesConfig) error {
+	for name, service := range services {
+		if err := validateEndpoints(name, &service); err != nil {
+			return err
+		}
+		if service.Timeout < 0 {
+			return fmt.Errorf("services.%s.timeout must not be negative", name)
+		}
+		if service.Timeout == 0 {
+			service.Timeout = DefaultServiceTimeout
+		}
+		if err := validateRetry("services."+name+".retry", &service.Retry); err != nil {
+			return err
+		}
+		service.Retry = service.Retry.withDefaults()
+		if err := validateCircuitBreaker("services."+name+".circuit_breaker", service.CircuitBreaker); err != nil {
+			return err
+		}
+		service.CircuitBreaker = service.CircuitBreaker.withDefaults()
+		services[name] = service
+
+		for _, header := range service.IdentityHeaders {
+			if !IsIdentityHeader(header) {
+				return fmt.Errorf("services.%s.identity_headers: %q is not an identity header", name, header)
+			}
+		}
+		for _, header := range service.ReservedHeaders {
+			if IsIdentityHeader(header) {
+				return fmt.Errorf("services.%s.reserved_headers: %q is an identity header, which is always reserved", name, header)
+			}
+		}
+	}
+	return nil
+}
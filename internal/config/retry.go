@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Upstream errors a request can be retried on
+const (
+	RetryOnConnect = "connect" // the connection to the service could not be opened
+	RetryOnReset   = "reset"   // the connection closed or reset before a response
+)
+
+// RetryConfig holds the retry policy of a service or route
+type RetryConfig struct {
+	MaxAttempts int               `mapstructure:"max_attempts"` // including the first, 1 disables retries, defaults to 2
+	Backoff     int               `mapstructure:"backoff"`      // in milliseconds before the first retry, doubled for each further one, defaults to 100
+	MaxBackoff  int               `mapstructure:"max_backoff"`  // in milliseconds, defaults to 1000
+	StatusCodes []int             `mapstructure:"status_codes"` // defaults to 502, 503 and 504
+	Errors      []string          `mapstructure:"errors"`       // connect or reset, defaults to both
+	Methods     []string          `mapstructure:"methods"`      // retried without an Idempotency-Key, defaults to the idempotent ones
+	Budget      RetryBudgetConfig `mapstructure:"budget"`       // per service only
+}
+
+// RetryBudgetConfig limits the retries to a service so they cannot amplify an outage
+type RetryBudgetConfig struct {
+	Percent      int `mapstructure:"percent"`        // retries as a percentage of requests over the last 10 seconds, defaults to 20
+	MinPerSecond int `mapstructure:"min_per_second"` // retries allowed regardless of the percentage, defaults to 3
+}
+
+// defaultRetry is the retry policy of services that do not set one
+var defaultRetry = RetryConfig{
+	MaxAttempts: 2,
+	Backoff:     100,
+	MaxBackoff:  1000,
+	StatusCodes: []int{502, 503, 504},
+	Errors:      []string{RetryOnConnect, RetryOnReset},
+	Methods:     []string{"GET", "HEAD", "OPTIONS", "PUT", "DELETE"},
+	Budget:      RetryBudgetConfig{Percent: 20, MinPerSecond: 3},
+}
+
+// Override returns the policy with the fields set in override replaced, for a
+// route's policy on top of its service's. The budget stays the service's.
+func (r RetryConfig) Override(override *RetryConfig) RetryConfig {
+	if override == nil {
+		return r
+	}
+	if override.MaxAttempts != 0 {
+		r.MaxAttempts = override.MaxAttempts
+	}
+	if override.Backoff != 0 {
+		r.Backoff = override.Backoff
+	}
+	if override.MaxBackoff != 0 {
+		r.MaxBackoff = override.MaxBackoff
+	}
+	if override.StatusCodes != nil {
+		r.StatusCodes = override.StatusCodes
+	}
+	if override.Errors != nil {
+		r.Errors = override.Errors
+	}
+	if override.Methods != nil {
+		r.Methods = override.Methods
+	}
+	return r
+}
+
+// withDefaults fills in the unset fields of a service's retry policy
+func (r RetryConfig) withDefaults() RetryConfig {
+	budget := r.Budget
+	r = defaultRetry.Override(&r)
+	if budget.Percent != 0 {
+		r.Budget.Percent = budget.Percent
+	}
+	if budget.MinPerSecond != 0 {
+		r.Budget.MinPerSecond = budget.MinPerSecond
+	}
+	return r
+}
+
+// validateRetry checks a retry policy and normalizes its methods
+func validateRetry(name string, retry *RetryConfig) error {
+	if retry.MaxAttempts < 0 || retry.Backoff < 0 || retry.MaxBackoff < 0 {
+		return fmt.Errorf("%s: max_attempts, backoff and max_backoff must not be negative", name)
+	}
+	if retry.MaxBackoff != 0 && retry.MaxBackoff < retry.Backoff {
+		return fmt.Errorf("%s: max_backoff must not be less than backoff", name)
+	}
+	for _, code := range retry.StatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("%s: status code %d is not an error status", name, code)
+		}
+	}
+	for _, kind := range retry.Errors {
+		if kind != RetryOnConnect && kind != RetryOnReset {
+			return fmt.Errorf("%s: invalid error %q, must be connect or reset", name, kind)
+		}
+	}
+	for i, method := range retry.Methods {
+		retry.Methods[i] = strings.ToUpper(method)
+		if !routeMethods[retry.Methods[i]] {
+			return fmt.Errorf("%s: invalid method %q", name, method)
+		}
+	}
+	if retry.Budget.Percent < 0 || retry.Budget.Percent > 100 || retry.Budget.MinPerSecond < 0 {
+		return fmt.Errorf("%s: budget.percent must be between 0 and 100 and budget.min_per_second must not be negative", name)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetryConfig_Override(t *testing.T) {
+	service := defaultRetry
+
+	if got := service.Override(nil); !reflect.DeepEqual(got, service) {
+		t.Errorf("Expected the service's policy without a route policy, got %+v", got)
+	}
+
+	got := service.Override(&RetryConfig{MaxAttempts: 4, StatusCodes: []int{429}})
+	want := service
+	want.MaxAttempts = 4
+	want.StatusCodes = []int{429}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultRoutes is the route table used when the configuration declares none
+var defaultRoutes = []RouteConfig{
+	// Guests may browse and place orders but not change or cancel them
+	{Method: "GET", Path: "/api/v1/orders/", Service: "order_service", UpstreamPath: "/orders/", Auth: RouteAuthGuest, Scopes: []string{"orders:read"}},
+	{Method: "POST", Path: "/api/v1/orders/", Service: "order_service", UpstreamPath: "/orders/", Auth: RouteAuthGuest, Scopes: []string{"orders:write"}},
+	{Method: "GET", Path: "/api/v1/orders/:id", Service: "order_service", UpstreamPath: "/orders/:id", Auth: RouteAuthGuest, Scopes: []string{"orders:read"}},
+	{Method: "PUT", Path: "/api/v1/orders/:id", Service: "order_service", UpstreamPath: "/orders/:id", Auth: RouteAuthUser, Scopes: []string{"orders:write"}},
+	{Method: "DELETE", Path: "/api/v1/orders/:id", Service: "order_service", UpstreamPath: "/orders/:id", Auth: RouteAuthUser, Scopes: []string{"orders:write"}},
+
+	// Guests may browse the catalog but not change it
+	{Method: "GET", Path: "/api/v1/inventory/", Service: "inventory_service", UpstreamPath: "/inventory/", Auth: RouteAuthGuest, Scopes: []string{"inventory:read"}},
+	{Method: "GET", Path: "/api/v1/inventory/:id", Service: "inventory_service", UpstreamPath: "/inventory/:id", Auth: RouteAuthGuest, Scopes: []string{"inventory:read"}},
+	{Method: "PUT", Path: "/api/v1/inventory/:id", Service: "inventory_service", UpstreamPath: "/inventory/:id", Auth: RouteAuthUser, Scopes: []string{"inventory:write"}},
+
+	// Guests may pay for their orders but only users can refund
+	{Method: "POST", Path: "/api/v1/payments/", Service: "payment_service", UpstreamPath: "/payments/", Auth: RouteAuthGuest, Scopes: []string{"payments:write"}},
+	{Method: "GET", Path: "/api/v1/payments/:id", Service: "payment_service", UpstreamPath: "/payments/:id", Auth: RouteAuthGuest, Scopes: []string{"payments:read"}},
+	{Method: "POST", Path: "/api/v1/payments/:id/refund", Service: "payment_service", UpstreamPath: "/payments/:id/refund", Auth: RouteAuthUser, Scopes: []string{"payments:refund"}},
+}
+
+// DefaultRoutes returns a copy of the route table used when the configuration declares none
+func DefaultRoutes() []RouteConfig {
+	routes := make([]RouteConfig, len(defaultRoutes))
+	copy(routes, defaultRoutes)
+	return routes
+}
+
+// routeMethods are the HTTP methods routes may be declared for
+var routeMethods = map[string]bool{
+	"GET":     true,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+	"DELETE":  true,
+	"HEAD":    true,
+	"OPTIONS": true,
+}
+
+// validateRoutes normalizes the route table and checks every route against
+// the configured services. Routes without an auth requirement need a user token.
+func validateRoutes(routes []RouteConfig, services ServicesConfig) error {
+	for i := range routes {
+		route := &routes[i]
+		route.Method = strings.ToUpper(route.Method)
+		if route.Auth == "" {
+			route.Auth = RouteAuthUser
+		}
+
+		name := fmt.Sprintf("routes[%d] (%s %s)", i, route.Method, route.Path)
+		if !routeMethods[route.Method] {
+			return fmt.Errorf("%s: invalid method", name)
+		}
+		if !strings.HasPrefix(route.Path, "/") {
+			return fmt.Errorf("%s: path must start with /", name)
+		}
+		if _, ok := services[route.Service]; !ok {
+			return fmt.Errorf("%s: unknown service %q, services.%s is not configured", name, route.Service, route.Service)
+		}
+		if !strings.HasPrefix(route.UpstreamPath, "/") {
+			return fmt.Errorf("%s: upstream_path must start with /", name)
+		}
+		if route.Timeout < 0 {
+			return fmt.Errorf("%s: timeout must not be negative", name)
+		}
+		if route.Retry != nil {
+			if route.Retry.Budget != (RetryBudgetConfig{}) {
+				return fmt.Errorf("%s: retry budget can only be set per service", name)
+			}
+			if err := validateRetry(name+" retry", route.Retry); err != nil {
+				return err
+			}
+		}
+
+		switch route.Auth {
+		case RouteAuthGuest, RouteAuthUser:
+		case RouteAuthNone:
+			if len(route.Roles) > 0 || len(route.Scopes) > 0 {
+				return fmt.Errorf("%s: roles and scopes need auth guest or user", name)
+			}
+		default:
+			return fmt.Errorf("%s: invalid auth %q, must be none, guest or user", name, route.Auth)
+		}
+
+		params := pathParams(route.Path)
+		for param := range pathParams(route.UpstreamPath) {
+			if !params[param] {
+				return fmt.Errorf("%s: upstream_path parameter %q is not in the path", name, param)
+			}
+		}
+	}
+	return nil
+}
+
+// pathParams returns the names of the :name and *name parameters in a path pattern
+func pathParams(pattern string) map[string]bool {
+	params := make(map[string]bool)
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			params[segment[1:]] = true
+		}
+	}
+	return params
+}
@@ -0,0 +1,53 @@
+package config
+
+import "testing"
+
+func TestValidateRoutes(t *testing.T) {
+	services := ServicesConfig{"order_service": {BaseURL: "http://orders"}}
+	valid := RouteConfig{Method: "GET", Path: "/api/v1/orders/:id", Service: "order_service", UpstreamPath: "/orders/:id", Auth: RouteAuthGuest}
+
+	tests := []struct {
+		name    string
+		change  func(*RouteConfig)
+		wantErr bool
+	}{
+		{"valid", func(r *RouteConfig) {}, false},
+		{"lowercase method", func(r *RouteConfig) { r.Method = "get" }, false},
+		{"invalid method", func(r *RouteConfig) { r.Method = "FETCH" }, true},
+		{"relative path", func(r *RouteConfig) { r.Path = "api/v1/orders/:id" }, true},
+		{"unknown service", func(r *RouteConfig) { r.Service = "loyalty_service" }, true},
+		{"relative upstream path", func(r *RouteConfig) { r.UpstreamPath = "orders/:id" }, true},
+		{"unknown upstream parameter", func(r *RouteConfig) { r.UpstreamPath = "/orders/:order_id" }, true},
+		{"invalid auth", func(r *RouteConfig) { r.Auth = "device" }, true},
+		{"negative timeout", func(r *RouteConfig) { r.Timeout = -1 }, true},
+		{"retry policy", func(r *RouteConfig) { r.Retry = &RetryConfig{MaxAttempts: 1} }, false},
+		{"invalid retry policy", func(r *RouteConfig) { r.Retry = &RetryConfig{Errors: []string{"timeout"}} }, true},
+		{"retry budget", func(r *RouteConfig) { r.Retry = &RetryConfig{Budget: RetryBudgetConfig{Percent: 50}} }, true},
+		{"scopes without auth", func(r *RouteConfig) { r.Auth = RouteAuthNone; r.Scopes = []string{"orders:read"} }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := valid
+			tt.change(&route)
+			if err := validateRoutes([]RouteConfig{route}, services); (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateRoutes_Defaults(t *testing.T) {
+	routes := []RouteConfig{{Method: "get", Path: "/api/v1/orders/", Service: "order_service", UpstreamPath: "/orders/"}}
+	if err := validateRoutes(routes, ServicesConfig{"order_service": {}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if routes[0].Method != "GET" || routes[0].Auth != RouteAuthUser {
+		t.Errorf("Expected the method to be normalized and auth to default to user, got %+v", routes[0])
+	}
+
+	services := ServicesConfig{"order_service": {}, "inventory_service": {}, "payment_service": {}}
+	if err := validateRoutes(DefaultRoutes(), services); err != nil {
+		t.Errorf("Expected the default route table to be valid, got %v", err)
+	}
+}
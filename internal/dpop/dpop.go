@@ -0,0 +1,292 @@
+// Package dpop verifies DPoP proofs (RFC 9449), which let a client prove it holds
+// the private key its tokens are bound to.
+package dpop
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// HeaderName is the request header that carries the proof
+const HeaderName = "DPoP"
+
+// proofType is the typ header every proof must carry
+const proofType = "dpop+jwt"
+
+// Proofs are accepted for proofMaxAge after they are issued, and up to
+// proofClockSkew before, to allow for clients with a slightly fast clock
+const (
+	proofMaxAge    = 60 * time.Second
+	proofClockSkew = 5 * time.Second
+)
+
+// supportedAlgorithms lists the asymmetric algorithms proofs may be signed with
+var supportedAlgorithms = []string{"ES256", "ES384", "ES512", "RS256", "PS256"}
+
+// ErrReplayed is returned for a proof whose jti has been seen before
+var ErrReplayed = errors.New("dpop proof has already been used")
+
+// defaultReplayCache remembers the proofs seen by this gateway instance
+var defaultReplayCache = NewReplayCache()
+
+// Proof is a verified DPoP proof
+type Proof struct {
+	JKT      string // thumbprint of the key that signed the proof
+	ID       string // the proof's jti
+	IssuedAt time.Time
+}
+
+// proofClaims are the claims of a DPoP proof JWT
+type proofClaims struct {
+	Method      string `json:"htm"`
+	URL         string `json:"htu"`
+	AccessToken string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Verify checks a proof sent with a request to method and requestURL. When the
+// request carries an access token, the proof must be bound to it through ath.
+// Each proof is only accepted once.
+func Verify(proof, method, requestURL, accessToken string) (*Proof, error) {
+	return verify(proof, method, requestURL, accessToken, time.Now(), defaultReplayCache)
+}
+
+// verify is Verify with the clock and replay cache passed in
+func verify(proof, method, requestURL, accessToken string, now time.Time, replays *ReplayCache) (*Proof, error) {
+	var key *JWK
+	claims := &proofClaims{}
+	_, err := jwt.ParseWithClaims(proof, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, proofType) {
+			return nil, fmt.Errorf("unexpected proof type %q", typ)
+		}
+
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		key = &JWK{}
+		if err := json.Unmarshal(raw, key); err != nil {
+			return nil, fmt.Errorf("invalid jwk header: %w", err)
+		}
+		return key.PublicKey()
+	},
+		jwt.WithValidMethods(supportedAlgorithms),
+		jwt.WithoutClaimsValidation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dpop proof: %w", err)
+	}
+
+	if claims.ID == "" {
+		return nil, errors.New("dpop proof has no jti")
+	}
+	if claims.IssuedAt == nil {
+		return nil, errors.New("dpop proof has no iat")
+	}
+	issuedAt := claims.IssuedAt.Time
+	if issuedAt.Before(now.Add(-proofMaxAge)) || issuedAt.After(now.Add(proofClockSkew)) {
+		return nil, errors.New("dpop proof is too old or from the future")
+	}
+
+	if claims.Method != method {
+		return nil, fmt.Errorf("dpop proof is for method %q, not %q", claims.Method, method)
+	}
+	if !sameURL(claims.URL, requestURL) {
+		return nil, fmt.Errorf("dpop proof is for %q, not %q", claims.URL, requestURL)
+	}
+
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if claims.AccessToken != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, errors.New("dpop proof is not bound to the access token")
+		}
+	}
+
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for replays last, so a rejected proof does not use up its jti
+	if !replays.Add(jkt+":"+claims.ID, issuedAt.Add(proofMaxAge+proofClockSkew)) {
+		return nil, ErrReplayed
+	}
+
+	return &Proof{JKT: jkt, ID: claims.ID, IssuedAt: issuedAt}, nil
+}
+
+// trustedProxiesKey is the context key of the proxies whose X-Forwarded-Proto
+// RequestURL believes
+type trustedProxiesKey struct{}
+
+// WithTrustedProxies returns a copy of ctx in which RequestURL takes the scheme
+// from X-Forwarded-Proto on requests that come from one of the proxies
+func WithTrustedProxies(ctx context.Context, proxies []*net.IPNet) context.Context {
+	return context.WithValue(ctx, trustedProxiesKey{}, proxies)
+}
+
+// RequestURL returns the URL a proof for the request must carry in htu: the
+// scheme, host and path, without query or fragment. Behind a proxy that
+// terminates TLS the scheme is the one the proxy reports, as long as the proxy
+// is trusted through WithTrustedProxies. Clients could send the header
+// themselves.
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || (fromTrustedProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")) {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// fromTrustedProxy reports whether the request came straight from a trusted proxy
+func fromTrustedProxy(r *http.Request) bool {
+	proxies, _ := r.Context().Value(trustedProxiesKey{}).([]*net.IPNet)
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	ip := net.ParseIP(peer)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range proxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameURL compares two URLs the way RFC 9449 asks: ignoring query and
+// fragment, with case-insensitive scheme and host and default ports dropped
+func sameURL(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return normalizedOrigin(ua) == normalizedOrigin(ub) && ua.EscapedPath() == ub.EscapedPath()
+}
+
+// normalizedOrigin returns the lower-cased scheme and host, without a default port
+func normalizedOrigin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (scheme == "http" && port == "80") || (scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	return scheme + "://" + host
+}
+
+// JWK is the public key embedded in a proof header
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+// PublicKey decodes the key. Keys with private parts are rejected, since a
+// client that sends its private key has not kept it to itself.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	if k.D != "" {
+		return nil, errors.New("jwk must not contain a private key")
+	}
+
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("jwk point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("rsa jwk must be at least 2048 bits")
+		}
+		if !e.IsInt64() || e.Int64() < 3 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the key, the value
+// tokens carry in cnf.jkt
+func (k *JWK) Thumbprint() (string, error) {
+	// The required members, in lexicographic order and without whitespace
+	var members string
+	switch k.Kty {
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// decodeBigInt decodes a base64url encoded unsigned integer
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid jwk integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
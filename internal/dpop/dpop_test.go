@@ -0,0 +1,200 @@
+package dpop
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testURL = "https://gateway.example.com/api/v1/auth/refresh"
+
+// signTestProof signs a proof with the key, letting the test change the claims and headers
+func signTestProof(t *testing.T, key *ecdsa.PrivateKey, claims jwt.MapClaims, header map[string]interface{}) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	for name, value := range header {
+		token.Header[name] = value
+	}
+
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign proof: %v", err)
+	}
+	return proof
+}
+
+func newTestProofClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"jti": "proof-id",
+		"htm": "POST",
+		"htu": testURL,
+		"iat": now.Unix(),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+
+	proof := signTestProof(t, key, newTestProofClaims(now), nil)
+	verified, err := verify(proof, "POST", "https://Gateway.example.com:443/api/v1/auth/refresh?x=1", "", now, NewReplayCache())
+	if err != nil {
+		t.Fatalf("Expected proof to verify, got %v", err)
+	}
+	if verified.JKT == "" || verified.ID != "proof-id" {
+		t.Errorf("Unexpected proof %+v", verified)
+	}
+}
+
+func TestVerify_Replay(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	replays := NewReplayCache()
+
+	proof := signTestProof(t, key, newTestProofClaims(now), nil)
+	if _, err := verify(proof, "POST", testURL, "", now, replays); err != nil {
+		t.Fatalf("Expected first use to verify, got %v", err)
+	}
+	if _, err := verify(proof, "POST", testURL, "", now, replays); err != ErrReplayed {
+		t.Errorf("Expected ErrReplayed, got %v", err)
+	}
+}
+
+func TestVerify_AccessTokenHash(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+
+	sum := sha256.Sum256([]byte("access-token"))
+	claims := newTestProofClaims(now)
+	claims["ath"] = base64.RawURLEncoding.EncodeToString(sum[:])
+	proof := signTestProof(t, key, claims, nil)
+
+	if _, err := verify(proof, "POST", testURL, "other-token", now, NewReplayCache()); err == nil {
+		t.Error("Expected proof for another access token to be rejected")
+	}
+	if _, err := verify(proof, "POST", testURL, "access-token", now, NewReplayCache()); err != nil {
+		t.Errorf("Expected proof to verify, got %v", err)
+	}
+}
+
+func TestVerify_Rejected(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims func(jwt.MapClaims)
+		header map[string]interface{}
+		method string
+		url    string
+	}{
+		{name: "wrong method", method: "GET"},
+		{name: "wrong url", url: "https://gateway.example.com/api/v1/auth/login"},
+		{name: "wrong scheme", url: "http://gateway.example.com/api/v1/auth/refresh"},
+		{name: "too old", claims: func(c jwt.MapClaims) { c["iat"] = now.Add(-2 * time.Minute).Unix() }},
+		{name: "from the future", claims: func(c jwt.MapClaims) { c["iat"] = now.Add(time.Minute).Unix() }},
+		{name: "missing jti", claims: func(c jwt.MapClaims) { delete(c, "jti") }},
+		{name: "missing iat", claims: func(c jwt.MapClaims) { delete(c, "iat") }},
+		{name: "wrong type", header: map[string]interface{}{"typ": "JWT"}},
+		{name: "private key in jwk", header: map[string]interface{}{"jwk": map[string]string{
+			"kty": "EC", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			"d": base64.RawURLEncoding.EncodeToString(key.D.FillBytes(make([]byte, 32))),
+		}}},
+		{name: "jwk of another key", header: map[string]interface{}{"jwk": map[string]string{
+			"kty": "EC", "crv": "P-256",
+			"x": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			"y": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := newTestProofClaims(now)
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+			method, url := "POST", testURL
+			if tt.method != "" {
+				method = tt.method
+			}
+			if tt.url != "" {
+				url = tt.url
+			}
+
+			proof := signTestProof(t, key, claims, tt.header)
+			if _, err := verify(proof, method, url, "", now, NewReplayCache()); err == nil {
+				t.Error("Expected proof to be rejected")
+			}
+		})
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// Example key and thumbprint from RFC 7638, section 3.1
+	key := &JWK{
+		Kty: "RSA",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		E:   "AQAB",
+	}
+
+	jkt, err := key.Thumbprint()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if jkt != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("Unexpected thumbprint %s", jkt)
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		proto      string
+		trusted    bool
+		want       string
+	}{
+		{"plain request", "203.0.113.7:4000", "", true, "http://gateway.example.com/api/v1/auth/refresh"},
+		{"trusted proxy", "10.0.0.2:4000", "https", true, testURL},
+		{"client sends the header", "203.0.113.7:4000", "https", true, "http://gateway.example.com/api/v1/auth/refresh"},
+		{"no trusted proxies", "10.0.0.2:4000", "https", false, "http://gateway.example.com/api/v1/auth/refresh"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://gateway.example.com/api/v1/auth/refresh?x=1", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			if tt.trusted {
+				r = r.WithContext(WithTrustedProxies(context.Background(), []*net.IPNet{proxies}))
+			}
+
+			if got := RequestURL(r); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
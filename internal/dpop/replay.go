@@ -0,0 +1,51 @@
+package dpop
+
+import (
+	"sync"
+	"time"
+)
+
+// ReplayCache remembers proof IDs until the proofs would have expired anyway,
+// so each proof is only accepted once. It only covers a single gateway
+// instance; the short proof lifetime limits what a replay on another instance
+// could do.
+type ReplayCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewReplayCache creates an empty replay cache
+func NewReplayCache() *ReplayCache {
+	return &ReplayCache{
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Add records the ID until expiresAt. It returns false if the ID was already
+// recorded and has not expired.
+func (r *ReplayCache) Add(id string, expiresAt time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastPrune) > proofMaxAge {
+		r.prune(now)
+	}
+
+	if seenUntil, ok := r.seen[id]; ok && seenUntil.After(now) {
+		return false
+	}
+	r.seen[id] = expiresAt
+	return true
+}
+
+// prune drops expired IDs. The caller must hold the lock.
+func (r *ReplayCache) prune(now time.Time) {
+	for id, expiresAt := range r.seen {
+		if !expiresAt.After(now) {
+			delete(r.seen, id)
+		}
+	}
+	r.lastPrune = now
+}
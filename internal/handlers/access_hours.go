@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/accesshours"
+)
+
+// ErrorCodeOutsideAccessHours is the error code of authentication rejected
+// outside the access hours of the user's role or store
+const ErrorCodeOutsideAccessHours = "outside_access_hours"
+
+// errOutsideAccessHours is returned when a user authenticates outside the access hours
+var errOutsideAccessHours = errors.New("outside access hours")
+
+// StoreAccessHoursRequest represents the request body for setting the access
+// hours of a store. Null windows remove the limit.
+type StoreAccessHoursRequest struct {
+	Timezone string               `json:"timezone" binding:"required"`
+	Windows  []accesshours.Window `json:"windows"`
+}
+
+// accessUntil returns when access ends for a user with the role working in the
+// store, or nil when the user is not limited. Outside the access hours it
+// returns errOutsideAccessHours. Admins are never limited.
+func (h *AuthHandler) accessUntil(role, storeID string, now time.Time) (*jwt.NumericDate, error) {
+	if role == RoleAdmin {
+		return nil, nil
+	}
+
+	roleWindows := h.config.Auth.AccessHours.Roles[role]
+	timezone := h.config.Auth.AccessHours.Timezone
+
+	var storeWindows []accesshours.Window
+	if storeID != "" {
+		var storeTimezone string
+		var raw []byte
+		err := h.db.QueryRow(`SELECT timezone, access_hours FROM "store" WHERE id = $1`, storeID).Scan(&storeTimezone, &raw)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if err == nil {
+			timezone = storeTimezone
+			if raw != nil {
+				if err := json.Unmarshal(raw, &storeWindows); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if roleWindows == nil && storeWindows == nil {
+		return nil, nil
+	}
+
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, err
+	}
+	until, err := accessWindowsUntil(now.In(location), roleWindows, storeWindows)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.NewNumericDate(until), nil
+}
+
+// accessWindowsUntil returns when access ends at local, which has to be within
+// every set of windows. A nil set does not limit access.
+func accessWindowsUntil(local time.Time, windowSets ...[]accesshours.Window) (time.Time, error) {
+	var until time.Time
+	for _, windows := range windowSets {
+		if windows == nil {
+			continue
+		}
+		end, ok := accesshours.Until(windows, local)
+		if !ok {
+			return time.Time{}, errOutsideAccessHours
+		}
+		if until.IsZero() || end.Before(until) {
+			until = end
+		}
+	}
+	return until, nil
+}
+
+// abortOutsideAccessHours rejects authentication outside the access hours
+func abortOutsideAccessHours(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "Outside allowed access hours",
+		"code":  ErrorCodeOutsideAccessHours,
+	})
+}
+
+// SetStoreAccessHours sets the timezone and access hours of a store
+func (h *TenantHandler) SetStoreAccessHours(c *gin.Context) {
+	storeID := c.Param("id")
+	if _, err := uuid.Parse(storeID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+
+	var req StoreAccessHoursRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+	if _, err := time.LoadLocation(req.Timezone); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timezone"})
+		return
+	}
+	if err := accesshours.ValidateAll(req.Windows); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid access hours",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var windows sql.NullString
+	if req.Windows != nil {
+		raw, err := json.Marshal(req.Windows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update access hours"})
+			return
+		}
+		windows = sql.NullString{String: string(raw), Valid: true}
+	}
+
+	result, err := h.db.Exec(`UPDATE "store" SET timezone = $1, access_hours = $2 WHERE id = $3`, req.Timezone, windows, storeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update access hours"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       storeID,
+		"timezone": req.Timezone,
+		"windows":  req.Windows,
+	})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/accesshours"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestAccessWindowsUntil(t *testing.T) {
+	roleWindows := []accesshours.Window{{Start: "07:00", End: "23:00"}}
+	storeWindows := []accesshours.Window{{Start: "09:00", End: "21:00"}}
+	day := func(hour int) time.Time { return time.Date(2024, time.July, 1, hour, 0, 0, 0, time.UTC) }
+
+	until, err := accessWindowsUntil(day(10), roleWindows, storeWindows)
+	if err != nil || !until.Equal(day(21)) {
+		t.Errorf("Expected access until the store closes, got %v, %v", until, err)
+	}
+
+	if _, err := accessWindowsUntil(day(8), roleWindows, storeWindows); err != errOutsideAccessHours {
+		t.Errorf("Expected errOutsideAccessHours before the store opens, got %v", err)
+	}
+
+	until, err = accessWindowsUntil(day(8), roleWindows, nil)
+	if err != nil || !until.Equal(day(23)) {
+		t.Errorf("Expected a nil set not to limit access, got %v, %v", until, err)
+	}
+}
+
+func TestAccessUntil_AdminExempt(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.AccessHours.Roles = map[string][]accesshours.Window{
+		RoleAdmin:   {},
+		RoleCashier: {},
+	}
+	h := &AuthHandler{config: cfg}
+
+	if until, err := h.accessUntil(RoleAdmin, "", time.Now()); until != nil || err != nil {
+		t.Errorf("Expected admins to be exempt, got %v, %v", until, err)
+	}
+	if _, err := h.accessUntil(RoleCashier, "", time.Now()); err != errOutsideAccessHours {
+		t.Errorf("Expected cashiers without windows to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Audit query page size limits
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// AuditHandler handles authentication audit log queries
+type AuditHandler struct {
+	db *sql.DB
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(db *sql.DB) *AuditHandler {
+	return &AuditHandler{
+		db: db,
+	}
+}
+
+// AuthEvent represents a recorded authentication event
+type AuthEvent struct {
+	ID        string    `json:"id"`
+	EventType string    `json:"event_type"`
+	Outcome   string    `json:"outcome"`
+	Reason    string    `json:"reason,omitempty"`
+	UserID    string    `json:"user_id,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListEvents returns authentication events filtered by user, type, outcome and time range.
+// Pass format=csv to export every matching event as CSV instead of a JSON page.
+func (h *AuditHandler) ListEvents(c *gin.Context) {
+	where, args, err := buildAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `
+		SELECT id, event_type, outcome, reason, user_id, username, ip_address, user_agent, request_id, created_at
+		FROM auth_event` + where + `
+		ORDER BY created_at DESC`
+
+	exportCSV := c.Query("format") == "csv"
+	if !exportCSV {
+		limit, offset, err := parsePagination(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", limit, offset)
+	}
+
+	rows, err := h.db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during querying audit events : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query audit events"})
+		return
+	}
+	defer rows.Close()
+
+	if exportCSV {
+		h.writeEventsCSV(c, rows)
+		return
+	}
+
+	events := []AuthEvent{}
+	for rows.Next() {
+		event, err := scanAuthEvent(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit events"})
+			return
+		}
+		events = append(events, event)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// writeEventsCSV streams the events as a CSV attachment row by row
+func (h *AuditHandler) writeEventsCSV(c *gin.Context, rows *sql.Rows) {
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="auth_events.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "event_type", "outcome", "reason", "user_id", "username", "ip_address", "user_agent", "request_id", "created_at"})
+
+	for rows.Next() {
+		event, err := scanAuthEvent(rows)
+		if err != nil {
+			// Headers are already sent, so the export can only be cut short
+			c.Error(err)
+			break
+		}
+		writeCSVRow(w,
+			event.ID,
+			event.EventType,
+			event.Outcome,
+			event.Reason,
+			event.UserID,
+			event.Username,
+			event.IPAddress,
+			event.UserAgent,
+			event.RequestID,
+			event.CreatedAt.Format(time.RFC3339),
+		)
+	}
+
+	w.Flush()
+}
+
+// writeCSVRow writes a row of an export. Usernames, user agents and the like
+// come from clients, so every cell goes through csvCell.
+func writeCSVRow(w *csv.Writer, cells ...string) error {
+	for i, cell := range cells {
+		cells[i] = csvCell(cell)
+	}
+	return w.Write(cells)
+}
+
+// csvCell keeps a spreadsheet from running a cell as a formula by prefixing
+// values that start like one with a single quote
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// scanAuthEvent reads one auth_event row
+func scanAuthEvent(rows *sql.Rows) (AuthEvent, error) {
+	var event AuthEvent
+	var reason, userID, username, ipAddress, userAgent, requestID sql.NullString
+
+	err := rows.Scan(&event.ID, &event.EventType, &event.Outcome, &reason, &userID, &username, &ipAddress, &userAgent, &requestID, &event.CreatedAt)
+	if err != nil {
+		return event, err
+	}
+
+	event.Reason = reason.String
+	event.UserID = userID.String
+	event.Username = username.String
+	event.IPAddress = ipAddress.String
+	event.UserAgent = userAgent.String
+	event.RequestID = requestID.String
+
+	return event, nil
+}
+
+// buildAuditFilter builds the WHERE clause and arguments from the query string
+func buildAuditFilter(c *gin.Context) (string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if userID := c.Query("user_id"); userID != "" {
+		if _, err := uuid.Parse(userID); err != nil {
+			return "", nil, fmt.Errorf("user_id must be a UUID")
+		}
+		addCondition("user_id = $%d", userID)
+	}
+	if eventType := c.Query("type"); eventType != "" {
+		addCondition("event_type = $%d", eventType)
+	}
+	if outcome := c.Query("outcome"); outcome != "" {
+		addCondition("outcome = $%d", outcome)
+	}
+	if from := c.Query("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return "", nil, fmt.Errorf("from must be an RFC3339 timestamp")
+		}
+		addCondition("created_at >= $%d", t)
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return "", nil, fmt.Errorf("to must be an RFC3339 timestamp")
+		}
+		addCondition("created_at < $%d", t)
+	}
+
+	if len(conditions) == 0 {
+		return "", args, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args, nil
+}
+
+// parsePagination reads limit and offset from the query string
+func parsePagination(c *gin.Context) (int, int, error) {
+	limit := defaultAuditPageSize
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditPageSize {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxAuditPageSize)
+		}
+		limit = n
+	}
+
+	offset := 0
+	if v := c.Query("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative number")
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newAuditQueryContext(rawQuery string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/audit/events?"+rawQuery, nil)
+	return c
+}
+
+func TestBuildAuditFilter(t *testing.T) {
+	c := newAuditQueryContext("user_id=550e8400-e29b-41d4-a716-446655440000&type=login&from=2024-01-01T00:00:00Z")
+
+	where, args, err := buildAuditFilter(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := " WHERE user_id = $1 AND event_type = $2 AND created_at >= $3"
+	if where != expected {
+		t.Errorf("Expected %q, got %q", expected, where)
+	}
+
+	if len(args) != 3 {
+		t.Errorf("Expected 3 arguments, got %d", len(args))
+	}
+}
+
+func TestBuildAuditFilter_NoFilters(t *testing.T) {
+	where, args, err := buildAuditFilter(newAuditQueryContext(""))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if where != "" || len(args) != 0 {
+		t.Errorf("Expected no filter, got %q with %d arguments", where, len(args))
+	}
+}
+
+func TestBuildAuditFilter_InvalidInput(t *testing.T) {
+	for _, rawQuery := range []string{"user_id=not-a-uuid", "from=yesterday", "to=2024-13-01"} {
+		if _, _, err := buildAuditFilter(newAuditQueryContext(rawQuery)); err == nil {
+			t.Errorf("Expected error for %q", rawQuery)
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	limit, offset, err := parsePagination(newAuditQueryContext(""))
+	if err != nil || limit != defaultAuditPageSize || offset != 0 {
+		t.Errorf("Expected defaults, got limit=%d offset=%d err=%v", limit, offset, err)
+	}
+
+	if _, _, err := parsePagination(newAuditQueryContext("limit=5000")); err == nil {
+		t.Error("Expected error for limit above the maximum")
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"jane", "jane"},
+		{"", ""},
+		{"=HYPERLINK(\"http://evil.test\")", "'=HYPERLINK(\"http://evil.test\")"},
+		{"+1-555", "'+1-555"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"a=b", "a=b"},
+	}
+
+	for _, tt := range tests {
+		if got := csvCell(tt.value); got != tt.want {
+			t.Errorf("csvCell(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestListEvents_CSVEscapesFormulas(t *testing.T) {
+	db, _ := newFakeDB(t, fakeQuery{match: "FROM auth_event", answer: rows([]driver.Value{
+		"event-1", "login", "failure", "invalid credentials", nil, "=cmd|' /C calc'!A0", "10.0.0.1", "@evil", nil, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	})})
+	h := NewAuditHandler(db)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/audit/events?format=csv", nil)
+	h.ListEvents(c)
+
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected a header and one event, got %v, %v", records, err)
+	}
+	if got := records[1][5]; got != "'=cmd|' /C calc'!A0" {
+		t.Errorf("Expected the username escaped, got %q", got)
+	}
+	if got := records[1][7]; got != "'@evil" {
+		t.Errorf("Expected the user agent escaped, got %q", got)
+	}
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rsa"
 	"crypto/x509"
 	"database/sql"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"strings"
@@ -14,31 +17,57 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/identity"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthHandler handles authentication requests
 type AuthHandler struct {
-	db     *sql.DB
-	config *config.Config
+	db          *sql.DB
+	config      *config.Config
+	audit       *audit.Logger
+	passwords   identity.PasswordProvider
+	federated   map[string]identity.FederatedProvider
+	provisioner *identity.Provisioner
+	mailer      mailer.Mailer
+
+	// Magic-link requests, so the endpoint cannot be used to flood inboxes
+	magicLinksByEmail *requestLimiter
+	magicLinksByIP    *requestLimiter
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(db *sql.DB, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger, mail mailer.Mailer) *AuthHandler {
+	federated := make(map[string]identity.FederatedProvider)
+	for name, providerConfig := range cfg.Auth.OIDC {
+		federated[name] = identity.NewOIDCProvider(name, providerConfig)
+	}
+
 	return &AuthHandler{
-		db:     db,
-		config: cfg,
+		db:          db,
+		config:      cfg,
+		audit:       auditLogger,
+		passwords:   identity.NewLocalProvider(db),
+		federated:   federated,
+		provisioner: identity.NewProvisioner(db),
+		mailer:      mail,
+
+		magicLinksByEmail: newRequestLimiter(cfg.Auth.MagicLink.MaxPerEmail, magicLinkLimitWindow),
+		magicLinksByIP:    newRequestLimiter(cfg.Auth.MagicLink.MaxPerIP, magicLinkLimitWindow),
 	}
 }
 
 // RegisterRequest represents the request body for user registration
 type RegisterRequest struct {
-	Username  string `json:"username" binding:"required"`
-	Email     string `json:"email" binding:"required,email"`
-	FirstName string `json:"first_name" binding:"required"`
-	LastName  string `json:"last_name" binding:"required"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Username   string `json:"username" binding:"required"`
+	Email      string `json:"email" binding:"required,email"`
+	FirstName  string `json:"first_name" binding:"required"`
+	LastName   string `json:"last_name" binding:"required"`
+	Password   string `json:"password" binding:"required,min=6"`
+	InviteCode string `json:"invite_code,omitempty"` // required when registration is invite-only
 }
 
 // RegisterResponse represents the response body for user registration
@@ -47,26 +76,68 @@ type RegisterResponse struct {
 	Message string `json:"message"`
 }
 
+// Subject types carried in the sub_type claim
+const (
+	SubjectTypeUser   = "user"
+	SubjectTypeDevice = "device"
+	SubjectTypeGuest  = "guest"
+)
+
+// User roles
+const (
+	RoleAdmin   = "admin"
+	RoleManager = "manager"
+	RoleCashier = "cashier"
+)
+
+// Access token lifetime
+const accessTokenTTL = 15 * time.Minute
+
+// Refresh token lifetime
+const refreshTokenTTL = 7 * 24 * time.Hour
+
 type Claims struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Fullname string `json:"full_name"`
+	Username     string           `json:"username"`
+	Email        string           `json:"email"`
+	Fullname     string           `json:"full_name"`
+	Role         string           `json:"role,omitempty"`
+	SubjectType  string           `json:"sub_type,omitempty"`
+	DeviceID     string           `json:"device_id,omitempty"`
+	TenantID     string           `json:"tenant_id,omitempty"`
+	StoreID      string           `json:"store_id,omitempty"`
+	GuestID      string           `json:"guest_id,omitempty"`
+	Scope        string           `json:"scope,omitempty"`        // space-separated granted scopes
+	Confirmation *Confirmation    `json:"cnf,omitempty"`          // binds the token to a DPoP key
+	AccessUntil  *jwt.NumericDate `json:"access_until,omitempty"` // end of the user's current access hours
 	jwt.RegisteredClaims
 }
 
 type LoginRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	StoreID  string `json:"store_id,omitempty"`
+	Scope    string `json:"scope,omitempty"` // space-separated, defaults to every scope the user may have
 }
 
 type LoginResponse struct {
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
-	ExpiresIn    int64  `json:"expires_in"`
+	AccessToken     string    `json:"access_token"`
+	TokenType       string    `json:"token_type"`
+	RefreshToken    string    `json:"refresh_token,omitempty"` // set as a cookie instead in cookie session mode
+	ExpiresIn       int64     `json:"expires_in"`
+	Scope           string    `json:"scope,omitempty"`
+	CSRFToken       string    `json:"csrf_token,omitempty"`       // only in cookie session mode
+	EvictedSessions []Session `json:"evicted_sessions,omitempty"` // sessions ended to stay within the session limit
 }
 
-// Register handles user registration
+// Register handles user registration. Depending on the registration mode it
+// is open to anyone, requires an invite code or is turned off.
 func (h *AuthHandler) Register(c *gin.Context) {
+	mode := h.config.Auth.Registration.Mode
+	if mode == config.RegistrationDisabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Registration is disabled"})
+		return
+	}
+
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -90,6 +161,30 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	if mode == config.RegistrationInviteOnly && strings.TrimSpace(req.InviteCode) == "" {
+		h.recordEvent(c, audit.EventRegister, audit.OutcomeFailure, "", req.Username, "missing invite code")
+		c.JSON(http.StatusForbidden, gin.H{"error": "An invite code is required to register"})
+		return
+	}
+
+	// An invite decides the role and must have been issued for this email
+	role := RoleCashier
+	var invite *redeemedInvite
+	if req.InviteCode != "" {
+		var err error
+		invite, err = h.lookupInvite(req.InviteCode)
+		if err == errInvalidInvite || (err == nil && !strings.EqualFold(invite.Email, req.Email)) {
+			h.recordEvent(c, audit.EventRegister, audit.OutcomeFailure, "", req.Username, "invalid invite code")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invite code"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invite code"})
+			return
+		}
+		role = invite.Role
+	}
+
 	// Check if user already exists by username or email
 	var existingUserID string
 	checkQuery := `SELECT id FROM "user" WHERE username = $1 OR email = $2 LIMIT 1`
@@ -98,6 +193,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 	if err != sql.ErrNoRows {
 		if err == nil {
 			// User exists
+			h.recordEvent(c, audit.EventRegister, audit.OutcomeFailure, "", req.Username, "user already exists")
 			c.JSON(http.StatusConflict, gin.H{
 				"error": "User with this username or email already exists",
 			})
@@ -123,14 +219,21 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	defer tx.Rollback()
+
 	// Insert new user
 	var userID string
 	insertQuery := `
-		INSERT INTO "user" (username, email, first_name, last_name, password_hash) 
-		VALUES ($1, $2, $3, $4, $5) 
+		INSERT INTO "user" (username, email, first_name, last_name, password_hash, role) 
+		VALUES ($1, $2, $3, $4, $5, $6) 
 		RETURNING id`
 
-	err = h.db.QueryRow(insertQuery, req.Username, req.Email, req.FirstName, req.LastName, string(hashedPassword)).Scan(&userID)
+	err = tx.QueryRow(insertQuery, req.Username, req.Email, req.FirstName, req.LastName, string(hashedPassword), role).Scan(&userID)
 	if err != nil {
 		if gin.Mode() == "debug" {
 			fmt.Printf("Error during inserting to database : %s\n", err)
@@ -142,6 +245,30 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	// Use up the invite in the same transaction, so a code registers one account
+	if invite != nil {
+		result, err := tx.Exec(`
+			UPDATE "user_invite" SET used_at = $1, used_by = $2
+			WHERE id = $3 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $1`,
+			time.Now(), userID, invite.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			h.recordEvent(c, audit.EventRegister, audit.OutcomeFailure, "", req.Username, "invalid invite code")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid or expired invite code"})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+
+	h.recordEvent(c, audit.EventRegister, audit.OutcomeSuccess, userID, req.Username, "")
+
 	// Return success response with user ID
 	c.JSON(http.StatusCreated, RegisterResponse{
 		ID:      userID,
@@ -156,65 +283,129 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	var userId, hashedPassword, firstName, lastName, email string
-	query := `SELECT id, password_hash, first_name, last_name, email FROM "user" WHERE username = $1`
-	err := h.db.QueryRow(query, req.Username).Scan(&userId, &hashedPassword, &firstName, &lastName, &email)
-
-	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
+	user, err := h.passwords.Authenticate(c.Request.Context(), req.Username, req.Password)
+	if err == identity.ErrInvalidCredentials {
+		var userID string
+		if user != nil {
+			userID = user.ID
+		}
+		h.recordEvent(c, audit.EventLogin, audit.OutcomeFailure, userID, req.Username, "invalid credentials")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
+	if err == identity.ErrAccountDisabled {
+		h.recordEvent(c, audit.EventLogin, audit.OutcomeFailure, user.ID, req.Username, "account deactivated")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during authenticating user : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate user"})
+		return
+	}
 
-	claims := &Claims{
-		Username: req.Username,
-		Email:    email,
-		Fullname: firstName + " " + lastName,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	h.startUserSession(c, user, req.StoreID, req.Scope, audit.EventLogin)
+}
+
+// startUserSession resolves the store and scope and issues access and refresh
+// tokens for an authenticated user, recording the outcome under eventType
+func (h *AuthHandler) startUserSession(c *gin.Context, user *identity.User, requestedStoreID, requestedScope, eventType string) {
+	scope, err := grantScope(requestedScope, h.roleScopes(user.Role))
+	if err != nil {
+		h.recordEvent(c, eventType, audit.OutcomeFailure, user.ID, user.Username, "invalid scope")
+		abortInvalidScope(c, err)
+		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	privateKey, err := LoadRSAPrivateKey(h.config.Keys.PrivateKeyPath)
+	jkt, err := h.dpopProofKey(c, "")
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read private key"})
+		h.recordEvent(c, eventType, audit.OutcomeFailure, user.ID, user.Username, "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	storeID, err := h.resolveStore(user.ID, requestedStoreID)
+	if err == errStoreNotAllowed {
+		h.recordEvent(c, eventType, audit.OutcomeFailure, user.ID, user.Username, "store not allowed")
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is not a member of the requested store"})
 		return
 	}
-	accessToken, err := token.SignedString(privateKey)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve store"})
 		return
 	}
 
-	refreshToken := uuid.New().String()
+	accessUntil, err := h.accessUntil(user.Role, storeID, time.Now())
+	if err == errOutsideAccessHours {
+		h.recordEvent(c, eventType, audit.OutcomeFailure, user.ID, user.Username, "outside access hours")
+		abortOutsideAccessHours(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access hours"})
+		return
+	}
+
+	claims := newUserClaims(user.ID, user.Username, user.Email, user.FirstName, user.LastName, user.Role)
+	claims.TenantID = user.TenantID
+	claims.StoreID = storeID
+	claims.Scope = scope
+	claims.AccessUntil = accessUntil
+	tokenType := claims.bindToKey(jkt)
 
-	_, err = h.db.Exec(`
-		INSERT INTO "refresh_tokens"(user_id, token, expires_at)
-	VALUES ($1, $2, $3);
-	`, userId, refreshToken, time.Now().Add(7*24*time.Hour))
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
 
+	refreshToken, evicted, err := h.openSession(user.ID, user.Role, sessionContext{StoreID: storeID, Scope: scope, DPoPJKT: jkt})
+	if limitErr, ok := err.(*sessionLimitError); ok {
+		h.recordEvent(c, eventType, audit.OutcomeFailure, user.ID, user.Username, "session limit reached")
+		abortSessionLimit(c, limitErr)
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new refresh token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		ExpiresIn:    900,
-	})
+	h.recordEvictions(c, user.ID, user.Username, evicted)
+	h.recordEvent(c, eventType, audit.OutcomeSuccess, user.ID, user.Username, "")
+
+	h.respondWithSession(c, LoginResponse{
+		AccessToken:     accessToken,
+		TokenType:       tokenType,
+		RefreshToken:    refreshToken,
+		ExpiresIn:       int64(accessTokenTTL.Seconds()),
+		Scope:           scope,
+		EvictedSessions: evicted,
+	}, h.wantsCookieSession(c))
 }
 
-// RefreshTokenRequest represents the request body for token refresh
+// RefreshTokenRequest represents the request body for token refresh and logout.
+// In cookie session mode the refresh token comes from the cookie instead.
 type RefreshTokenRequest struct {
-	RefreshToken string `json:"refresh_token" binding:"required"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 // RefreshToken handles access token refresh
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	var req RefreshTokenRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	refreshToken, _, err := h.presentedRefreshToken(c, req.RefreshToken)
+	if err == errCSRFMismatch {
+		h.recordEvent(c, audit.EventRefresh, audit.OutcomeFailure, "", "", "invalid CSRF token")
+		abortCSRF(c)
+		return
+	}
+	if refreshToken == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
@@ -222,80 +413,269 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	// Validate refresh token from database
 	var userID string
 	var expiresAt time.Time
+	var deviceID, storeID, boundJKT, sessionScope sql.NullString
 
 	query := `
-		SELECT user_id, expires_at 
+		SELECT user_id, expires_at, device_id, store_id, dpop_jkt, scope
 		FROM refresh_tokens 
 		WHERE token = $1 AND expires_at > $2
 	`
-	err := h.db.QueryRow(query, req.RefreshToken, time.Now()).Scan(&userID, &expiresAt)
+	err = h.db.QueryRow(query, refreshToken, time.Now()).Scan(&userID, &expiresAt, &deviceID, &storeID, &boundJKT, &sessionScope)
 
 	if err != nil {
+		h.recordEvent(c, audit.EventRefresh, audit.OutcomeFailure, "", "", "invalid or expired refresh token")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
 		return
 	}
 
+	// A bound refresh token is only good together with a proof from its key
+	jkt, err := h.dpopProofKey(c, boundJKT.String)
+	if err != nil {
+		h.recordEvent(c, audit.EventRefresh, audit.OutcomeFailure, userID, "", "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
 	// Get user details for new access token
-	var username, email, firstName, lastName string
-	userQuery := `SELECT username, email, first_name, last_name FROM "user" WHERE id = $1`
-	err = h.db.QueryRow(userQuery, userID).Scan(&username, &email, &firstName, &lastName)
+	var username, email, firstName, lastName, role string
+	var tenantID sql.NullString
+	userQuery := `SELECT username, email, first_name, last_name, role, tenant_id FROM "user" WHERE id = $1`
+	err = h.db.QueryRow(userQuery, userID).Scan(&username, &email, &firstName, &lastName, &role, &tenantID)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Generate new access token
-	claims := &Claims{
-		Username: username,
-		Email:    email,
-		Fullname: firstName + " " + lastName,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	// Drop the store from the session if the membership was revoked since login
+	if storeID.Valid {
+		member, err := h.isStoreMember(userID, storeID.String)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve store"})
+			return
+		}
+		storeID.Valid = member
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	privateKey, err := LoadRSAPrivateKey(h.config.Keys.PrivateKeyPath)
+	// Keep the session's scope, less anything the role or device no longer allows
+	deviceScopes, err := h.deviceScopes(deviceID.String)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read private key"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve scope"})
 		return
 	}
+	scope, _ := grantScope("", h.roleScopes(role), scopeLimit(sessionScope.String), deviceScopes)
 
-	newAccessToken, err := token.SignedString(privateKey)
+	accessUntil, err := h.accessUntil(role, storeID.String, time.Now())
+	if err == errOutsideAccessHours {
+		h.recordEvent(c, audit.EventRefresh, audit.OutcomeFailure, userID, username, "outside access hours")
+		abortOutsideAccessHours(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access hours"})
+		return
+	}
+
+	// Generate new access token
+	claims := newUserClaims(userID, username, email, firstName, lastName, role)
+	claims.DeviceID = deviceID.String
+	claims.TenantID = tenantID.String
+	if storeID.Valid {
+		claims.StoreID = storeID.String
+	}
+	claims.Scope = scope
+	claims.AccessUntil = accessUntil
+	tokenType := claims.bindToKey(jkt)
+
+	newAccessToken, err := h.generateAccessToken(claims)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate new token"})
 		return
 	}
 
 	// Update last_used_at
-	h.db.Exec(`UPDATE refresh_tokens SET last_used_at = $1 WHERE token = $2`, time.Now(), req.RefreshToken)
+	h.db.Exec(`UPDATE refresh_tokens SET last_used_at = $1 WHERE token = $2`, time.Now(), refreshToken)
+
+	h.recordEvent(c, audit.EventRefresh, audit.OutcomeSuccess, userID, username, "")
 
 	c.JSON(http.StatusOK, gin.H{
 		"access_token": newAccessToken,
-		"expires_in":   900,
+		"token_type":   tokenType,
+		"expires_in":   int64(accessTokenTTL.Seconds()),
+		"scope":        scope,
 	})
 }
 
 // Logout handles user logout by revoking refresh token
 func (h *AuthHandler) Logout(c *gin.Context) {
 	var req RefreshTokenRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	refreshToken, fromCookie, err := h.presentedRefreshToken(c, req.RefreshToken)
+	if err == errCSRFMismatch {
+		h.recordEvent(c, audit.EventLogout, audit.OutcomeFailure, "", "", "invalid CSRF token")
+		abortCSRF(c)
+		return
+	}
+	if refreshToken == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
+	if fromCookie {
+		h.clearSessionCookies(c)
+	}
 
 	// Delete refresh token from database
-	_, err := h.db.Exec(`DELETE FROM refresh_tokens WHERE token = $1`, req.RefreshToken)
-	if err != nil {
+	var userID string
+	err = h.db.QueryRow(`DELETE FROM refresh_tokens WHERE token = $1 RETURNING user_id`, refreshToken).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		h.recordEvent(c, audit.EventLogout, audit.OutcomeFailure, "", "", "failed to revoke refresh token")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Logout failed"})
 		return
 	}
 
+	if err == sql.ErrNoRows {
+		h.recordEvent(c, audit.EventLogout, audit.OutcomeFailure, "", "", "unknown refresh token")
+	} else {
+		h.recordEvent(c, audit.EventLogout, audit.OutcomeSuccess, userID, "", "")
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// newUserClaims builds the access token claims for a user
+func newUserClaims(userID, username, email, firstName, lastName, role string) *Claims {
+	return &Claims{
+		Username:    username,
+		Email:       email,
+		Fullname:    firstName + " " + lastName,
+		Role:        role,
+		SubjectType: SubjectTypeUser,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+// generateAccessToken signs the given claims with the configured private key
+func (h *AuthHandler) generateAccessToken(claims jwt.Claims) (string, error) {
+	privateKey, err := LoadRSAPrivateKey(h.config.Keys.PrivateKeyPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	return token.SignedString(privateKey)
+}
+
+// sessionContext describes where a session was opened.
+// Empty fields are stored as NULL.
+type sessionContext struct {
+	DeviceID string
+	StoreID  string
+	Scope    string // granted scope, kept across refreshes
+	DPoPJKT  string // thumbprint of the DPoP key the refresh token is bound to
+}
+
+// saveRefreshToken creates and stores a new refresh token for the user
+func (h *AuthHandler) saveRefreshToken(userID string, session sessionContext) (string, error) {
+	return insertRefreshToken(h.db, userID, session)
+}
+
+// sessionExecer is satisfied by both *sql.DB and *sql.Tx
+type sessionExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertRefreshToken creates and stores a new refresh token for the user
+func insertRefreshToken(db sessionExecer, userID string, session sessionContext) (string, error) {
+	refreshToken := uuid.New().String()
+
+	_, err := db.Exec(`
+		INSERT INTO "refresh_tokens"(user_id, token, expires_at, device_id, store_id, scope, dpop_jkt)
+	VALUES ($1, $2, $3, $4, $5, $6, $7);
+	`, userID, refreshToken, time.Now().Add(refreshTokenTTL), nullString(session.DeviceID), nullString(session.StoreID),
+		nullString(session.Scope), nullString(session.DPoPJKT))
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
+}
+
+// recordEvent queues an authentication event for the audit log
+func (h *AuthHandler) recordEvent(c *gin.Context, eventType, outcome, userID, username, reason string) {
+	h.audit.Record(audit.Event{
+		Type:      eventType,
+		Outcome:   outcome,
+		Reason:    reason,
+		UserID:    userID,
+		Username:  username,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString("request_id"),
+	})
+}
+
+// sendMail sends the message in the background, so the response time does not
+// reveal whether a message was sent
+func sendMail(m mailer.Mailer, msg mailer.Message) {
+	if m == nil {
+		log.Printf("No mailer configured, dropping mail to %s: %s", msg.To, msg.Subject)
+		return
+	}
+
+	go func() {
+		if err := m.Send(context.Background(), msg); err != nil {
+			log.Printf("Failed to send mail: %v", err)
+		}
+	}()
+}
+
+// nullString converts an empty string to a NULL database value
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// IsUser reports whether the token was issued to a user.
+// Tokens issued before sub_type existed are user tokens.
+func (c *Claims) IsUser() bool {
+	return c.SubjectType == "" || c.SubjectType == SubjectTypeUser
+}
+
+// ParseToken validates a bearer token and returns its claims
+func ParseToken(tokenString, publicKeyPath string) (*Claims, error) {
+	// Remove "Bearer " prefix
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	// Parse and validate token
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		// Validate signing method
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+
+		// Load public key
+		return LoadRSAPublicKey(publicKeyPath)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+
+	return claims, nil
+}
+
 func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
 	keyData, err := os.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// RegisterDeviceRequest represents the request body for kiosk device registration
+type RegisterDeviceRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	StoreID string   `json:"store_id,omitempty"`
+	Scopes  []string `json:"scopes,omitempty"` // limits sessions on the device, unlimited when empty
+}
+
+// RegisterDeviceResponse represents the response body for kiosk device registration.
+// The device secret is only ever returned once.
+type RegisterDeviceResponse struct {
+	DeviceID     string `json:"device_id"`
+	DeviceSecret string `json:"device_secret"`
+	Message      string `json:"message"`
+}
+
+// DeviceTokenRequest represents the request body for kiosk device authentication
+type DeviceTokenRequest struct {
+	DeviceID     string `json:"device_id" binding:"required"`
+	DeviceSecret string `json:"device_secret" binding:"required"`
+}
+
+// DeviceTokenResponse represents the response body for kiosk device authentication
+type DeviceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// RegisterDevice registers a new kiosk device and returns its credential
+func (h *AuthHandler) RegisterDevice(c *gin.Context) {
+	var req RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Device name cannot be empty"})
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !isKnownScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope %q", scope)})
+			return
+		}
+	}
+
+	secret, err := generateDeviceSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate device secret"})
+		return
+	}
+
+	hashedSecret, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process device secret"})
+		return
+	}
+
+	var deviceID string
+	var scopes interface{}
+	if len(req.Scopes) > 0 {
+		scopes = pq.Array(req.Scopes)
+	}
+
+	insertQuery := `INSERT INTO "kiosk_device" (name, secret_hash, store_id, scopes) VALUES ($1, $2, $3, $4) RETURNING id`
+	err = h.db.QueryRow(insertQuery, req.Name, string(hashedSecret), nullString(req.StoreID), scopes).Scan(&deviceID)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during inserting kiosk device : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, RegisterDeviceResponse{
+		DeviceID:     deviceID,
+		DeviceSecret: secret,
+		Message:      "Device registered successfully",
+	})
+}
+
+// DeactivateDevice disables a kiosk device and ends every session opened on it
+func (h *AuthHandler) DeactivateDevice(c *gin.Context) {
+	deviceID := c.Param("id")
+
+	result, err := h.db.Exec(`UPDATE "kiosk_device" SET is_active = false WHERE id = $1`, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to deactivate device"})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+
+	if _, err := h.db.Exec(`DELETE FROM refresh_tokens WHERE device_id = $1`, deviceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke device sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device deactivated successfully"})
+}
+
+// DeviceToken authenticates a kiosk device with its credential and issues a device token
+func (h *AuthHandler) DeviceToken(c *gin.Context) {
+	var req DeviceTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var name, secretHash string
+	var isActive bool
+	var storeID sql.NullString
+	query := `SELECT name, secret_hash, is_active, store_id FROM "kiosk_device" WHERE id = $1`
+	err := h.db.QueryRow(query, req.DeviceID).Scan(&name, &secretHash, &isActive, &storeID)
+
+	if err != nil || !isActive || bcrypt.CompareHashAndPassword([]byte(secretHash), []byte(req.DeviceSecret)) != nil {
+		h.recordEvent(c, audit.EventDeviceToken, audit.OutcomeFailure, "", req.DeviceID, "invalid device credentials")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid device credentials"})
+		return
+	}
+
+	jkt, err := h.dpopProofKey(c, "")
+	if err != nil {
+		h.recordEvent(c, audit.EventDeviceToken, audit.OutcomeFailure, "", req.DeviceID, "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	ttl := time.Duration(h.config.Auth.DeviceTokenTTL) * time.Minute
+	claims := &Claims{
+		Username:    name,
+		SubjectType: SubjectTypeDevice,
+		DeviceID:    req.DeviceID,
+		StoreID:     storeID.String,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   req.DeviceID,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	tokenType := claims.bindToKey(jkt)
+
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	h.db.Exec(`UPDATE "kiosk_device" SET last_seen_at = $1 WHERE id = $2`, time.Now(), req.DeviceID)
+
+	h.recordEvent(c, audit.EventDeviceToken, audit.OutcomeSuccess, "", req.DeviceID, "")
+
+	c.JSON(http.StatusOK, DeviceTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   tokenType,
+		ExpiresIn:   int64(ttl.Seconds()),
+	})
+}
+
+// lookupDevice reports whether the kiosk device exists and has not been deactivated,
+// along with the store it is installed in, if any
+func (h *AuthHandler) lookupDevice(deviceID string) (bool, string, error) {
+	var isActive bool
+	var storeID sql.NullString
+	err := h.db.QueryRow(`SELECT is_active, store_id FROM "kiosk_device" WHERE id = $1`, deviceID).Scan(&isActive, &storeID)
+	if err == sql.ErrNoRows {
+		return false, "", nil
+	}
+	return isActive, storeID.String, err
+}
+
+// generateDeviceSecret generates a random hex encoded device secret
+func generateDeviceSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
+)
+
+// Token types returned with issued access tokens
+const (
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
+)
+
+// errDPoPProofRequired is returned when a DPoP-bound refresh token is used without a proof
+var errDPoPProofRequired = errors.New("dpop proof required")
+
+// errDPoPKeyMismatch is returned when a proof is signed by another key than the token is bound to
+var errDPoPKeyMismatch = errors.New("dpop proof key does not match the bound key")
+
+// Confirmation is the cnf claim, naming the key a token is bound to
+type Confirmation struct {
+	JKT string `json:"jkt"`
+}
+
+// dpopProofKey verifies the DPoP proof sent with a token request and returns the
+// thumbprint of the key that signed it, or "" when no proof was sent. boundJKT is
+// the key a presented refresh token is bound to; such a token is only accepted
+// with a proof from that key.
+func (h *AuthHandler) dpopProofKey(c *gin.Context, boundJKT string) (string, error) {
+	// A proof that authenticated the request in the middleware also covers the
+	// tokens issued for it, and could not be verified a second time anyway
+	jkt := c.GetString("dpop_jkt")
+
+	if jkt == "" {
+		if proof := c.GetHeader(dpop.HeaderName); proof != "" {
+			verified, err := dpop.Verify(proof, c.Request.Method, dpop.RequestURL(c.Request), "")
+			if err != nil {
+				return "", err
+			}
+			jkt = verified.JKT
+		}
+	}
+
+	if boundJKT != "" {
+		if jkt == "" {
+			return "", errDPoPProofRequired
+		}
+		if jkt != boundJKT {
+			return "", errDPoPKeyMismatch
+		}
+	}
+	return jkt, nil
+}
+
+// abortInvalidDPoP rejects a token request whose DPoP proof could not be verified
+func abortInvalidDPoP(c *gin.Context) {
+	c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid DPoP proof"})
+}
+
+// bindToKey binds the claims to the DPoP key and returns the matching token type
+func (c *Claims) bindToKey(jkt string) string {
+	if jkt == "" {
+		return TokenTypeBearer
+	}
+	c.Confirmation = &Confirmation{JKT: jkt}
+	return TokenTypeDPoP
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQuery answers the queries that contain match. answer returns the rows
+// of a query, or for a statement run with Exec as many rows as it affected.
+type fakeQuery struct {
+	match  string
+	answer func(args []driver.Value) ([][]driver.Value, error)
+}
+
+// rows answers a query with fixed rows
+func rows(values ...[]driver.Value) func([]driver.Value) ([][]driver.Value, error) {
+	return func([]driver.Value) ([][]driver.Value, error) {
+		return values, nil
+	}
+}
+
+// fakeDB is a database/sql driver that answers queries from a script, with the
+// first matching fakeQuery. Queries nothing matches fail the test.
+type fakeDB struct {
+	t       *testing.T
+	queries []fakeQuery
+
+	mu   sync.Mutex
+	seen []string
+}
+
+// newFakeDB opens a database answering from the queries
+func newFakeDB(t *testing.T, queries ...fakeQuery) (*sql.DB, *fakeDB) {
+	t.Helper()
+	f := &fakeDB{t: t, queries: queries}
+	db := sql.OpenDB(f)
+	t.Cleanup(func() { db.Close() })
+	return db, f
+}
+
+// ran reports whether a query containing match was run
+func (f *fakeDB) ran(match string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, query := range f.seen {
+		if strings.Contains(query, match) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDB) answer(query string, named []driver.NamedValue) ([][]driver.Value, error) {
+	f.mu.Lock()
+	f.seen = append(f.seen, query)
+	f.mu.Unlock()
+
+	args := make([]driver.Value, len(named))
+	for i, arg := range named {
+		args[i] = arg.Value
+	}
+	for _, q := range f.queries {
+		if strings.Contains(query, q.match) {
+			return q.answer(args)
+		}
+	}
+	f.t.Errorf("unexpected query: %s", query)
+	return nil, errors.New("unexpected query")
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+func (f *fakeDB) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (f *fakeDB) Close() error                                 { return nil }
+func (f *fakeDB) Begin() (driver.Tx, error)                    { return f, nil }
+func (f *fakeDB) Commit() error                                { return nil }
+func (f *fakeDB) Rollback() error                              { return nil }
+
+func (f *fakeDB) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	values, err := f.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: values}, nil
+}
+
+func (f *fakeDB) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	values, err := f.answer(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(values)), nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
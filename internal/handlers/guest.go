@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+)
+
+// GuestSessionResponse represents the response body for a new guest session
+type GuestSessionResponse struct {
+	GuestID     string `json:"guest_id"`
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	Scope       string `json:"scope,omitempty"`
+}
+
+// UpgradeGuestRequest represents the request body for attaching a guest session to a user
+type UpgradeGuestRequest struct {
+	GuestToken string `json:"guest_token" binding:"required"`
+}
+
+// StartGuestSession issues a short-lived guest token for an anonymous customer
+// on an authenticated kiosk device
+func (h *AuthHandler) StartGuestSession(c *gin.Context) {
+	deviceID := c.GetString("device_id")
+	active, storeID, err := h.lookupDevice(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device"})
+		return
+	}
+	if !active {
+		h.recordEvent(c, audit.EventGuestStart, audit.OutcomeFailure, "", "", "device inactive: "+deviceID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device is not registered or has been deactivated"})
+		return
+	}
+
+	deviceScopes, err := h.deviceScopes(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve scope"})
+		return
+	}
+	scope, _ := grantScope("", h.guestScopes(), deviceScopes)
+
+	// A guest token is bound to the key of the kiosk's device token, so it is
+	// no easier to use off the kiosk than the device token itself
+	jkt, err := h.dpopProofKey(c, "")
+	if err != nil {
+		h.recordEvent(c, audit.EventGuestStart, audit.OutcomeFailure, "", "", "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	ttl := time.Duration(h.config.Auth.GuestTokenTTL) * time.Minute
+	expiresAt := time.Now().Add(ttl)
+
+	var guestID string
+	err = h.db.QueryRow(`
+		INSERT INTO "guest_session" (device_id, store_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id`, deviceID, nullString(storeID), expiresAt).Scan(&guestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create guest session"})
+		return
+	}
+
+	claims := &Claims{
+		SubjectType: SubjectTypeGuest,
+		GuestID:     guestID,
+		DeviceID:    deviceID,
+		StoreID:     storeID,
+		Scope:       scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   guestID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	tokenType := claims.bindToKey(jkt)
+
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	h.recordEvent(c, audit.EventGuestStart, audit.OutcomeSuccess, "", guestID, "")
+
+	c.JSON(http.StatusCreated, GuestSessionResponse{
+		GuestID:     guestID,
+		AccessToken: accessToken,
+		TokenType:   tokenType,
+		ExpiresIn:   int64(ttl.Seconds()),
+		Scope:       scope,
+	})
+}
+
+// UpgradeGuestSession attaches a guest session to the signed-in user, for a
+// customer who signs in mid-flow. The returned access token carries the guest
+// ID so downstream services can move the guest cart to the user.
+func (h *AuthHandler) UpgradeGuestSession(c *gin.Context) {
+	var req UpgradeGuestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	username := c.GetString("username")
+
+	guestClaims, err := ParseToken(req.GuestToken, h.config.Keys.PublicKeyPath)
+	if err != nil || guestClaims.SubjectType != SubjectTypeGuest {
+		h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeFailure, userID, username, "invalid guest token")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired guest token"})
+		return
+	}
+
+	// The upgraded token stays bound to the key of the user's session. A guest
+	// token bound to the kiosk's key is only upgraded with a proof from that key.
+	var guestJKT string
+	if guestClaims.Confirmation != nil {
+		guestJKT = guestClaims.Confirmation.JKT
+	}
+	jkt, err := h.dpopProofKey(c, guestJKT)
+	if err != nil {
+		h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeFailure, userID, username, "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	// The access token outlives a deactivation by up to its lifetime, so the
+	// account is checked again before issuing a new one
+	var email, firstName, lastName, role string
+	var tenantID sql.NullString
+	userQuery := `SELECT email, first_name, last_name, role, tenant_id FROM "user" WHERE id = $1 AND is_active`
+	err = h.db.QueryRow(userQuery, userID).Scan(&email, &firstName, &lastName, &role, &tenantID)
+	if err == sql.ErrNoRows {
+		h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeFailure, userID, username, "account deactivated")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Attach the session once; repeating the upgrade for the same user is allowed
+	result, err := h.db.Exec(`
+		UPDATE "guest_session"
+		SET upgraded_user_id = $1, upgraded_at = COALESCE(upgraded_at, $2)
+		WHERE id = $3 AND expires_at > $2 AND (upgraded_user_id IS NULL OR upgraded_user_id = $1)`,
+		userID, time.Now(), guestClaims.Subject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upgrade guest session"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeFailure, userID, username, "guest session expired or already upgraded")
+		c.JSON(http.StatusConflict, gin.H{"error": "Guest session has expired or belongs to another user"})
+		return
+	}
+
+	// The upgraded token keeps the user's scope, within what the kiosk allows
+	deviceScopes, err := h.deviceScopes(guestClaims.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve scope"})
+		return
+	}
+	scope, _ := grantScope("", h.roleScopes(role), scopeLimit(c.GetString("scope")), deviceScopes)
+
+	accessUntil, err := h.accessUntil(role, c.GetString("store_id"), time.Now())
+	if err == errOutsideAccessHours {
+		h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeFailure, userID, username, "outside access hours")
+		abortOutsideAccessHours(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access hours"})
+		return
+	}
+
+	claims := newUserClaims(userID, username, email, firstName, lastName, role)
+	claims.GuestID = guestClaims.Subject
+	claims.DeviceID = guestClaims.DeviceID
+	claims.TenantID = tenantID.String
+	claims.StoreID = c.GetString("store_id")
+	claims.Scope = scope
+	claims.AccessUntil = accessUntil
+	tokenType := claims.bindToKey(jkt)
+
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	h.recordEvent(c, audit.EventGuestUpgrade, audit.OutcomeSuccess, userID, username, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"guest_id":     guestClaims.Subject,
+		"access_token": accessToken,
+		"token_type":   tokenType,
+		"expires_in":   int64(accessTokenTTL.Seconds()),
+		"scope":        scope,
+	})
+}
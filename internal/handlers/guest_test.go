@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql/driver"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// setupSigningKeys writes a fresh token signing key pair and returns its paths
+func setupSigningKeys(t *testing.T) config.PublicPrivateKey {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	dir := t.TempDir()
+	keys := config.PublicPrivateKey{
+		PrivateKeyPath: filepath.Join(dir, "privateKey.pem"),
+		PublicKeyPath:  filepath.Join(dir, "publicKey.pem"),
+	}
+	privatePEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	if err := os.WriteFile(keys.PrivateKeyPath, privatePEM, 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(keys.PublicKeyPath, publicPEM, 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return keys
+}
+
+// setupGuestRouter serves the guest routes for a kiosk whose device token, and
+// so the session of anyone signed in on it, is bound to deviceJKT
+func setupGuestRouter(t *testing.T, deviceJKT string, queries ...fakeQuery) (*AuthHandler, *gin.Engine, *fakeDB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, queries...)
+	cfg := &config.Config{Keys: setupSigningKeys(t)}
+	cfg.Auth.GuestTokenTTL = 30
+	h := &AuthHandler{db: db, config: cfg}
+
+	authenticated := func(c *gin.Context) {
+		c.Set("device_id", "kiosk-1")
+		c.Set("user_id", "user-1")
+		c.Set("username", "customer1")
+		if deviceJKT != "" {
+			c.Set("dpop_jkt", deviceJKT)
+		}
+		c.Next()
+	}
+
+	router := gin.New()
+	router.POST("/api/v1/auth/guest", authenticated, h.StartGuestSession)
+	router.POST("/api/v1/auth/guest/upgrade", authenticated, h.UpgradeGuestSession)
+	return h, router, fake
+}
+
+// guestToken signs a guest token, bound to jkt unless it is empty
+func guestToken(t *testing.T, h *AuthHandler, jkt string) string {
+	t.Helper()
+	claims := &Claims{
+		SubjectType: SubjectTypeGuest,
+		GuestID:     "guest-1",
+		DeviceID:    "kiosk-1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "guest-1",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}
+	claims.bindToKey(jkt)
+	token, err := h.generateAccessToken(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign guest token: %v", err)
+	}
+	return token
+}
+
+// postJSON sends a JSON body and decodes the JSON response
+func postJSON(router *gin.Engine, path string, body any) (int, map[string]any) {
+	encoded, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	resp := map[string]any{}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp
+}
+
+// tokenJKT returns the DPoP key an access token is bound to
+func tokenJKT(t *testing.T, h *AuthHandler, token string) string {
+	t.Helper()
+	claims, err := ParseToken(token, h.config.Keys.PublicKeyPath)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if claims.Confirmation == nil {
+		return ""
+	}
+	return claims.Confirmation.JKT
+}
+
+var upgradeQueries = []fakeQuery{
+	{match: `UPDATE "guest_session"`, answer: rows([]driver.Value{})},
+	{match: `SELECT email, first_name`, answer: rows([]driver.Value{"customer1@example.com", "Customer", "One", "cashier", nil})},
+	{match: `SELECT scopes FROM "kiosk_device"`, answer: rows()},
+}
+
+func TestStartGuestSession_BoundToDeviceKey(t *testing.T) {
+	h, router, _ := setupGuestRouter(t, "device-jkt",
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT scopes FROM "kiosk_device"`, answer: rows()},
+		fakeQuery{match: `INSERT INTO "guest_session"`, answer: rows([]driver.Value{"guest-1"})},
+	)
+
+	code, resp := postJSON(router, "/api/v1/auth/guest", nil)
+	if code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", code)
+	}
+	if resp["token_type"] != TokenTypeDPoP {
+		t.Errorf("Expected token type DPoP, got %q", resp["token_type"])
+	}
+	if got := tokenJKT(t, h, resp["access_token"].(string)); got != "device-jkt" {
+		t.Errorf("Expected the guest token bound to the device key, got %q", got)
+	}
+}
+
+func TestUpgradeGuestSession_RequiresProof(t *testing.T) {
+	// The user's session is not bound and the request has no proof
+	h, router, fake := setupGuestRouter(t, "", upgradeQueries...)
+
+	code, _ := postJSON(router, "/api/v1/auth/guest/upgrade", UpgradeGuestRequest{GuestToken: guestToken(t, h, "device-jkt")})
+	if code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", code)
+	}
+	if fake.ran(`UPDATE "guest_session"`) {
+		t.Error("Expected the guest session left as it is")
+	}
+}
+
+func TestUpgradeGuestSession_StaysBound(t *testing.T) {
+	h, router, _ := setupGuestRouter(t, "device-jkt", upgradeQueries...)
+
+	code, resp := postJSON(router, "/api/v1/auth/guest/upgrade", UpgradeGuestRequest{GuestToken: guestToken(t, h, "device-jkt")})
+	if code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if resp["token_type"] != TokenTypeDPoP {
+		t.Errorf("Expected token type DPoP, got %q", resp["token_type"])
+	}
+	if got := tokenJKT(t, h, resp["access_token"].(string)); got != "device-jkt" {
+		t.Errorf("Expected the upgraded token bound to the session key, got %q", got)
+	}
+}
+
+func TestUpgradeGuestSession_OtherKey(t *testing.T) {
+	h, router, fake := setupGuestRouter(t, "other-jkt", upgradeQueries...)
+
+	code, _ := postJSON(router, "/api/v1/auth/guest/upgrade", UpgradeGuestRequest{GuestToken: guestToken(t, h, "device-jkt")})
+	if code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", code)
+	}
+	if fake.ran(`UPDATE "guest_session"`) {
+		t.Error("Expected the guest session left as it is")
+	}
+}
+
+func TestUpgradeGuestSession_DeactivatedUser(t *testing.T) {
+	h, router, fake := setupGuestRouter(t, "",
+		fakeQuery{match: `SELECT email, first_name`, answer: rows()},
+	)
+
+	code, _ := postJSON(router, "/api/v1/auth/guest/upgrade", UpgradeGuestRequest{GuestToken: guestToken(t, h, "")})
+	if code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", code)
+	}
+	if !fake.ran("AND is_active") {
+		t.Error("Expected only active users looked up")
+	}
+	if fake.ran(`UPDATE "guest_session"`) {
+		t.Error("Expected the guest session left as it is")
+	}
+}
@@ -6,12 +6,19 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// CircuitBreakers reports the circuit breaker states of the downstream services
+type CircuitBreakers interface {
+	CircuitStates() map[string]string
+}
+
 // HealthHandler handles health check requests
-type HealthHandler struct{}
+type HealthHandler struct {
+	breakers CircuitBreakers
+}
 
 // NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+func NewHealthHandler(breakers CircuitBreakers) *HealthHandler {
+	return &HealthHandler{breakers: breakers}
 }
 
 // HealthCheck returns the health status of the gateway
@@ -23,14 +30,24 @@ func (h *HealthHandler) HealthCheck(c *gin.Context) {
 	})
 }
 
-// ReadinessCheck returns the readiness status of the gateway
+// ReadinessCheck returns the readiness status of the gateway. A downstream
+// service with an open circuit breaker makes the gateway degraded but still
+// ready, since the gateway's other routes keep working.
 func (h *HealthHandler) ReadinessCheck(c *gin.Context) {
-	// TODO: Add checks for database connectivity and downstream services
+	// TODO: Add a check for database connectivity
+	status := "ready"
+	services := h.breakers.CircuitStates()
+	for _, state := range services {
+		if state != "closed" {
+			status = "degraded"
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status": "ready",
+		"status": status,
 		"checks": gin.H{
 			"database": "ok",
-			// Add more service checks as needed
+			"services": services,
 		},
 	})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeBreakers reports fixed circuit breaker states
+type fakeBreakers map[string]string
+
+func (f fakeBreakers) CircuitStates() map[string]string {
+	return f
+}
+
+func TestReadinessCheck_CircuitBreakers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		breakers   fakeBreakers
+		wantStatus string
+	}{
+		{"all closed", fakeBreakers{"order_service": "closed", "payment_service": "closed"}, "ready"},
+		{"one open", fakeBreakers{"order_service": "closed", "payment_service": "open"}, "degraded"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/ready", NewHealthHandler(tt.breakers).ReadinessCheck)
+
+			req, _ := http.NewRequest(http.MethodGet, "/ready", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", w.Code)
+			}
+			var response struct {
+				Status string `json:"status"`
+				Checks struct {
+					Services map[string]string `json:"services"`
+				} `json:"checks"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response.Status != tt.wantStatus {
+				t.Errorf("Expected status %q, got %q", tt.wantStatus, response.Status)
+			}
+			if response.Checks.Services["payment_service"] != tt.breakers["payment_service"] {
+				t.Errorf("Expected the breaker states reported, got %v", response.Checks.Services)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
+)
+
+// Invite statuses, derived from the used, revoked and expiry columns
+const (
+	InviteStatusPending = "pending"
+	InviteStatusUsed    = "used"
+	InviteStatusRevoked = "revoked"
+	InviteStatusExpired = "expired"
+)
+
+// errInvalidInvite is returned for invite codes that are unknown, used, revoked or expired
+var errInvalidInvite = errors.New("invalid or expired invite code")
+
+// inviteStatusSQL computes an invite's status in queries on "user_invite"
+const inviteStatusSQL = `
+	CASE
+		WHEN used_at IS NOT NULL THEN 'used'
+		WHEN revoked_at IS NOT NULL THEN 'revoked'
+		WHEN expires_at <= CURRENT_TIMESTAMP THEN 'expired'
+		ELSE 'pending'
+	END`
+
+// CreateInviteRequest represents the request body for issuing an invite
+type CreateInviteRequest struct {
+	Email          string `json:"email" binding:"required,email"`
+	Role           string `json:"role,omitempty"`
+	ExpiresInHours int    `json:"expires_in_hours,omitempty"`
+}
+
+// Invite represents an invite as listed to admins. The code itself is never stored.
+type Invite struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	Status    string     `json:"status"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	UsedBy    string     `json:"used_by,omitempty"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// CreateInviteResponse represents the response body for issuing an invite.
+// The invite code is only ever returned once.
+type CreateInviteResponse struct {
+	Invite
+	Code string `json:"code"`
+}
+
+// redeemedInvite is the part of an invite needed to register with it
+type redeemedInvite struct {
+	ID    string
+	Email string
+	Role  string
+}
+
+// CreateInvite issues an invite code for the email and role and emails it to the invitee
+func (h *AuthHandler) CreateInvite(c *gin.Context) {
+	var req CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Role == "" {
+		req.Role = RoleCashier
+	}
+	if req.Role != RoleAdmin && req.Role != RoleManager && req.Role != RoleCashier {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be admin, manager or cashier"})
+		return
+	}
+
+	ttl := time.Duration(h.config.Auth.Registration.InviteTTL) * time.Hour
+	if req.ExpiresInHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in_hours must be positive"})
+		return
+	}
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+
+	code, err := randomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invite code"})
+		return
+	}
+
+	invite := Invite{
+		Email:     req.Email,
+		Role:      req.Role,
+		Status:    InviteStatusPending,
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedBy: c.GetString("user_id"),
+	}
+
+	insertQuery := `
+		INSERT INTO "user_invite" (code_hash, email, role, expires_at, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	err = h.db.QueryRow(insertQuery, hashInviteCode(code), invite.Email, invite.Role, invite.ExpiresAt, nullString(invite.CreatedBy)).
+		Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during inserting user invite : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	sendMail(h.mailer, mailer.Message{
+		To:      invite.Email,
+		Subject: "You have been invited",
+		Body: fmt.Sprintf("Hello,\n\nYou have been invited to create an account. Use this invite code when you register, before %s:\n\n%s\n\nThe code only works for this email address.\n",
+			invite.ExpiresAt.Format(time.RFC1123), code),
+	})
+
+	c.JSON(http.StatusCreated, CreateInviteResponse{
+		Invite: invite,
+		Code:   code,
+	})
+}
+
+// ListInvites returns invites, newest first, filtered by status and email
+func (h *AuthHandler) ListInvites(c *gin.Context) {
+	var conditions []string
+	var args []interface{}
+
+	if status := c.Query("status"); status != "" {
+		switch status {
+		case InviteStatusPending, InviteStatusUsed, InviteStatusRevoked, InviteStatusExpired:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, used, revoked or expired"})
+			return
+		}
+		args = append(args, status)
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", inviteStatusSQL, len(args)))
+	}
+	if email := c.Query("email"); email != "" {
+		args = append(args, email)
+		conditions = append(conditions, fmt.Sprintf("lower(email) = lower($%d)", len(args)))
+	}
+
+	limit, offset, err := parsePagination(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `
+		SELECT id, email, role, ` + inviteStatusSQL + `, expires_at, created_by, used_by, used_at, revoked_at, created_at
+		FROM "user_invite"`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT %d OFFSET %d", limit, offset)
+
+	rows, err := h.db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during querying user invites : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query invites"})
+		return
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		var invite Invite
+		var createdBy, usedBy sql.NullString
+		var usedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&invite.ID, &invite.Email, &invite.Role, &invite.Status, &invite.ExpiresAt,
+			&createdBy, &usedBy, &usedAt, &revokedAt, &invite.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invites"})
+			return
+		}
+		invite.CreatedBy = createdBy.String
+		invite.UsedBy = usedBy.String
+		if usedAt.Valid {
+			invite.UsedAt = &usedAt.Time
+		}
+		if revokedAt.Valid {
+			invite.RevokedAt = &revokedAt.Time
+		}
+		invites = append(invites, invite)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// RevokeInvite revokes an invite that has not been used yet
+func (h *AuthHandler) RevokeInvite(c *gin.Context) {
+	inviteID := c.Param("id")
+	if _, err := uuid.Parse(inviteID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	var status string
+	err := h.db.QueryRow(`SELECT `+inviteStatusSQL+` FROM "user_invite" WHERE id = $1`, inviteID).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	switch status {
+	case InviteStatusUsed:
+		c.JSON(http.StatusConflict, gin.H{"error": "Invite has already been used"})
+		return
+	case InviteStatusRevoked:
+		c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+		return
+	}
+
+	result, err := h.db.Exec(`UPDATE "user_invite" SET revoked_at = $1 WHERE id = $2 AND used_at IS NULL AND revoked_at IS NULL`, time.Now(), inviteID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		// Used between the check and the update
+		c.JSON(http.StatusConflict, gin.H{"error": "Invite has already been used"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
+
+// lookupInvite finds the pending invite for the code
+func (h *AuthHandler) lookupInvite(code string) (*redeemedInvite, error) {
+	var invite redeemedInvite
+	err := h.db.QueryRow(`
+		SELECT id, email, role
+		FROM "user_invite"
+		WHERE code_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > $2`,
+		hashInviteCode(code), time.Now()).Scan(&invite.ID, &invite.Email, &invite.Role)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidInvite
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// hashInviteCode hashes an invite code for storage and lookup
+func hashInviteCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestRegister_RegistrationMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := `{"username":"jane","email":"jane@example.com","first_name":"Jane","last_name":"Doe","password":"secret123"}`
+
+	tests := []struct {
+		name string
+		mode string
+	}{
+		{"disabled", config.RegistrationDisabled},
+		{"invite only without code", config.RegistrationInviteOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Auth.Registration.Mode = tt.mode
+			h := &AuthHandler{config: cfg}
+
+			router := gin.New()
+			router.POST("/register", h.Register)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/register", bytes.NewBufferString(body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+			}
+		})
+	}
+}
+
+func TestHashInviteCode(t *testing.T) {
+	if hashInviteCode("abc") != hashInviteCode(" abc\n") {
+		t.Error("Expected surrounding whitespace to be ignored")
+	}
+	if hashInviteCode("abc") == hashInviteCode("abd") {
+		t.Error("Expected different codes to hash differently")
+	}
+	if len(hashInviteCode("abc")) != 64 {
+		t.Errorf("Expected a 64 character hash, got %d", len(hashInviteCode("abc")))
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/identity"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
+)
+
+// magicLinkCookie holds the nonce that binds a magic link to the browser that requested it
+const magicLinkCookie = "magic_link_nonce"
+
+// magicLinkCookiePath limits the nonce cookie to the magic-link endpoints
+const magicLinkCookiePath = "/api/v1/auth/magic-link"
+
+// magicLinkLimitWindow is the window of the per-email and per-IP request limits
+const magicLinkLimitWindow = time.Hour
+
+// magicLinkAudience keeps magic-link tokens apart from other gateway tokens
+const magicLinkAudience = "magic-link"
+
+// MagicLinkRequest represents the request body for requesting a magic link
+type MagicLinkRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// VerifyMagicLinkRequest represents the request body for exchanging a magic link
+type VerifyMagicLinkRequest struct {
+	Token   string `json:"token" binding:"required"`
+	StoreID string `json:"store_id,omitempty"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// magicLinkClaims are the claims of a signed magic-link token. The subject is
+// the user ID and the token ID is the magic_link row.
+type magicLinkClaims struct {
+	NonceHash string `json:"nonce_hash"`
+	jwt.RegisteredClaims
+}
+
+// RequestMagicLink emails a single-use sign-in link to the account with the
+// email. The response is the same whether or not the account exists.
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	if !h.magicLinkEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Magic-link login is disabled"})
+		return
+	}
+
+	var req MagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// The email is limited whether or not it belongs to an account, so the
+	// limit does not reveal which emails do
+	now := time.Now()
+	if ok, wait := h.magicLinksByIP.allow(c.ClientIP(), now); !ok {
+		h.recordEvent(c, audit.EventMagicLink, audit.OutcomeFailure, "", req.Email, "too many requests from client")
+		abortRateLimited(c, "Too many sign-in link requests, try again later", wait)
+		return
+	}
+	if ok, wait := h.magicLinksByEmail.allow(strings.ToLower(req.Email), now); !ok {
+		h.recordEvent(c, audit.EventMagicLink, audit.OutcomeFailure, "", req.Email, "too many requests for email")
+		abortRateLimited(c, "Too many sign-in link requests, try again later", wait)
+		return
+	}
+
+	nonce, err := randomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign-in link"})
+		return
+	}
+
+	ttl := time.Duration(h.config.Auth.MagicLink.TTL) * time.Minute
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkCookie, nonce, int(ttl.Seconds()), magicLinkCookiePath, "", h.config.Auth.MagicLink.SecureCookie, true)
+
+	accepted := gin.H{"message": "If the email belongs to an account, a sign-in link has been sent"}
+
+	var userID, username string
+	err = h.db.QueryRow(`SELECT id, username FROM "user" WHERE lower(email) = lower($1) AND is_active`, req.Email).Scan(&userID, &username)
+	if err != nil {
+		h.recordEvent(c, audit.EventMagicLink, audit.OutcomeFailure, "", req.Email, "unknown or inactive email")
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	var linkID string
+	err = h.db.QueryRow(`INSERT INTO "magic_link" (user_id, expires_at) VALUES ($1, $2) RETURNING id`, userID, expiresAt).Scan(&linkID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign-in link"})
+		return
+	}
+
+	token, err := signMagicLink([]byte(h.config.Auth.MagicLink.SigningKey), userID, linkID, nonce, expiresAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign-in link"})
+		return
+	}
+
+	link, err := url.Parse(h.config.Auth.MagicLink.LinkURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create sign-in link"})
+		return
+	}
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	sendMail(h.mailer, mailer.Message{
+		To:      req.Email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("Hello %s,\n\nUse this link to sign in. It works once, in the browser you requested it from, and expires in %d minutes.\n\n%s\n\nIf you did not ask to sign in, you can ignore this email.\n",
+			username, h.config.Auth.MagicLink.TTL, link.String()),
+	})
+
+	h.recordEvent(c, audit.EventMagicLink, audit.OutcomeSuccess, userID, username, "")
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// VerifyMagicLink exchanges a magic link for a normal session. The link must be
+// used from the browser that requested it, and only once.
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	if !h.magicLinkEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Magic-link login is disabled"})
+		return
+	}
+
+	var req VerifyMagicLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	claims, err := parseMagicLink([]byte(h.config.Auth.MagicLink.SigningKey), req.Token)
+	if err != nil {
+		h.recordEvent(c, audit.EventMagicLogin, audit.OutcomeFailure, "", "", "invalid or expired link")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+		return
+	}
+
+	// Check the browser before using up the link, so a link opened elsewhere
+	// (or fetched by a mail scanner) still works in the right browser
+	nonce, err := c.Cookie(magicLinkCookie)
+	if err != nil || !claims.matchesNonce(nonce) {
+		h.recordEvent(c, audit.EventMagicLogin, audit.OutcomeFailure, claims.Subject, "", "browser nonce mismatch")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Open the sign-in link in the browser you requested it from"})
+		return
+	}
+
+	var linkID string
+	err = h.db.QueryRow(`
+		DELETE FROM "magic_link"
+		WHERE id = $1 AND user_id = $2 AND expires_at > $3
+		RETURNING id`, claims.ID, claims.Subject, time.Now()).Scan(&linkID)
+	if err != nil {
+		h.recordEvent(c, audit.EventMagicLogin, audit.OutcomeFailure, claims.Subject, "", "link already used")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in link"})
+		return
+	}
+
+	c.SetCookie(magicLinkCookie, "", -1, magicLinkCookiePath, "", h.config.Auth.MagicLink.SecureCookie, true)
+
+	var user identity.User
+	var tenantID sql.NullString
+	err = h.db.QueryRow(`
+		SELECT id, username, email, first_name, last_name, role, tenant_id
+		FROM "user"
+		WHERE id = $1 AND is_active`, claims.Subject).Scan(
+		&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &tenantID)
+	if err != nil {
+		h.recordEvent(c, audit.EventMagicLogin, audit.OutcomeFailure, claims.Subject, "", "account deactivated")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	}
+	user.TenantID = tenantID.String
+
+	h.startUserSession(c, &user, req.StoreID, req.Scope, audit.EventMagicLogin)
+}
+
+// magicLinkEnabled reports whether magic-link login is turned on and can sign links
+func (h *AuthHandler) magicLinkEnabled() bool {
+	return h.config.Auth.MagicLink.Enabled && h.config.Auth.MagicLink.SigningKey != ""
+}
+
+// signMagicLink signs a magic-link token for the user and link, bound to the nonce
+func signMagicLink(key []byte, userID, linkID, nonce string, expiresAt time.Time) (string, error) {
+	claims := magicLinkClaims{
+		NonceHash: hashNonce(nonce),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        linkID,
+			Audience:  jwt.ClaimStrings{magicLinkAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+}
+
+// parseMagicLink verifies a magic-link token's signature, audience and expiry
+func parseMagicLink(key []byte, token string) (*magicLinkClaims, error) {
+	claims := &magicLinkClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{"HS256"}),
+		jwt.WithAudience(magicLinkAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || claims.ID == "" || claims.NonceHash == "" {
+		return nil, errors.New("incomplete magic-link token")
+	}
+	return claims, nil
+}
+
+// matchesNonce reports whether the nonce is the one the link was issued for
+func (c *magicLinkClaims) matchesNonce(nonce string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashNonce(nonce)), []byte(c.NonceHash)) == 1
+}
+
+// hashNonce hashes a nonce so the link does not carry the cookie value itself
+func hashNonce(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
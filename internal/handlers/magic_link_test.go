@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestMagicLinkRoundTrip(t *testing.T) {
+	key := []byte("test-signing-key")
+
+	token, err := signMagicLink(key, "user-1", "link-1", "browser-nonce", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to sign magic link: %v", err)
+	}
+
+	claims, err := parseMagicLink(key, token)
+	if err != nil {
+		t.Fatalf("Failed to parse magic link: %v", err)
+	}
+
+	if claims.Subject != "user-1" || claims.ID != "link-1" {
+		t.Errorf("Unexpected claims: subject=%q id=%q", claims.Subject, claims.ID)
+	}
+	if !claims.matchesNonce("browser-nonce") {
+		t.Error("Expected the issuing browser's nonce to match")
+	}
+	if claims.matchesNonce("other-browser") {
+		t.Error("Expected another browser's nonce not to match")
+	}
+}
+
+func TestParseMagicLink_Rejected(t *testing.T) {
+	key := []byte("test-signing-key")
+
+	expired, _ := signMagicLink(key, "user-1", "link-1", "nonce", time.Now().Add(-time.Minute))
+	otherKey, _ := signMagicLink([]byte("other-key"), "user-1", "link-1", "nonce", time.Now().Add(time.Minute))
+
+	for name, token := range map[string]string{
+		"expired":   expired,
+		"other key": otherKey,
+		"garbage":   "not-a-token",
+	} {
+		if _, err := parseMagicLink(key, token); err == nil {
+			t.Errorf("Expected %s token to be rejected", name)
+		}
+	}
+}
+
+func TestRequestMagicLink_RateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		emails []string
+	}{
+		{"same email", []string{"jane@example.com", "Jane@Example.com", "JANE@example.com"}},
+		{"same client", []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com", "f@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newFakeDB(t, fakeQuery{match: `FROM "user"`, answer: rows()})
+			cfg := &config.Config{}
+			cfg.Auth.MagicLink = config.MagicLinkConfig{Enabled: true, SigningKey: "test-signing-key", TTL: 15}
+			h := &AuthHandler{
+				db:                db,
+				config:            cfg,
+				magicLinksByEmail: newRequestLimiter(2, magicLinkLimitWindow),
+				magicLinksByIP:    newRequestLimiter(5, magicLinkLimitWindow),
+			}
+			router := gin.New()
+			router.POST("/api/v1/auth/magic-link", h.RequestMagicLink)
+
+			var codes []int
+			for _, email := range tt.emails {
+				req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/magic-link", strings.NewReader(`{"email":"`+email+`"}`))
+				req.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				codes = append(codes, w.Code)
+			}
+
+			last := len(codes) - 1
+			for i, code := range codes[:last] {
+				if code != http.StatusAccepted {
+					t.Errorf("Expected request %d accepted, got %d", i+1, code)
+				}
+			}
+			if codes[last] != http.StatusTooManyRequests {
+				t.Errorf("Expected the last request limited, got %d", codes[last])
+			}
+		})
+	}
+}
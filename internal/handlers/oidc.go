@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/identity"
+)
+
+// oidcLoginStateTTL is how long a user has to complete a federated sign-in
+const oidcLoginStateTTL = 10 * time.Minute
+
+// oidcStateCookie binds a federated sign-in to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// oidcStateCookiePath limits the state cookie to the federated sign-in endpoints
+const oidcStateCookiePath = "/api/v1/auth/oidc"
+
+// OIDCLogin starts a federated sign-in by redirecting the browser to the provider.
+// The state, nonce and PKCE verifier are kept until the provider calls back, and
+// the state is also set in a cookie so only this browser can complete the sign-in.
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.federated[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, err := randomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+	nonce, err := randomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+	codeVerifier, err := randomURLToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, identity.CodeChallenge(codeVerifier))
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during building %s authorization URL : %s\n", provider.Name(), err)
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		INSERT INTO "oidc_login_state" (state, provider, nonce, code_verifier, store_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		state, provider.Name(), nonce, codeVerifier, nullString(c.Query("store_id")), time.Now().Add(oidcLoginStateTTL))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-in"})
+		return
+	}
+
+	// Lax, since the provider sends the browser back with a cross-site redirect
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcLoginStateTTL.Seconds()), oidcStateCookiePath, "", h.oidcSecureCookie(provider.Name()), true)
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes a federated sign-in. The verified identity is linked to
+// a gateway user, provisioning one on first login, and a normal session is issued.
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	provider, ok := h.federated[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", "", provider.Name()+": "+providerError)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-in was not completed"})
+		return
+	}
+
+	state, code := c.Query("state"), c.Query("code")
+	if state == "" || code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
+		return
+	}
+
+	// A callback opened in another browser would sign that browser in as the
+	// user who started the sign-in
+	browserState, err := c.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(browserState), []byte(state)) != 1 {
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", "", provider.Name()+": browser state mismatch")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Finish signing in in the browser you started from"})
+		return
+	}
+
+	// The state is single use, so it is removed as it is read
+	var nonce, codeVerifier string
+	var storeID sql.NullString
+	err = h.db.QueryRow(`
+		DELETE FROM "oidc_login_state"
+		WHERE state = $1 AND provider = $2 AND expires_at > $3
+		RETURNING nonce, code_verifier, store_id`,
+		state, provider.Name(), time.Now()).Scan(&nonce, &codeVerifier, &storeID)
+	if err != nil {
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", "", provider.Name()+": invalid or expired state")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired sign-in state"})
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcStateCookiePath, "", h.oidcSecureCookie(provider.Name()), true)
+
+	id, err := provider.Exchange(c.Request.Context(), code, codeVerifier, nonce)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during %s code exchange : %s\n", provider.Name(), err)
+		}
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", "", provider.Name()+": code exchange failed")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider rejected the sign-in"})
+		return
+	}
+
+	defaultRole := h.config.Auth.OIDC[provider.Name()].DefaultRole
+	if defaultRole == "" {
+		defaultRole = RoleCashier
+	}
+
+	user, err := h.provisioner.Resolve(c.Request.Context(), id, defaultRole)
+	switch err {
+	case nil:
+	case identity.ErrMissingEmail:
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", id.PreferredUsername, provider.Name()+": no email")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Identity provider did not share an email address"})
+		return
+	case identity.ErrEmailNotVerified:
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", id.Email, provider.Name()+": email not verified")
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email exists, but the provider has not verified the email"})
+		return
+	case identity.ErrAccountDisabled:
+		h.recordEvent(c, audit.EventOIDCLogin, audit.OutcomeFailure, "", id.Email, provider.Name()+": account deactivated")
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is deactivated"})
+		return
+	default:
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during provisioning %s user : %s\n", provider.Name(), err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to provision user"})
+		return
+	}
+
+	h.startUserSession(c, user, storeID.String, "", audit.EventOIDCLogin)
+}
+
+// oidcSecureCookie reports whether the state cookie is sent over HTTPS only,
+// which it is when the provider calls back over HTTPS
+func (h *AuthHandler) oidcSecureCookie(provider string) bool {
+	return strings.HasPrefix(h.config.Auth.OIDC[provider].RedirectURL, "https://")
+}
+
+// randomURLToken generates a random URL-safe token, also used as a PKCE code verifier
+func randomURLToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
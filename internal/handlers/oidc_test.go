@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/identity"
+)
+
+// fakeFederatedProvider redirects to a fixed URL and rejects every code
+type fakeFederatedProvider struct{}
+
+func (fakeFederatedProvider) Name() string { return "corporate" }
+
+func (fakeFederatedProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	return "https://idp.test/authorize?state=" + url.QueryEscape(state), nil
+}
+
+func (fakeFederatedProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*identity.Identity, error) {
+	return nil, errors.New("invalid_grant")
+}
+
+func setupOIDCRouter(t *testing.T, queries ...fakeQuery) (*gin.Engine, *fakeDB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, queries...)
+	cfg := &config.Config{}
+	cfg.Auth.OIDC = map[string]config.OIDCProviderConfig{
+		"corporate": {RedirectURL: "https://gateway.test/api/v1/auth/oidc/corporate/callback"},
+	}
+	h := &AuthHandler{
+		db:        db,
+		config:    cfg,
+		federated: map[string]identity.FederatedProvider{"corporate": fakeFederatedProvider{}},
+	}
+
+	router := gin.New()
+	router.GET("/api/v1/auth/oidc/:provider/login", h.OIDCLogin)
+	router.GET("/api/v1/auth/oidc/:provider/callback", h.OIDCCallback)
+	return router, fake
+}
+
+func TestOIDCLogin_SetsStateCookie(t *testing.T) {
+	router, _ := setupOIDCRouter(t, fakeQuery{match: `INSERT INTO "oidc_login_state"`, answer: rows([]driver.Value{})})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/auth/oidc/corporate/login", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected status 302, got %d", w.Code)
+	}
+	location, _ := url.Parse(w.Header().Get("Location"))
+	state := location.Query().Get("state")
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected a state cookie, got %v", cookies)
+	}
+	cookie := cookies[0]
+	if cookie.Name != oidcStateCookie || cookie.Value != state {
+		t.Errorf("Expected the state %q in the cookie, got %s=%q", state, cookie.Name, cookie.Value)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != oidcStateCookiePath {
+		t.Errorf("Expected an HttpOnly, Secure, SameSite=Lax cookie on %s, got %+v", oidcStateCookiePath, cookie)
+	}
+}
+
+func TestOIDCCallback_RequiresStateCookie(t *testing.T) {
+	tests := []struct {
+		name   string
+		cookie string
+	}{
+		{"no cookie", ""},
+		{"another sign-in", "attacker-state"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, fake := setupOIDCRouter(t)
+
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/auth/oidc/corporate/callback?state=victim-state&code=good-code", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401, got %d", w.Code)
+			}
+			if fake.ran("oidc_login_state") {
+				t.Error("Expected the state kept for the browser that started the sign-in")
+			}
+		})
+	}
+}
+
+func TestOIDCCallback_MatchingStateCookie(t *testing.T) {
+	router, fake := setupOIDCRouter(t, fakeQuery{
+		match:  `DELETE FROM "oidc_login_state"`,
+		answer: rows([]driver.Value{"nonce", "verifier", nil}),
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/auth/oidc/corporate/callback?state=the-state&code=bad-code", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "the-state"})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// The state is used up and the code goes to the provider, which rejects it
+	if !fake.ran("oidc_login_state") {
+		t.Fatal("Expected the state consumed")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the provider's rejection, got %d", w.Code)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the state cookie cleared, got %v", cookies)
+	}
+}
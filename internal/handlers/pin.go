@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// pinPattern matches a valid operator PIN of 4 to 6 digits
+var pinPattern = regexp.MustCompile(`^[0-9]{4,6}$`)
+
+// SetPinRequest represents the request body for setting an operator PIN
+type SetPinRequest struct {
+	Password string `json:"password" binding:"required"`
+	Pin      string `json:"pin" binding:"required"`
+}
+
+// PinLoginRequest represents the request body for operator PIN quick-login
+type PinLoginRequest struct {
+	OperatorID string `json:"operator_id" binding:"required"`
+	Pin        string `json:"pin" binding:"required"`
+	Scope      string `json:"scope,omitempty"`
+}
+
+// isValidPin checks that the PIN is made of 4 to 6 digits
+func isValidPin(pin string) bool {
+	return pinPattern.MatchString(pin)
+}
+
+// SetPin sets or replaces the PIN of the authenticated user.
+// The current password is required so a borrowed session cannot change the PIN.
+func (h *AuthHandler) SetPin(c *gin.Context) {
+	var req SetPinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if !isValidPin(req.Pin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN must be 4 to 6 digits"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		return
+	}
+
+	var hashedPassword string
+	err := h.db.QueryRow(`SELECT password_hash FROM "user" WHERE id = $1`, userID).Scan(&hashedPassword)
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)) != nil {
+		h.recordEvent(c, audit.EventSetPin, audit.OutcomeFailure, userID, c.GetString("username"), "invalid credentials")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	hashedPin, err := bcrypt.GenerateFromPassword([]byte(req.Pin), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process PIN"})
+		return
+	}
+
+	_, err = h.db.Exec(`
+		UPDATE "user"
+		SET pin_hash = $1, pin_failed_attempts = 0, pin_locked_until = NULL
+		WHERE id = $2`, string(hashedPin), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save PIN"})
+		return
+	}
+
+	h.recordEvent(c, audit.EventSetPin, audit.OutcomeSuccess, userID, c.GetString("username"), "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "PIN updated successfully"})
+}
+
+// PinLogin exchanges an authenticated kiosk device token, operator ID and PIN for user tokens
+func (h *AuthHandler) PinLogin(c *gin.Context) {
+	var req PinLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Request"})
+		return
+	}
+
+	deviceID := c.GetString("device_id")
+	active, storeID, err := h.lookupDevice(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device"})
+		return
+	}
+	if !active {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, "", "", "device inactive: "+deviceID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device is not registered or has been deactivated"})
+		return
+	}
+
+	// Failed attempts also count per device, so a device token cannot try a
+	// few PINs against every operator in turn
+	var deviceLockedUntil sql.NullTime
+	err = h.db.QueryRow(`SELECT pin_locked_until FROM "kiosk_device" WHERE id = $1`, deviceID).Scan(&deviceLockedUntil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device"})
+		return
+	}
+	if deviceLockedUntil.Valid && deviceLockedUntil.Time.After(time.Now()) {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, "", req.OperatorID, "device PIN locked: "+deviceID)
+		abortPinLocked(c, deviceLockedUntil.Time)
+		return
+	}
+
+	var username, email, firstName, lastName, role string
+	var pinHash, tenantID sql.NullString
+	var lockedUntil sql.NullTime
+	query := `
+		SELECT username, email, first_name, last_name, role, pin_hash, pin_locked_until, tenant_id
+		FROM "user"
+		WHERE id = $1 AND is_active`
+	err = h.db.QueryRow(query, req.OperatorID).Scan(&username, &email, &firstName, &lastName, &role, &pinHash, &lockedUntil, &tenantID)
+	if err != nil || !pinHash.Valid {
+		deviceLockedUntil, err := h.recordFailedDevicePinAttempt(deviceID)
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, "", req.OperatorID, "unknown or inactive operator or no PIN set")
+		if err == nil && deviceLockedUntil.Valid {
+			abortPinLocked(c, deviceLockedUntil.Time)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "PIN locked")
+		abortPinLocked(c, lockedUntil.Time)
+		return
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(pinHash.String), []byte(req.Pin)) != nil {
+		lockedUntil, err := h.recordFailedPinAttempt(req.OperatorID)
+		deviceLockedUntil, deviceErr := h.recordFailedDevicePinAttempt(deviceID)
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "invalid PIN")
+		if deviceErr == nil && deviceLockedUntil.Valid {
+			abortPinLocked(c, deviceLockedUntil.Time)
+			return
+		}
+		if err == nil && lockedUntil.Valid {
+			abortPinLocked(c, lockedUntil.Time)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
+
+	// The device counter is left to expire with its window, so an operator who
+	// knows their own PIN cannot clear the guesses made against other operators
+	h.db.Exec(`UPDATE "user" SET pin_failed_attempts = 0, pin_locked_until = NULL WHERE id = $1`, req.OperatorID)
+
+	// A kiosk installed in a store only accepts operators of that store
+	if storeID != "" {
+		member, err := h.isStoreMember(req.OperatorID, storeID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve store"})
+			return
+		}
+		if !member {
+			h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "store not allowed")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Operator is not assigned to this store"})
+			return
+		}
+	}
+
+	// Sessions on a kiosk get no more than the kiosk allows
+	deviceScopes, err := h.deviceScopes(deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve scope"})
+		return
+	}
+	scope, err := grantScope(req.Scope, h.roleScopes(role), deviceScopes)
+	if err != nil {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "invalid scope")
+		abortInvalidScope(c, err)
+		return
+	}
+
+	jkt, err := h.dpopProofKey(c, "")
+	if err != nil {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	accessUntil, err := h.accessUntil(role, storeID, time.Now())
+	if err == errOutsideAccessHours {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "outside access hours")
+		abortOutsideAccessHours(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access hours"})
+		return
+	}
+
+	claims := newUserClaims(req.OperatorID, username, email, firstName, lastName, role)
+	claims.DeviceID = deviceID
+	claims.TenantID = tenantID.String
+	claims.StoreID = storeID
+	claims.Scope = scope
+	claims.AccessUntil = accessUntil
+	tokenType := claims.bindToKey(jkt)
+
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	refreshToken, evicted, err := h.openSession(req.OperatorID, role, sessionContext{DeviceID: deviceID, StoreID: storeID, Scope: scope, DPoPJKT: jkt})
+	if limitErr, ok := err.(*sessionLimitError); ok {
+		h.recordEvent(c, audit.EventPinLogin, audit.OutcomeFailure, req.OperatorID, username, "session limit reached")
+		abortSessionLimit(c, limitErr)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new refresh token"})
+		return
+	}
+
+	h.recordEvictions(c, req.OperatorID, username, evicted)
+	h.recordEvent(c, audit.EventPinLogin, audit.OutcomeSuccess, req.OperatorID, username, "")
+
+	h.respondWithSession(c, LoginResponse{
+		AccessToken:     accessToken,
+		TokenType:       tokenType,
+		RefreshToken:    refreshToken,
+		ExpiresIn:       int64(accessTokenTTL.Seconds()),
+		Scope:           scope,
+		EvictedSessions: evicted,
+	}, h.wantsCookieSession(c))
+}
+
+// recordFailedPinAttempt increments the failed PIN counter of the operator and
+// locks the PIN once the configured maximum is reached, starting a fresh count
+// for when the lockout ends. The returned time is valid only when the operator
+// is now locked out.
+func (h *AuthHandler) recordFailedPinAttempt(userID string) (sql.NullTime, error) {
+	var lockedUntil sql.NullTime
+	lockout := time.Now().Add(time.Duration(h.config.Auth.Pin.LockoutMinutes) * time.Minute)
+
+	err := h.db.QueryRow(`
+		UPDATE "user"
+		SET pin_failed_attempts = CASE WHEN pin_failed_attempts + 1 >= $1 THEN 0 ELSE pin_failed_attempts + 1 END,
+		    pin_locked_until = CASE WHEN pin_failed_attempts + 1 >= $1 THEN $2 ELSE NULL END
+		WHERE id = $3
+		RETURNING pin_locked_until`, h.config.Auth.Pin.MaxAttempts, lockout, userID).Scan(&lockedUntil)
+
+	return lockedUntil, err
+}
+
+// recordFailedDevicePinAttempt counts a failed PIN attempt on the kiosk device
+// against the device's own maximum. The count only covers attempts within the
+// last lockout period: the first failure after that starts a new window. A
+// successful login does not reset it. The returned time is valid only when the
+// device is now locked out.
+func (h *AuthHandler) recordFailedDevicePinAttempt(deviceID string) (sql.NullTime, error) {
+	var lockedUntil sql.NullTime
+	window := time.Duration(h.config.Auth.Pin.LockoutMinutes) * time.Minute
+	now := time.Now()
+
+	err := h.db.QueryRow(`
+		UPDATE "kiosk_device"
+		SET pin_failed_attempts = CASE
+		        WHEN (CASE WHEN pin_failed_window_start > $3 THEN pin_failed_attempts + 1 ELSE 1 END) >= $1 THEN 0
+		        ELSE (CASE WHEN pin_failed_window_start > $3 THEN pin_failed_attempts + 1 ELSE 1 END)
+		    END,
+		    pin_failed_window_start = CASE WHEN pin_failed_window_start > $3 THEN pin_failed_window_start ELSE $2 END,
+		    pin_locked_until = CASE
+		        WHEN (CASE WHEN pin_failed_window_start > $3 THEN pin_failed_attempts + 1 ELSE 1 END) >= $1 THEN $4
+		        ELSE NULL
+		    END
+		WHERE id = $5
+		RETURNING pin_locked_until`, h.config.Auth.Pin.DeviceMaxAttempts, now, now.Add(-window), now.Add(window), deviceID).Scan(&lockedUntil)
+
+	return lockedUntil, err
+}
+
+// abortPinLocked responds that PIN login is locked until the given time
+func abortPinLocked(c *gin.Context, lockedUntil time.Time) {
+	c.AbortWithStatusJSON(http.StatusLocked, gin.H{
+		"error":        "Too many failed PIN attempts",
+		"locked_until": lockedUntil,
+	})
+}
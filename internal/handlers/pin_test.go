@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestIsValidPin(t *testing.T) {
+	tests := []struct {
+		pin  string
+		want bool
+	}{
+		{"1234", true},
+		{"12345", true},
+		{"123456", true},
+		{"123", false},
+		{"1234567", false},
+		{"12a4", false},
+		{" 1234", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidPin(tt.pin); got != tt.want {
+			t.Errorf("isValidPin(%q) = %v, want %v", tt.pin, got, tt.want)
+		}
+	}
+}
+
+// failedPinCounter answers the failed PIN updates the way the database does:
+// the attempt that reaches the maximum locks and starts a fresh count
+func failedPinCounter() func(args []driver.Value) ([][]driver.Value, error) {
+	attempts := make(map[driver.Value]int64)
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		maxAttempts, lockout, id := args[0].(int64), args[1], args[2]
+		attempts[id]++
+		if attempts[id] >= maxAttempts {
+			attempts[id] = 0
+			return [][]driver.Value{{lockout}}, nil
+		}
+		return [][]driver.Value{{nil}}, nil
+	}
+}
+
+// deviceFailedPinCounter answers the failed device PIN updates the way the
+// database does: attempts before the window cutoff no longer count, and the
+// attempt that reaches the maximum locks and starts a fresh count. The counter
+// starts with the given attempts made at windowStart.
+func deviceFailedPinCounter(attempts int64, windowStart time.Time) func(args []driver.Value) ([][]driver.Value, error) {
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		maxAttempts, now, cutoff, lockout := args[0].(int64), args[1].(time.Time), args[2].(time.Time), args[3]
+		if windowStart.After(cutoff) {
+			attempts++
+		} else {
+			attempts, windowStart = 1, now
+		}
+		if attempts >= maxAttempts {
+			attempts = 0
+			return [][]driver.Value{{lockout}}, nil
+		}
+		return [][]driver.Value{{nil}}, nil
+	}
+}
+
+// pinOperators answers the operator lookup with operators whose PIN is 1234
+func pinOperators(t *testing.T, lockedUntil driver.Value) func(args []driver.Value) ([][]driver.Value, error) {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("1234"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(args []driver.Value) ([][]driver.Value, error) {
+		if args[0] == "unknown" {
+			return nil, nil
+		}
+		return [][]driver.Value{{"cashier1", "cashier1@example.com", "Cashier", "One", "cashier", string(hash), lockedUntil, nil}}, nil
+	}
+}
+
+func setupPinRouter(t *testing.T, queries ...fakeQuery) (*gin.Engine, *fakeDB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, queries...)
+	cfg := &config.Config{}
+	cfg.Auth.Pin.MaxAttempts = 3
+	cfg.Auth.Pin.DeviceMaxAttempts = 5
+	cfg.Auth.Pin.LockoutMinutes = 15
+	h := &AuthHandler{db: db, config: cfg}
+
+	router := gin.New()
+	router.POST("/api/v1/auth/pin-login", func(c *gin.Context) {
+		c.Set("device_id", "kiosk-1")
+		c.Next()
+	}, h.PinLogin)
+	return router, fake
+}
+
+// pinLogin posts a PIN login and returns the status and locked_until of the response
+func pinLogin(router *gin.Engine, operatorID, pin string) (int, string) {
+	body, _ := json.Marshal(PinLoginRequest{OperatorID: operatorID, Pin: pin})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/pin-login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var resp struct {
+		LockedUntil string `json:"locked_until"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w.Code, resp.LockedUntil
+}
+
+func TestPinLogin_LocksOperatorAtMaxAttempts(t *testing.T) {
+	router, _ := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{nil})},
+		fakeQuery{match: `SELECT username, email`, answer: pinOperators(t, nil)},
+		fakeQuery{match: `UPDATE "user"`, answer: failedPinCounter()},
+		fakeQuery{match: `UPDATE "kiosk_device"`, answer: deviceFailedPinCounter(0, time.Time{})},
+	)
+
+	for attempt := 1; attempt < 3; attempt++ {
+		if code, _ := pinLogin(router, "operator-1", "9999"); code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected status 401, got %d", attempt, code)
+		}
+	}
+
+	code, lockedUntil := pinLogin(router, "operator-1", "9999")
+	if code != http.StatusLocked {
+		t.Fatalf("Expected status 423 at the maximum, got %d", code)
+	}
+	until, err := time.Parse(time.RFC3339Nano, lockedUntil)
+	if err != nil || until.Before(time.Now().Add(14*time.Minute)) {
+		t.Errorf("Expected locked_until about 15 minutes ahead, got %q", lockedUntil)
+	}
+}
+
+func TestPinLogin_LockedOperator(t *testing.T) {
+	lockedUntil := time.Now().Add(10 * time.Minute).UTC()
+	router, fake := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{nil})},
+		fakeQuery{match: `SELECT username, email`, answer: pinOperators(t, lockedUntil)},
+	)
+
+	// Even the right PIN is refused until the lockout ends
+	code, got := pinLogin(router, "operator-1", "1234")
+	if code != http.StatusLocked {
+		t.Fatalf("Expected status 423, got %d", code)
+	}
+	if until, err := time.Parse(time.RFC3339Nano, got); err != nil || !until.Equal(lockedUntil) {
+		t.Errorf("Expected locked_until %v, got %q", lockedUntil, got)
+	}
+	if fake.ran("UPDATE") {
+		t.Error("Expected no attempt counted while locked")
+	}
+}
+
+func TestPinLogin_LocksDeviceAcrossOperators(t *testing.T) {
+	router, _ := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{nil})},
+		fakeQuery{match: `SELECT username, email`, answer: pinOperators(t, nil)},
+		fakeQuery{match: `UPDATE "user"`, answer: failedPinCounter()},
+		fakeQuery{match: `UPDATE "kiosk_device"`, answer: deviceFailedPinCounter(0, time.Time{})},
+	)
+
+	// One guess per operator stays under the operator limit, not the device one
+	operators := []string{"operator-1", "operator-2", "unknown", "operator-3"}
+	for _, operatorID := range operators {
+		if code, _ := pinLogin(router, operatorID, "9999"); code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected status 401, got %d", operatorID, code)
+		}
+	}
+
+	code, lockedUntil := pinLogin(router, "operator-4", "9999")
+	if code != http.StatusLocked || lockedUntil == "" {
+		t.Fatalf("Expected status 423 with locked_until at the device maximum, got %d %q", code, lockedUntil)
+	}
+}
+
+func TestPinLogin_LockedDevice(t *testing.T) {
+	router, fake := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{time.Now().Add(time.Minute)})},
+	)
+
+	if code, lockedUntil := pinLogin(router, "operator-1", "1234"); code != http.StatusLocked || lockedUntil == "" {
+		t.Errorf("Expected status 423 with locked_until, got %d %q", code, lockedUntil)
+	}
+	if fake.ran(`FROM "user"`) {
+		t.Error("Expected no operator looked up on a locked device")
+	}
+}
+
+func TestPinLogin_DeviceAttemptsExpireWithWindow(t *testing.T) {
+	// Four failures 20 minutes ago, outside the 15 minute window
+	router, _ := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, nil})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{nil})},
+		fakeQuery{match: `SELECT username, email`, answer: pinOperators(t, nil)},
+		fakeQuery{match: `UPDATE "user"`, answer: failedPinCounter()},
+		fakeQuery{match: `UPDATE "kiosk_device"`, answer: deviceFailedPinCounter(4, time.Now().Add(-20*time.Minute))},
+	)
+
+	if code, _ := pinLogin(router, "operator-1", "9999"); code != http.StatusUnauthorized {
+		t.Errorf("Expected the old attempts not to count, got %d", code)
+	}
+}
+
+func TestPinLogin_ResetsAttemptsOnSuccess(t *testing.T) {
+	router, fake := setupPinRouter(t,
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, "store-1"})},
+		fakeQuery{match: `SELECT pin_locked_until FROM "kiosk_device"`, answer: rows([]driver.Value{nil})},
+		fakeQuery{match: `SELECT username, email`, answer: pinOperators(t, nil)},
+		fakeQuery{match: `SET pin_failed_attempts = 0`, answer: rows([]driver.Value{})},
+		// Stop the login after the PIN is accepted
+		fakeQuery{match: `FROM user_store`, answer: rows([]driver.Value{false})},
+	)
+
+	if code, _ := pinLogin(router, "operator-1", "1234"); code != http.StatusForbidden {
+		t.Fatalf("Expected the store check after the PIN, got %d", code)
+	}
+	if !fake.ran(`UPDATE "user" SET pin_failed_attempts = 0`) {
+		t.Error("Expected the operator's failed attempts reset")
+	}
+	if fake.ran(`UPDATE "kiosk_device"`) {
+		t.Error("Expected the device's failed attempts kept until their window ends")
+	}
+}
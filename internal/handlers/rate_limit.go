@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestLimiter counts requests per key in fixed windows. Counts are kept in
+// memory, so each gateway instance limits on its own. A nil limiter allows
+// every request.
+type requestLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*limitWindow
+	lastSweep time.Time
+}
+
+// limitWindow is the count of one key in the current window
+type limitWindow struct {
+	start time.Time
+	count int
+}
+
+// newRequestLimiter returns a limiter allowing limit requests per key in each
+// window, or nil when limit is 0
+func newRequestLimiter(limit int, window time.Duration) *requestLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &requestLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*limitWindow),
+	}
+}
+
+// allow counts a request for the key and reports whether it is within the
+// limit. When it is not, it returns how long until the key's window ends.
+func (l *requestLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the keys whose window ended, once per window
+	if now.Sub(l.lastSweep) >= l.window {
+		for k, w := range l.windows {
+			if now.Sub(w.start) >= l.window {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &limitWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false, w.start.Add(l.window).Sub(now)
+	}
+	w.count++
+	return true, 0
+}
+
+// abortRateLimited responds that the client has made too many requests, and when to try again
+func abortRateLimited(c *gin.Context, message string, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": message})
+}
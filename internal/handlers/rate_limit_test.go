@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	l := newRequestLimiter(2, time.Hour)
+	now := time.Now()
+
+	for i := range 2 {
+		if ok, _ := l.allow("jane@example.com", now); !ok {
+			t.Fatalf("Expected request %d allowed", i+1)
+		}
+	}
+	ok, wait := l.allow("jane@example.com", now.Add(10*time.Minute))
+	if ok || wait != 50*time.Minute {
+		t.Errorf("Expected the third request limited for 50m, got %v, %s", ok, wait)
+	}
+
+	if ok, _ := l.allow("john@example.com", now); !ok {
+		t.Error("Expected another key counted on its own")
+	}
+	if ok, _ := l.allow("jane@example.com", now.Add(time.Hour)); !ok {
+		t.Error("Expected a new window to allow requests again")
+	}
+}
+
+func TestRequestLimiter_Unlimited(t *testing.T) {
+	l := newRequestLimiter(0, time.Hour)
+	for range 100 {
+		if ok, _ := l.allow("jane@example.com", time.Now()); !ok {
+			t.Fatal("Expected no limit")
+		}
+	}
+}
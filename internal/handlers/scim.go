@@ -0,0 +1,927 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SCIM schema URNs
+const (
+	scimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema    = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimProviderSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// SCIM list page size limits
+const (
+	defaultSCIMPageSize = 100
+	maxSCIMPageSize     = 1000
+)
+
+// scimContentType is the media type of SCIM responses
+const scimContentType = "application/scim+json; charset=utf-8"
+
+// scimActor is recorded in created_by and updated_by for changes made through SCIM
+const scimActor = "scim"
+
+// postgresUniqueViolation is the PostgreSQL error code for a unique constraint violation
+const postgresUniqueViolation = "23505"
+
+// scimGroups are the SCIM groups, one per gateway role
+var scimGroups = []string{RoleAdmin, RoleManager, RoleCashier}
+
+// SCIMHandler implements the SCIM 2.0 provisioning API for users and groups.
+// Groups map to gateway roles, so a user is a member of exactly one group.
+type SCIMHandler struct {
+	db *sql.DB
+}
+
+// NewSCIMHandler creates a new SCIM handler
+func NewSCIMHandler(db *sql.DB) *SCIMHandler {
+	return &SCIMHandler{
+		db: db,
+	}
+}
+
+// scimError is a SCIM error response
+type scimError struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *scimError) Error() string {
+	return e.Detail
+}
+
+// scimName represents the name attribute of a SCIM user
+type scimName struct {
+	GivenName  string `json:"givenName"`
+	FamilyName string `json:"familyName"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// scimEmail represents an entry of the emails attribute of a SCIM user
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// scimMember references a user from a group, or a group from a user
+type scimMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// scimMeta represents the meta attribute of a SCIM resource
+type scimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location"`
+}
+
+// scimUser represents a SCIM user resource
+type scimUser struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        scimName     `json:"name"`
+	DisplayName string       `json:"displayName"`
+	Emails      []scimEmail  `json:"emails"`
+	Active      bool         `json:"active"`
+	Groups      []scimMember `json:"groups"`
+	Meta        scimMeta     `json:"meta"`
+}
+
+// scimUserRequest represents the request body for creating or replacing a SCIM user
+type scimUserRequest struct {
+	ExternalID  string      `json:"externalId"`
+	UserName    string      `json:"userName"`
+	Name        scimName    `json:"name"`
+	DisplayName string      `json:"displayName"`
+	Emails      []scimEmail `json:"emails"`
+	Active      *bool       `json:"active"`
+	Password    string      `json:"password"`
+}
+
+// scimGroup represents a SCIM group resource
+type scimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id"`
+	DisplayName string       `json:"displayName"`
+	Members     []scimMember `json:"members,omitempty"`
+	Meta        scimMeta     `json:"meta"`
+}
+
+// scimGroupRequest represents the request body for replacing a SCIM group
+type scimGroupRequest struct {
+	DisplayName string       `json:"displayName"`
+	Members     []scimMember `json:"members"`
+}
+
+// scimListResponse represents a SCIM list response
+type scimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// ServiceProviderConfig describes the supported SCIM features
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{scimProviderSchema},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": maxSCIMPageSize},
+		"changePassword": gin.H{"supported": true},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Static bearer token configured in auth.scim.bearer_token",
+		}},
+	})
+}
+
+// ListUsers returns users matching the optional filter, one page at a time
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	startIndex, count, err := parseSCIMPagination(c)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	where := ""
+	var args []interface{}
+	if filter := c.Query("filter"); filter != "" {
+		parsed, err := parseSCIMFilter(filter)
+		if err != nil {
+			writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()})
+			return
+		}
+		condition, filterArgs, err := parsed.userSQL(nil)
+		if err != nil {
+			writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()})
+			return
+		}
+		where = " WHERE " + condition
+		args = filterArgs
+	}
+
+	var total int
+	if err := h.db.QueryRow(`SELECT COUNT(*) FROM "user"`+where, args...).Scan(&total); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	users := []scimUser{}
+	if count > 0 {
+		query := scimUserSelect + where + fmt.Sprintf(" ORDER BY created_at, id LIMIT %d OFFSET %d", count, startIndex-1)
+		rows, err := h.db.Query(query, args...)
+		if err != nil {
+			writeSCIMError(c, err)
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			user, err := scanSCIMUser(rows)
+			if err != nil {
+				writeSCIMError(c, err)
+				return
+			}
+			users = append(users, h.userResource(c, user))
+		}
+	}
+
+	scimJSON(c, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+// GetUser returns a single user
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.findUser(h.db, c.Param("id"), false)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, h.userResource(c, user))
+}
+
+// CreateUser provisions a new user with the cashier role. Without a password the
+// account gets a random one, so the user signs in through a federated provider.
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var req scimUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"})
+		return
+	}
+
+	attributes, err := req.attributes()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	hashedPassword, err := hashSCIMPassword(req.Password)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	var userID string
+	err = h.db.QueryRow(`
+		INSERT INTO "user" (username, email, first_name, last_name, password_hash, external_id, is_active, role, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		RETURNING id`,
+		attributes.UserName, attributes.Email, attributes.GivenName, attributes.FamilyName, hashedPassword,
+		nullString(attributes.ExternalID), attributes.Active, RoleCashier, scimActor).Scan(&userID)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	user, err := h.findUser(h.db, userID, false)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	resource := h.userResource(c, user)
+	c.Header("Location", resource.Meta.Location)
+	scimJSON(c, http.StatusCreated, resource)
+}
+
+// ReplaceUser replaces the user's attributes. Setting active to false deprovisions the user.
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var req scimUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"})
+		return
+	}
+
+	attributes, err := req.attributes()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	var hashedPassword string
+	if req.Password != "" {
+		if hashedPassword, err = hashSCIMPassword(req.Password); err != nil {
+			writeSCIMError(c, err)
+			return
+		}
+	}
+
+	h.updateUser(c, c.Param("id"), func(current *scimUserAttributes) error {
+		*current = *attributes
+		return nil
+	}, hashedPassword)
+}
+
+// PatchUser applies SCIM PATCH operations to the user. Setting active to false deprovisions the user.
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var req scimPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Operations) == 0 {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"})
+		return
+	}
+
+	h.updateUser(c, c.Param("id"), func(current *scimUserAttributes) error {
+		return current.applyPatch(req.Operations)
+	}, "")
+}
+
+// DeleteUser deprovisions the user. The account is deactivated rather than
+// removed, so audit records and order history keep pointing at it.
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := h.findUser(tx, c.Param("id"), true); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE "user" SET is_active = false, updated_by = $1 WHERE id = $2`, scimActor, c.Param("id")); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	if err := revokeSessions(tx, c.Param("id")); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// updateUser loads the user, lets change modify its attributes and writes them back.
+// A non-empty hashedPassword also replaces the password. Deactivated users lose their sessions.
+func (h *SCIMHandler) updateUser(c *gin.Context, userID string, change func(*scimUserAttributes) error, hashedPassword string) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	user, err := h.findUser(tx, userID, true)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	attributes := user.attributes
+	if err := change(&attributes); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	if err := attributes.validate(); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	_, err = tx.Exec(`
+		UPDATE "user"
+		SET username = $1, email = $2, first_name = $3, last_name = $4, external_id = $5, is_active = $6,
+			password_hash = COALESCE(NULLIF($7, ''), password_hash), updated_by = $8
+		WHERE id = $9`,
+		attributes.UserName, attributes.Email, attributes.GivenName, attributes.FamilyName,
+		nullString(attributes.ExternalID), attributes.Active, hashedPassword, scimActor, userID)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	if !attributes.Active {
+		if err := revokeSessions(tx, userID); err != nil {
+			writeSCIMError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	updated, err := h.findUser(h.db, userID, false)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, h.userResource(c, updated))
+}
+
+// ListGroups returns the groups matching the optional filter
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	startIndex, count, err := parseSCIMPagination(c)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	var filter scimFilter
+	if f := c.Query("filter"); f != "" {
+		if filter, err = parseSCIMFilter(f); err != nil {
+			writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()})
+			return
+		}
+	}
+
+	var matched []string
+	for _, role := range scimGroups {
+		if filter != nil {
+			ok, err := filter.matches(map[string]string{"id": role, "displayname": role})
+			if err != nil {
+				writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidFilter", Detail: err.Error()})
+				return
+			}
+			if !ok {
+				continue
+			}
+		}
+		matched = append(matched, role)
+	}
+
+	groups := []scimGroup{}
+	for i := startIndex - 1; i < len(matched) && len(groups) < count; i++ {
+		group, err := h.groupResource(c, matched[i])
+		if err != nil {
+			writeSCIMError(c, err)
+			return
+		}
+		groups = append(groups, group)
+	}
+
+	scimJSON(c, http.StatusOK, scimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: len(matched),
+		StartIndex:   startIndex,
+		ItemsPerPage: len(groups),
+		Resources:    groups,
+	})
+}
+
+// GetGroup returns a single group
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	role, err := scimGroupRole(c.Param("id"))
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	group, err := h.groupResource(c, role)
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, group)
+}
+
+// PatchGroup adds or removes group members. Adding a user to a group gives them
+// that role; removing a user from their role's group makes them a cashier.
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	role, err := scimGroupRole(c.Param("id"))
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	var req scimPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Operations) == 0 {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	for _, operation := range req.Operations {
+		if err := applyGroupPatch(tx, role, operation); err != nil {
+			writeSCIMError(c, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	h.GetGroup(c)
+}
+
+// ReplaceGroup sets the group's members
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	role, err := scimGroupRole(c.Param("id"))
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	var req scimGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: "Invalid request body"})
+		return
+	}
+	if req.DisplayName != "" && req.DisplayName != role {
+		writeSCIMError(c, &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "groups are fixed to gateway roles and cannot be renamed"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := replaceGroupMembers(tx, role, req.Members); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeSCIMError(c, err)
+		return
+	}
+
+	h.GetGroup(c)
+}
+
+// applyGroupPatch applies one PATCH operation to the members of the role's group
+func applyGroupPatch(tx *sql.Tx, role string, operation scimPatchOperation) error {
+	op := strings.ToLower(operation.Op)
+	path := strings.ToLower(operation.Path)
+
+	// Operations without a path carry the members in the value object
+	if path == "" && op != "remove" {
+		var value struct {
+			DisplayName string       `json:"displayName"`
+			Members     []scimMember `json:"members"`
+		}
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return invalidSCIMValue("members")
+		}
+		if value.DisplayName != "" && value.DisplayName != role {
+			return &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "groups are fixed to gateway roles and cannot be renamed"}
+		}
+		if value.Members == nil {
+			return nil
+		}
+		operation.Value, _ = json.Marshal(value.Members)
+		path = "members"
+	}
+
+	// remove with a path such as members[value eq "<id>"]
+	if op == "remove" && strings.HasPrefix(path, "members[") {
+		filter, err := parseSCIMFilter(strings.TrimSuffix(operation.Path[len("members["):], "]"))
+		if err != nil || len(filter) != 1 || filter[0].Attribute != "value" || filter[0].Operator != "eq" {
+			return &scimError{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: "unsupported member path"}
+		}
+		return removeGroupMembers(tx, role, []scimMember{{Value: filter[0].Value}})
+	}
+
+	if path != "members" {
+		if path == "displayname" {
+			return &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "groups are fixed to gateway roles and cannot be renamed"}
+		}
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidPath", Detail: fmt.Sprintf("unsupported path %q", operation.Path)}
+	}
+
+	var members []scimMember
+	if len(operation.Value) > 0 {
+		if err := json.Unmarshal(operation.Value, &members); err != nil {
+			return invalidSCIMValue("members")
+		}
+	}
+
+	switch op {
+	case "add":
+		return addGroupMembers(tx, role, members)
+	case "replace":
+		return replaceGroupMembers(tx, role, members)
+	case "remove":
+		if len(operation.Value) == 0 {
+			return replaceGroupMembers(tx, role, nil)
+		}
+		return removeGroupMembers(tx, role, members)
+	}
+	return &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: fmt.Sprintf("unsupported patch operation %q", operation.Op)}
+}
+
+// addGroupMembers gives the users the role
+func addGroupMembers(tx *sql.Tx, role string, members []scimMember) error {
+	for _, member := range members {
+		if _, err := uuid.Parse(member.Value); err != nil {
+			return invalidSCIMValue("members")
+		}
+
+		result, err := tx.Exec(`UPDATE "user" SET role = $1, updated_by = $2 WHERE id = $3`, role, scimActor, member.Value)
+		if err != nil {
+			return err
+		}
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: fmt.Sprintf("unknown user %s", member.Value)}
+		}
+	}
+	return nil
+}
+
+// removeGroupMembers moves users that have the role back to the cashier role
+func removeGroupMembers(tx *sql.Tx, role string, members []scimMember) error {
+	for _, member := range members {
+		if _, err := uuid.Parse(member.Value); err != nil {
+			return invalidSCIMValue("members")
+		}
+
+		_, err := tx.Exec(`UPDATE "user" SET role = $1, updated_by = $2 WHERE id = $3 AND role = $4`, RoleCashier, scimActor, member.Value, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceGroupMembers makes the members the only users with the role
+func replaceGroupMembers(tx *sql.Tx, role string, members []scimMember) error {
+	keep := make([]string, 0, len(members))
+	for _, member := range members {
+		if _, err := uuid.Parse(member.Value); err != nil {
+			return invalidSCIMValue("members")
+		}
+		keep = append(keep, member.Value)
+	}
+
+	_, err := tx.Exec(`
+		UPDATE "user" SET role = $1, updated_by = $2
+		WHERE role = $3 AND NOT (id::text = ANY($4))`,
+		RoleCashier, scimActor, role, pq.Array(keep))
+	if err != nil {
+		return err
+	}
+
+	return addGroupMembers(tx, role, members)
+}
+
+// revokeSessions deletes every refresh token of the user. Access tokens already
+// issued stay valid until they expire.
+func revokeSessions(tx *sql.Tx, userID string) error {
+	_, err := tx.Exec(`DELETE FROM refresh_tokens WHERE user_id = $1`, userID)
+	return err
+}
+
+// scimStoredUser is a user row as read for SCIM
+type scimStoredUser struct {
+	ID           string
+	Role         string
+	Created      sql.NullTime
+	LastModified sql.NullTime
+	attributes   scimUserAttributes
+}
+
+// scimUserSelect selects the columns read by scanSCIMUser
+const scimUserSelect = `SELECT id, username, external_id, first_name, last_name, email, is_active, role, created_at, updated_at FROM "user"`
+
+// scimQueryer is implemented by *sql.DB and *sql.Tx
+type scimQueryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// findUser loads a user by ID, locking the row when forUpdate is set
+func (h *SCIMHandler) findUser(q scimQueryer, userID string, forUpdate bool) (*scimStoredUser, error) {
+	notFound := &scimError{Status: http.StatusNotFound, Detail: fmt.Sprintf("User %s not found", userID)}
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, notFound
+	}
+
+	query := scimUserSelect + " WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	user, err := scanSCIMUser(q.QueryRow(query, userID))
+	if err == sql.ErrNoRows {
+		return nil, notFound
+	}
+	return user, err
+}
+
+// scanSCIMUser reads a row selected with scimUserSelect
+func scanSCIMUser(row interface{ Scan(...interface{}) error }) (*scimStoredUser, error) {
+	var user scimStoredUser
+	var externalID sql.NullString
+	err := row.Scan(&user.ID, &user.attributes.UserName, &externalID, &user.attributes.GivenName, &user.attributes.FamilyName,
+		&user.attributes.Email, &user.attributes.Active, &user.Role, &user.Created, &user.LastModified)
+	if err != nil {
+		return nil, err
+	}
+	user.attributes.ExternalID = externalID.String
+	return &user, nil
+}
+
+// userResource builds the SCIM representation of a user
+func (h *SCIMHandler) userResource(c *gin.Context, user *scimStoredUser) scimUser {
+	a := user.attributes
+	displayName := strings.TrimSpace(a.GivenName + " " + a.FamilyName)
+
+	resource := scimUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          user.ID,
+		ExternalID:  a.ExternalID,
+		UserName:    a.UserName,
+		Name:        scimName{GivenName: a.GivenName, FamilyName: a.FamilyName, Formatted: displayName},
+		DisplayName: displayName,
+		Emails:      []scimEmail{{Value: a.Email, Type: "work", Primary: true}},
+		Active:      a.Active,
+		Groups:      []scimMember{{Value: user.Role, Display: user.Role, Ref: scimLocation(c, "Groups", user.Role)}},
+		Meta: scimMeta{
+			ResourceType: "User",
+			Location:     scimLocation(c, "Users", user.ID),
+		},
+	}
+	if user.Created.Valid {
+		resource.Meta.Created = &user.Created.Time
+	}
+	if user.LastModified.Valid {
+		resource.Meta.LastModified = &user.LastModified.Time
+	}
+	return resource
+}
+
+// groupResource builds the SCIM representation of a role's group. Members are
+// left out when the client asks to exclude them.
+func (h *SCIMHandler) groupResource(c *gin.Context, role string) (scimGroup, error) {
+	group := scimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          role,
+		DisplayName: role,
+		Meta: scimMeta{
+			ResourceType: "Group",
+			Location:     scimLocation(c, "Groups", role),
+		},
+	}
+
+	if strings.Contains(strings.ToLower(c.Query("excludedAttributes")), "members") {
+		return group, nil
+	}
+
+	rows, err := h.db.Query(`SELECT id, username FROM "user" WHERE role = $1 ORDER BY username`, role)
+	if err != nil {
+		return group, err
+	}
+	defer rows.Close()
+
+	group.Members = []scimMember{}
+	for rows.Next() {
+		var member scimMember
+		if err := rows.Scan(&member.Value, &member.Display); err != nil {
+			return group, err
+		}
+		member.Ref = scimLocation(c, "Users", member.Value)
+		group.Members = append(group.Members, member)
+	}
+	return group, rows.Err()
+}
+
+// attributes validates the request and returns the user attributes it sets
+func (r *scimUserRequest) attributes() (*scimUserAttributes, error) {
+	attributes := &scimUserAttributes{
+		UserName:   strings.TrimSpace(r.UserName),
+		ExternalID: strings.TrimSpace(r.ExternalID),
+		GivenName:  strings.TrimSpace(r.Name.GivenName),
+		FamilyName: strings.TrimSpace(r.Name.FamilyName),
+		Email:      primarySCIMEmail(r.Emails),
+		Active:     r.Active == nil || *r.Active,
+	}
+
+	if attributes.GivenName == "" {
+		attributes.GivenName = strings.TrimSpace(r.DisplayName)
+	}
+	if attributes.GivenName == "" {
+		attributes.GivenName = attributes.UserName
+	}
+
+	return attributes, attributes.validate()
+}
+
+// validate applies the same rules as registration to the attributes
+func (a *scimUserAttributes) validate() error {
+	if a.UserName == "" {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "userName is required"}
+	}
+	if a.Email == "" {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "an email is required"}
+	}
+	if _, err := mail.ParseAddress(a.Email); err != nil {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "email is not a valid address"}
+	}
+	if a.GivenName == "" {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "name.givenName is required"}
+	}
+	return nil
+}
+
+// hashSCIMPassword hashes the password, or a random one when it is empty
+func hashSCIMPassword(password string) (string, error) {
+	if password == "" {
+		secret, err := generateDeviceSecret()
+		if err != nil {
+			return "", err
+		}
+		password = secret
+	} else if len(password) < 6 {
+		return "", &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "password must be at least 6 characters"}
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// scimGroupRole returns the role of the group ID, or a not found error
+func scimGroupRole(groupID string) (string, error) {
+	for _, role := range scimGroups {
+		if role == groupID {
+			return role, nil
+		}
+	}
+	return "", &scimError{Status: http.StatusNotFound, Detail: fmt.Sprintf("Group %s not found", groupID)}
+}
+
+// parseSCIMPagination reads the 1-based startIndex and count query parameters
+func parseSCIMPagination(c *gin.Context) (int, int, error) {
+	startIndex := 1
+	if v := c.Query("startIndex"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "startIndex must be a number"}
+		}
+		if n > 1 {
+			startIndex = n
+		}
+	}
+
+	count := defaultSCIMPageSize
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "count must be a number"}
+		}
+		count = n
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxSCIMPageSize {
+		count = maxSCIMPageSize
+	}
+
+	return startIndex, count, nil
+}
+
+// scimLocation returns the absolute URL of a SCIM resource
+func scimLocation(c *gin.Context, resourceType, id string) string {
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s/scim/v2/%s/%s", scheme, c.Request.Host, resourceType, id)
+}
+
+// scimJSON writes a response with the SCIM media type
+func scimJSON(c *gin.Context, status int, v interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, v)
+}
+
+// writeSCIMError writes err as a SCIM error response. Unique constraint
+// violations become 409 uniqueness errors and anything else a 500.
+func writeSCIMError(c *gin.Context, err error) {
+	e, ok := err.(*scimError)
+	if !ok {
+		if pqErr, isPQ := err.(*pq.Error); isPQ && pqErr.Code == postgresUniqueViolation {
+			e = &scimError{Status: http.StatusConflict, ScimType: "uniqueness", Detail: "A user with this userName, email or externalId already exists"}
+		} else {
+			if gin.Mode() == "debug" {
+				fmt.Printf("Error during SCIM request : %s\n", err)
+			}
+			e = &scimError{Status: http.StatusInternalServerError, Detail: "Internal server error"}
+		}
+	}
+
+	body := gin.H{
+		"schemas": []string{scimErrorSchema},
+		"status":  strconv.Itoa(e.Status),
+		"detail":  e.Detail,
+	}
+	if e.ScimType != "" {
+		body["scimType"] = e.ScimType
+	}
+	scimJSON(c, e.Status, body)
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scimComparison is a single "attribute operator value" expression of a SCIM filter
+type scimComparison struct {
+	Attribute string // lower-cased attribute path
+	Operator  string // lower-cased operator
+	Value     string
+}
+
+// scimFilter is a parsed SCIM filter. Only comparisons joined by "and" are
+// supported, which covers the lookups provisioning clients make.
+type scimFilter []scimComparison
+
+// scimOperators lists the supported comparison operators
+var scimOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+// parseSCIMFilter parses a filter such as `userName eq "jane" and active eq true`
+func parseSCIMFilter(filter string) (scimFilter, error) {
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var result scimFilter
+	for i := 0; i < len(tokens); {
+		if len(result) > 0 {
+			if !strings.EqualFold(tokens[i], "and") {
+				return nil, fmt.Errorf("unsupported filter expression %q, only \"and\" is supported", tokens[i])
+			}
+			i++
+		}
+		if i+1 >= len(tokens) {
+			return nil, fmt.Errorf("incomplete filter expression")
+		}
+
+		attribute := tokens[i]
+		if strings.ContainsAny(attribute, "()[]") || strings.EqualFold(attribute, "not") {
+			return nil, fmt.Errorf("unsupported filter expression %q", attribute)
+		}
+
+		comparison := scimComparison{
+			Attribute: strings.ToLower(attribute),
+			Operator:  strings.ToLower(tokens[i+1]),
+		}
+		if !scimOperators[comparison.Operator] {
+			return nil, fmt.Errorf("unsupported filter operator %q", tokens[i+1])
+		}
+		i += 2
+
+		if comparison.Operator != "pr" {
+			if i >= len(tokens) {
+				return nil, fmt.Errorf("missing value for %s %s", attribute, comparison.Operator)
+			}
+			comparison.Value = tokens[i]
+			i++
+		}
+
+		result = append(result, comparison)
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("empty filter")
+	}
+	return result, nil
+}
+
+// tokenizeSCIMFilter splits a filter on whitespace, keeping quoted strings
+// together and unquoting them
+func tokenizeSCIMFilter(filter string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(filter); {
+		switch {
+		case filter[i] == ' ' || filter[i] == '\t':
+			i++
+		case filter[i] == '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, fmt.Errorf("unterminated string in filter")
+			}
+
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("invalid string in filter: %w", err)
+			}
+			tokens = append(tokens, value)
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && filter[end] != ' ' && filter[end] != '\t' {
+				end++
+			}
+			tokens = append(tokens, filter[i:end])
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// scimUserColumns maps lower-cased SCIM user attributes to "user" columns
+var scimUserColumns = map[string]string{
+	"id":                "id::text",
+	"username":          "username",
+	"externalid":        "external_id",
+	"emails":            "email",
+	"emails.value":      "email",
+	"name.givenname":    "first_name",
+	"name.familyname":   "last_name",
+	"active":            "is_active",
+	"meta.created":      "created_at",
+	"meta.lastmodified": "updated_at",
+}
+
+// userSQL translates the filter to a WHERE condition on the "user" table. Arguments
+// are appended to args and numbered after the ones already there.
+func (f scimFilter) userSQL(args []interface{}) (string, []interface{}, error) {
+	var conditions []string
+	for _, comparison := range f {
+		column, ok := scimUserColumns[comparison.Attribute]
+		if !ok {
+			return "", nil, fmt.Errorf("unsupported filter attribute %q", comparison.Attribute)
+		}
+
+		var condition string
+		var err error
+		switch column {
+		case "is_active":
+			condition, args, err = comparison.booleanSQL(column, args)
+		case "created_at", "updated_at":
+			condition, args, err = comparison.timeSQL(column, args)
+		default:
+			condition, args, err = comparison.stringSQL(column, args)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, condition)
+	}
+
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// stringSQL builds a case-insensitive string comparison
+func (s scimComparison) stringSQL(column string, args []interface{}) (string, []interface{}, error) {
+	if s.Operator == "pr" {
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column), args, nil
+	}
+
+	value := strings.ToLower(s.Value)
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+
+	var condition string
+	switch s.Operator {
+	case "eq":
+		args = append(args, value)
+		condition = "lower(%s) = $%d"
+	case "ne":
+		args = append(args, value)
+		condition = "lower(%s) IS DISTINCT FROM $%d"
+	case "co":
+		args = append(args, "%"+pattern+"%")
+		condition = "lower(%s) LIKE $%d"
+	case "sw":
+		args = append(args, pattern+"%")
+		condition = "lower(%s) LIKE $%d"
+	case "ew":
+		args = append(args, "%"+pattern)
+		condition = "lower(%s) LIKE $%d"
+	default:
+		return "", nil, fmt.Errorf("operator %q is not supported for %s", s.Operator, s.Attribute)
+	}
+
+	return fmt.Sprintf(condition, column, len(args)), args, nil
+}
+
+// booleanSQL builds an equality comparison on a boolean column
+func (s scimComparison) booleanSQL(column string, args []interface{}) (string, []interface{}, error) {
+	if s.Operator == "pr" {
+		return "true", args, nil
+	}
+
+	value, err := parseSCIMBool(s.Value)
+	if err != nil {
+		return "", nil, err
+	}
+
+	switch s.Operator {
+	case "eq":
+		args = append(args, value)
+	case "ne":
+		args = append(args, !value)
+	default:
+		return "", nil, fmt.Errorf("operator %q is not supported for %s", s.Operator, s.Attribute)
+	}
+
+	return fmt.Sprintf("%s = $%d", column, len(args)), args, nil
+}
+
+// timeSQL builds a comparison on a timestamp column
+func (s scimComparison) timeSQL(column string, args []interface{}) (string, []interface{}, error) {
+	if s.Operator == "pr" {
+		return fmt.Sprintf("%s IS NOT NULL", column), args, nil
+	}
+
+	value, err := time.Parse(time.RFC3339, s.Value)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s must be an RFC3339 timestamp", s.Attribute)
+	}
+
+	operators := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+	operator, ok := operators[s.Operator]
+	if !ok {
+		return "", nil, fmt.Errorf("operator %q is not supported for %s", s.Operator, s.Attribute)
+	}
+
+	args = append(args, value)
+	return fmt.Sprintf("%s %s $%d", column, operator, len(args)), args, nil
+}
+
+// matches evaluates the filter against string attributes held in memory.
+// Attribute names must be lower-cased; unknown attributes are an error.
+func (f scimFilter) matches(attributes map[string]string) (bool, error) {
+	for _, comparison := range f {
+		value, ok := attributes[comparison.Attribute]
+		if !ok {
+			return false, fmt.Errorf("unsupported filter attribute %q", comparison.Attribute)
+		}
+
+		value = strings.ToLower(value)
+		expected := strings.ToLower(comparison.Value)
+
+		var matched bool
+		switch comparison.Operator {
+		case "eq":
+			matched = value == expected
+		case "ne":
+			matched = value != expected
+		case "co":
+			matched = strings.Contains(value, expected)
+		case "sw":
+			matched = strings.HasPrefix(value, expected)
+		case "ew":
+			matched = strings.HasSuffix(value, expected)
+		case "pr":
+			matched = value != ""
+		default:
+			return false, fmt.Errorf("operator %q is not supported for %s", comparison.Operator, comparison.Attribute)
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseSCIMBool parses a boolean sent as a JSON boolean or, as some clients
+// do, as the string "True" or "False"
+func parseSCIMBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
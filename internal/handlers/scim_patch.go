@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// scimUserSchemaPrefix is stripped from fully qualified attribute paths
+const scimUserSchemaPrefix = "urn:ietf:params:scim:schemas:core:2.0:user:"
+
+// scimPatchOperation is a single operation of a SCIM PATCH request
+type scimPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// scimPatchRequest represents the request body of a SCIM PATCH
+type scimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []scimPatchOperation `json:"Operations"`
+}
+
+// scimUserAttributes holds the user attributes that can be changed through SCIM
+type scimUserAttributes struct {
+	UserName   string
+	ExternalID string
+	GivenName  string
+	FamilyName string
+	Email      string
+	Active     bool
+}
+
+// applyPatch applies PATCH operations to the attributes. Attributes the gateway
+// does not store, such as title or phone numbers, are ignored so provisioning
+// clients that send their full profile keep working.
+func (u *scimUserAttributes) applyPatch(operations []scimPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		path := strings.TrimPrefix(strings.ToLower(operation.Path), scimUserSchemaPrefix)
+
+		switch op {
+		case "add", "replace":
+			if path == "" {
+				if err := u.applyObject(operation.Value); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := u.set(path, operation.Value); err != nil {
+				return err
+			}
+		case "remove":
+			if err := u.remove(path); err != nil {
+				return err
+			}
+		default:
+			return &scimError{Status: http.StatusBadRequest, ScimType: "invalidSyntax", Detail: fmt.Sprintf("unsupported patch operation %q", operation.Op)}
+		}
+	}
+	return nil
+}
+
+// applyObject applies an operation without a path, whose value holds attributes by name
+func (u *scimUserAttributes) applyObject(value json.RawMessage) error {
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(value, &attributes); err != nil {
+		return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: "patch value without a path must be an object"}
+	}
+
+	for name, attributeValue := range attributes {
+		if err := u.set(strings.TrimPrefix(strings.ToLower(name), scimUserSchemaPrefix), attributeValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set replaces the attribute at the lower-cased path
+func (u *scimUserAttributes) set(path string, value json.RawMessage) error {
+	switch {
+	case path == "active":
+		active, err := scimBoolValue(value)
+		if err != nil {
+			return invalidSCIMValue(path)
+		}
+		u.Active = active
+	case path == "username":
+		return setSCIMString(&u.UserName, path, value, true)
+	case path == "externalid":
+		return setSCIMString(&u.ExternalID, path, value, false)
+	case path == "name.givenname":
+		return setSCIMString(&u.GivenName, path, value, true)
+	case path == "name.familyname":
+		return setSCIMString(&u.FamilyName, path, value, false)
+	case path == "name":
+		var name scimName
+		if err := json.Unmarshal(value, &name); err != nil {
+			return invalidSCIMValue(path)
+		}
+		if name.GivenName != "" {
+			u.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			u.FamilyName = name.FamilyName
+		}
+	case path == "emails":
+		var emails []scimEmail
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return invalidSCIMValue(path)
+		}
+		if email := primarySCIMEmail(emails); email != "" {
+			u.Email = email
+		}
+	case strings.HasPrefix(path, "emails"):
+		// emails.value, emails[type eq "work"].value and similar address the single email
+		return setSCIMString(&u.Email, path, value, true)
+	case path == "groups":
+		return &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: "groups are changed through the Groups endpoint"}
+	}
+	return nil
+}
+
+// remove clears the attribute at the lower-cased path. Only optional attributes can be removed.
+func (u *scimUserAttributes) remove(path string) error {
+	switch path {
+	case "externalid":
+		u.ExternalID = ""
+	case "name.familyname":
+		u.FamilyName = ""
+	case "username", "name.givenname", "emails", "active":
+		return &scimError{Status: http.StatusBadRequest, ScimType: "mutability", Detail: fmt.Sprintf("%s is required and cannot be removed", path)}
+	case "":
+		return &scimError{Status: http.StatusBadRequest, ScimType: "noTarget", Detail: "remove requires a path"}
+	}
+	return nil
+}
+
+// setSCIMString decodes a string value into target, optionally rejecting empty values
+func setSCIMString(target *string, path string, value json.RawMessage, required bool) error {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return invalidSCIMValue(path)
+	}
+	s = strings.TrimSpace(s)
+	if required && s == "" {
+		return invalidSCIMValue(path)
+	}
+	*target = s
+	return nil
+}
+
+// scimBoolValue decodes a boolean sent as a JSON boolean or as a string
+func scimBoolValue(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	return parseSCIMBool(s)
+}
+
+// primarySCIMEmail returns the primary email, or the first one when none is marked primary
+func primarySCIMEmail(emails []scimEmail) string {
+	for _, email := range emails {
+		if email.Primary {
+			return strings.TrimSpace(email.Value)
+		}
+	}
+	if len(emails) > 0 {
+		return strings.TrimSpace(emails[0].Value)
+	}
+	return ""
+}
+
+// invalidSCIMValue reports a value that does not fit the attribute
+func invalidSCIMValue(path string) error {
+	return &scimError{Status: http.StatusBadRequest, ScimType: "invalidValue", Detail: fmt.Sprintf("invalid value for %s", path)}
+}
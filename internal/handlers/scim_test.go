@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	filter, err := parseSCIMFilter(`userName eq "Jane \"JD\" Doe" and active eq true and externalId pr`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := scimFilter{
+		{Attribute: "username", Operator: "eq", Value: `Jane "JD" Doe`},
+		{Attribute: "active", Operator: "eq", Value: "true"},
+		{Attribute: "externalid", Operator: "pr"},
+	}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, filter)
+	}
+}
+
+func TestParseSCIMFilter_Unsupported(t *testing.T) {
+	filters := []string{
+		``,
+		`userName eq "a" or userName eq "b"`,
+		`not (userName eq "a")`,
+		`emails[type eq "work"]`,
+		`userName regex "a"`,
+		`userName eq`,
+		`userName eq "unterminated`,
+	}
+	for _, filter := range filters {
+		if _, err := parseSCIMFilter(filter); err == nil {
+			t.Errorf("Expected error for %q", filter)
+		}
+	}
+}
+
+func TestSCIMFilterUserSQL(t *testing.T) {
+	filter, err := parseSCIMFilter(`userName eq "Jane" and emails.value co "50%" and active eq "False" and meta.lastModified gt "2024-01-01T00:00:00Z"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	where, args, err := filter.userSQL(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "lower(username) = $1 AND lower(email) LIKE $2 AND is_active = $3 AND updated_at > $4"
+	if where != expected {
+		t.Errorf("Expected %q, got %q", expected, where)
+	}
+	if args[0] != "jane" || args[1] != `%50\%%` || args[2] != false {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+}
+
+func TestSCIMFilterUserSQL_InvalidAttribute(t *testing.T) {
+	for _, f := range []string{`password eq "x"`, `active co "t"`, `meta.created gt "yesterday"`, `userName gt "a"`} {
+		filter, err := parseSCIMFilter(f)
+		if err != nil {
+			t.Fatalf("Unexpected parse error for %q: %v", f, err)
+		}
+		if _, _, err := filter.userSQL(nil); err == nil {
+			t.Errorf("Expected error for %q", f)
+		}
+	}
+}
+
+func TestSCIMFilterMatches(t *testing.T) {
+	group := map[string]string{"id": "manager", "displayname": "manager"}
+
+	tests := map[string]bool{
+		`displayName eq "Manager"`: true,
+		`displayName sw "man"`:     true,
+		`id ne "manager"`:          false,
+		`displayName eq "admin"`:   false,
+	}
+	for f, want := range tests {
+		filter, err := parseSCIMFilter(f)
+		if err != nil {
+			t.Fatalf("Unexpected parse error for %q: %v", f, err)
+		}
+		got, err := filter.matches(group)
+		if err != nil || got != want {
+			t.Errorf("%q: expected %v, got %v (err %v)", f, want, got, err)
+		}
+	}
+}
+
+func newSCIMUserAttributes() scimUserAttributes {
+	return scimUserAttributes{
+		UserName:   "jane",
+		ExternalID: "hr-1",
+		GivenName:  "Jane",
+		FamilyName: "Doe",
+		Email:      "jane@example.com",
+		Active:     true,
+	}
+}
+
+func decodePatch(t *testing.T, body string) []scimPatchOperation {
+	t.Helper()
+	var req scimPatchRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatalf("Invalid patch body: %v", err)
+	}
+	return req.Operations
+}
+
+func TestSCIMUserApplyPatch(t *testing.T) {
+	attributes := newSCIMUserAttributes()
+
+	err := attributes.applyPatch(decodePatch(t, `{"Operations": [
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jane.doe@example.com"},
+		{"op": "replace", "value": {"name.familyName": "Smith", "title": "Store Lead"}},
+		{"op": "remove", "path": "externalId"}
+	]}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := scimUserAttributes{
+		UserName:   "jane",
+		GivenName:  "Jane",
+		FamilyName: "Smith",
+		Email:      "jane.doe@example.com",
+		Active:     false,
+	}
+	if attributes != expected {
+		t.Errorf("Expected %+v, got %+v", expected, attributes)
+	}
+}
+
+func TestSCIMUserApplyPatch_Invalid(t *testing.T) {
+	patches := []string{
+		`{"Operations": [{"op": "remove", "path": "userName"}]}`,
+		`{"Operations": [{"op": "replace", "path": "active", "value": "maybe"}]}`,
+		`{"Operations": [{"op": "replace", "path": "userName", "value": ""}]}`,
+		`{"Operations": [{"op": "add", "path": "groups", "value": [{"value": "admin"}]}]}`,
+		`{"Operations": [{"op": "move", "path": "userName", "value": "x"}]}`,
+	}
+	for _, patch := range patches {
+		attributes := newSCIMUserAttributes()
+		err := attributes.applyPatch(decodePatch(t, patch))
+		if _, ok := err.(*scimError); !ok {
+			t.Errorf("Expected SCIM error for %s, got %v", patch, err)
+		}
+	}
+}
+
+func TestSCIMUserAttributesValidate(t *testing.T) {
+	attributes := newSCIMUserAttributes()
+	if err := attributes.validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	attributes.Email = "not-an-email"
+	if err := attributes.validate(); err == nil {
+		t.Error("Expected error for invalid email")
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Scopes that can be granted on access tokens
+const (
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+	ScopeInventoryRead  = "inventory:read"
+	ScopeInventoryWrite = "inventory:write"
+	ScopePaymentsRead   = "payments:read"
+	ScopePaymentsWrite  = "payments:write"
+	ScopePaymentsRefund = "payments:refund"
+)
+
+// AllScopes lists every scope the gateway knows, in the order tokens carry them
+var AllScopes = []string{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeInventoryRead,
+	ScopeInventoryWrite,
+	ScopePaymentsRead,
+	ScopePaymentsWrite,
+	ScopePaymentsRefund,
+}
+
+// defaultGuestScopes are the scopes of anonymous guest sessions unless configured
+var defaultGuestScopes = []string{
+	ScopeOrdersRead,
+	ScopeOrdersWrite,
+	ScopeInventoryRead,
+	ScopePaymentsRead,
+	ScopePaymentsWrite,
+}
+
+// invalidScopeError is returned when a token request asks for a scope that is
+// unknown or not allowed for the user or client
+type invalidScopeError struct {
+	Scope string
+}
+
+func (e *invalidScopeError) Error() string {
+	return fmt.Sprintf("scope %q is not allowed", e.Scope)
+}
+
+// isKnownScope reports whether the gateway knows the scope
+func isKnownScope(scope string) bool {
+	for _, known := range AllScopes {
+		if known == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// roleScopes returns the scopes users with the role may be granted. Roles
+// without a configured list may be granted every scope.
+func (h *AuthHandler) roleScopes(role string) []string {
+	if scopes, ok := h.config.Auth.Scopes.Roles[role]; ok {
+		return scopes
+	}
+	return AllScopes
+}
+
+// guestScopes returns the scopes anonymous guest sessions are granted
+func (h *AuthHandler) guestScopes() []string {
+	if len(h.config.Auth.Scopes.Guest) > 0 {
+		return h.config.Auth.Scopes.Guest
+	}
+	return defaultGuestScopes
+}
+
+// deviceScopes returns the scopes sessions opened on the kiosk device are
+// limited to, or nil when the device does not limit them
+func (h *AuthHandler) deviceScopes(deviceID string) ([]string, error) {
+	if deviceID == "" {
+		return nil, nil
+	}
+
+	var scopes pq.StringArray
+	err := h.db.QueryRow(`SELECT scopes FROM "kiosk_device" WHERE id = $1`, deviceID).Scan(&scopes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return scopes, err
+}
+
+// grantScope returns the space-separated scope to put on a token. Without a
+// request every allowed scope is granted; a request may only narrow it down.
+// Each limit further restricts the allowed scopes, and a nil limit is ignored.
+func grantScope(requested string, allowed []string, limits ...[]string) (string, error) {
+	permitted := make(map[string]bool)
+	for _, scope := range allowed {
+		permitted[scope] = true
+	}
+	for _, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		within := make(map[string]bool)
+		for _, scope := range limit {
+			within[scope] = permitted[scope]
+		}
+		permitted = within
+	}
+
+	wanted := strings.Fields(requested)
+	for _, scope := range wanted {
+		if !permitted[scope] {
+			return "", &invalidScopeError{Scope: scope}
+		}
+	}
+	if len(wanted) == 0 {
+		wanted = AllScopes
+	}
+
+	// Keep the canonical order and drop duplicates
+	var granted []string
+	for _, scope := range AllScopes {
+		if !permitted[scope] {
+			continue
+		}
+		for _, w := range wanted {
+			if w == scope {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+	return strings.Join(granted, " "), nil
+}
+
+// scopeLimit turns a granted scope into a limit for grantScope. Sessions from
+// before scopes existed have none, and are not limited.
+func scopeLimit(scope string) []string {
+	if scope == "" {
+		return nil
+	}
+	return strings.Fields(scope)
+}
+
+// abortInvalidScope rejects a token request for a scope the subject may not have
+func abortInvalidScope(c *gin.Context, err error) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error":   "Invalid scope",
+		"details": err.Error(),
+	})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import "testing"
+
+func TestGrantScope(t *testing.T) {
+	cashier := []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeInventoryRead}
+
+	tests := []struct {
+		name      string
+		requested string
+		allowed   []string
+		limits    [][]string
+		want      string
+	}{
+		{"everything allowed by default", "", cashier, nil, "orders:read orders:write inventory:read"},
+		{"narrowed by request", "inventory:read orders:read", cashier, nil, "orders:read inventory:read"},
+		{"duplicates dropped", "orders:read orders:read", cashier, nil, "orders:read"},
+		{"limited by device", "", cashier, [][]string{{ScopeOrdersRead, ScopeInventoryWrite}}, "orders:read"},
+		{"nil limit ignored", "", cashier, [][]string{nil}, "orders:read orders:write inventory:read"},
+		{"empty limit grants nothing", "", cashier, [][]string{{}}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := grantScope(tt.requested, tt.allowed, tt.limits...)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGrantScope_NotAllowed(t *testing.T) {
+	cashier := []string{ScopeOrdersRead, ScopeOrdersWrite}
+
+	for _, requested := range []string{"payments:refund", "orders:read unknown:scope"} {
+		if _, err := grantScope(requested, cashier); err == nil {
+			t.Errorf("Expected %q to be rejected", requested)
+		}
+	}
+
+	if _, err := grantScope("orders:write", cashier, []string{ScopeOrdersRead}); err == nil {
+		t.Error("Expected a scope outside the device limit to be rejected")
+	}
+}
+
+func TestScopeLimit(t *testing.T) {
+	if scopeLimit("") != nil {
+		t.Error("Expected no limit for sessions without a scope")
+	}
+	if got := scopeLimit("orders:read inventory:read"); len(got) != 2 {
+		t.Errorf("Expected two scopes, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cookie session mode for browser clients. The refresh token lives in an
+// HttpOnly cookie the page cannot read, and requests authenticated by it must
+// echo the readable CSRF cookie in the CSRF header (double-submit).
+const (
+	RefreshTokenCookie = "refresh_token"
+	CSRFCookie         = "csrf_token"
+	CSRFHeader         = "X-CSRF-Token"
+
+	// SessionModeHeader set to SessionModeCookie asks for a cookie session at login
+	SessionModeHeader = "X-Session-Mode"
+	SessionModeCookie = "cookie"
+)
+
+// refreshCookiePath limits the refresh token cookie to the auth endpoints
+const refreshCookiePath = "/api/v1/auth"
+
+// errCSRFMismatch is returned when a cookie-authenticated request lacks a matching CSRF token
+var errCSRFMismatch = errors.New("missing or mismatched CSRF token")
+
+// wantsCookieSession reports whether the client asked for a cookie session and the mode is enabled
+func (h *AuthHandler) wantsCookieSession(c *gin.Context) bool {
+	return h.config.Auth.SessionCookie.Enabled && strings.EqualFold(c.GetHeader(SessionModeHeader), SessionModeCookie)
+}
+
+// respondWithSession sends newly issued session tokens. For a cookie session the
+// refresh token is set as a cookie instead of being returned, together with a
+// fresh CSRF token.
+func (h *AuthHandler) respondWithSession(c *gin.Context, resp LoginResponse, cookieSession bool) {
+	if cookieSession {
+		csrfToken, err := randomURLToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+			return
+		}
+		h.setSessionCookies(c, resp.RefreshToken, csrfToken)
+		resp.RefreshToken = ""
+		resp.CSRFToken = csrfToken
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// presentedRefreshToken returns the refresh token sent in the request body or,
+// for POST requests in cookie mode, the one in the refresh token cookie. Using
+// the cookie requires the CSRF header to match the CSRF cookie.
+func (h *AuthHandler) presentedRefreshToken(c *gin.Context, bodyToken string) (token string, fromCookie bool, err error) {
+	if bodyToken != "" {
+		return bodyToken, false, nil
+	}
+
+	// GET requests can be triggered by any site through a link, so cookies
+	// are only accepted on POST
+	if !h.config.Auth.SessionCookie.Enabled || c.Request.Method != http.MethodPost {
+		return "", false, nil
+	}
+
+	token, cookieErr := c.Cookie(RefreshTokenCookie)
+	if cookieErr != nil || token == "" {
+		return "", false, nil
+	}
+
+	csrfCookie, _ := c.Cookie(CSRFCookie)
+	csrfHeader := c.GetHeader(CSRFHeader)
+	if csrfCookie == "" || subtle.ConstantTimeCompare([]byte(csrfCookie), []byte(csrfHeader)) != 1 {
+		return "", true, errCSRFMismatch
+	}
+	return token, true, nil
+}
+
+// setSessionCookies sets the refresh token and CSRF cookies
+func (h *AuthHandler) setSessionCookies(c *gin.Context, refreshToken, csrfToken string) {
+	cfg := h.config.Auth.SessionCookie
+	maxAge := int(refreshTokenTTL.Seconds())
+
+	c.SetSameSite(sessionCookieSameSite(cfg.SameSite))
+	c.SetCookie(RefreshTokenCookie, refreshToken, maxAge, refreshCookiePath, cfg.Domain, cfg.Secure, true)
+	// The page reads the CSRF cookie to echo it, so it is not HttpOnly
+	c.SetCookie(CSRFCookie, csrfToken, maxAge, "/", cfg.Domain, cfg.Secure, false)
+}
+
+// clearSessionCookies removes the refresh token and CSRF cookies
+func (h *AuthHandler) clearSessionCookies(c *gin.Context) {
+	cfg := h.config.Auth.SessionCookie
+
+	c.SetSameSite(sessionCookieSameSite(cfg.SameSite))
+	c.SetCookie(RefreshTokenCookie, "", -1, refreshCookiePath, cfg.Domain, cfg.Secure, true)
+	c.SetCookie(CSRFCookie, "", -1, "/", cfg.Domain, cfg.Secure, false)
+}
+
+// sessionCookieSameSite maps the configured SameSite value, defaulting to strict
+func sessionCookieSameSite(value string) http.SameSite {
+	if strings.EqualFold(value, "lax") {
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteStrictMode
+}
+
+// abortCSRF rejects a cookie-authenticated request without a valid CSRF token
+func abortCSRF(c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "Invalid CSRF token"})
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func newSessionCookieHandler() *AuthHandler {
+	cfg := &config.Config{}
+	cfg.Auth.SessionCookie.Enabled = true
+	cfg.Auth.SessionCookie.Secure = true
+	return &AuthHandler{config: cfg}
+}
+
+func TestPresentedRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newSessionCookieHandler()
+
+	tests := []struct {
+		name       string
+		method     string
+		bodyToken  string
+		csrfCookie string
+		csrfHeader string
+		wantToken  string
+		wantErr    bool
+	}{
+		{"body token wins", http.MethodPost, "body-token", "", "", "body-token", false},
+		{"cookie with matching csrf", http.MethodPost, "", "csrf", "csrf", "cookie-token", false},
+		{"cookie without csrf header", http.MethodPost, "", "csrf", "", "", true},
+		{"cookie with other csrf", http.MethodPost, "", "csrf", "other", "", true},
+		{"cookie without csrf cookie", http.MethodPost, "", "", "csrf", "", true},
+		{"cookie ignored on get", http.MethodGet, "", "csrf", "csrf", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest(tt.method, "/api/v1/auth/refresh", nil)
+			c.Request.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: "cookie-token"})
+			if tt.csrfCookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: CSRFCookie, Value: tt.csrfCookie})
+			}
+			if tt.csrfHeader != "" {
+				c.Request.Header.Set(CSRFHeader, tt.csrfHeader)
+			}
+
+			token, _, err := h.presentedRefreshToken(c, tt.bodyToken)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if token != tt.wantToken {
+				t.Errorf("Expected token %q, got %q", tt.wantToken, token)
+			}
+		})
+	}
+}
+
+func TestPresentedRefreshToken_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &AuthHandler{config: &config.Config{}}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/auth/refresh", nil)
+	c.Request.AddCookie(&http.Cookie{Name: RefreshTokenCookie, Value: "cookie-token"})
+	c.Request.AddCookie(&http.Cookie{Name: CSRFCookie, Value: "csrf"})
+	c.Request.Header.Set(CSRFHeader, "csrf")
+
+	if token, _, err := h.presentedRefreshToken(c, ""); token != "" || err != nil {
+		t.Errorf("Expected the cookie to be ignored, got %q, %v", token, err)
+	}
+}
+
+func TestRespondWithSession_Cookie(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := newSessionCookieHandler()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+
+	h.respondWithSession(c, LoginResponse{AccessToken: "access", RefreshToken: "refresh"}, true)
+
+	if strings.Contains(w.Body.String(), `"refresh_token"`) {
+		t.Errorf("Expected no refresh token in the body, got %s", w.Body.String())
+	}
+
+	cookies := make(map[string]*http.Cookie)
+	for _, cookie := range w.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+
+	refresh := cookies[RefreshTokenCookie]
+	if refresh == nil || refresh.Value != "refresh" {
+		t.Fatalf("Expected the refresh token cookie, got %+v", refresh)
+	}
+	if !refresh.HttpOnly || !refresh.Secure || refresh.SameSite != http.SameSiteStrictMode || refresh.Path != refreshCookiePath {
+		t.Errorf("Unexpected refresh token cookie flags %+v", refresh)
+	}
+
+	csrf := cookies[CSRFCookie]
+	if csrf == nil || csrf.Value == "" || csrf.HttpOnly {
+		t.Fatalf("Expected a readable CSRF cookie, got %+v", csrf)
+	}
+	if !strings.Contains(w.Body.String(), `"csrf_token":"`+csrf.Value+`"`) {
+		t.Errorf("Expected the CSRF token in the body, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// Session represents an active session, as reported when a login evicts it
+type Session struct {
+	ID         string     `json:"id"`
+	DeviceID   string     `json:"device_id,omitempty"`
+	StoreID    string     `json:"store_id,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// sessionLimitError is returned when a login would exceed the concurrent
+// session limit under the reject policy
+type sessionLimitError struct {
+	Limit int
+}
+
+func (e *sessionLimitError) Error() string {
+	return fmt.Sprintf("at most %d active sessions allowed", e.Limit)
+}
+
+// SessionLimitRequest represents the request body for setting a user's session
+// limit. A null max_sessions falls back to the role or global limit.
+type SessionLimitRequest struct {
+	MaxSessions *int `json:"max_sessions" binding:"omitempty,min=0"`
+}
+
+// sessionLimit returns the maximum number of active sessions of a user with the
+// role, or 0 for unlimited. The user's own limit takes precedence when set.
+func (h *AuthHandler) sessionLimit(role string, userLimit sql.NullInt64) int {
+	if userLimit.Valid {
+		return int(userLimit.Int64)
+	}
+	if limit, ok := h.config.Auth.Sessions.Roles[role]; ok {
+		return limit
+	}
+	return h.config.Auth.Sessions.MaxPerUser
+}
+
+// sessionsToEvict returns the sessions that have to end for one more to fit
+// within the limit. active is ordered oldest first.
+func sessionsToEvict(active []Session, limit int) []Session {
+	if limit <= 0 || len(active) < limit {
+		return nil
+	}
+	return active[:len(active)-limit+1]
+}
+
+// openSession stores a refresh token for a new login of the user, first making
+// room under the concurrent session limit. Under the evict_oldest policy the
+// oldest sessions are ended and returned; under reject a *sessionLimitError is
+// returned instead.
+func (h *AuthHandler) openSession(userID, role string, session sessionContext) (string, []Session, error) {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so concurrent logins cannot both take the last free slot
+	var userLimit sql.NullInt64
+	err = tx.QueryRow(`SELECT max_sessions FROM "user" WHERE id = $1 FOR UPDATE`, userID).Scan(&userLimit)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var evicted []Session
+	if limit := h.sessionLimit(role, userLimit); limit > 0 {
+		active, err := activeSessions(tx, userID)
+		if err != nil {
+			return "", nil, err
+		}
+
+		evicted = sessionsToEvict(active, limit)
+		if len(evicted) > 0 && h.config.Auth.Sessions.Policy == config.SessionPolicyReject {
+			return "", nil, &sessionLimitError{Limit: limit}
+		}
+		for _, s := range evicted {
+			if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE id = $1`, s.ID); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	refreshToken, err := insertRefreshToken(tx, userID, session)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", nil, err
+	}
+	return refreshToken, evicted, nil
+}
+
+// activeSessions returns the user's unexpired sessions, oldest first
+func activeSessions(tx *sql.Tx, userID string) ([]Session, error) {
+	rows, err := tx.Query(`
+		SELECT id, device_id, store_id, created_at, last_used_at
+		FROM refresh_tokens
+		WHERE user_id = $1 AND expires_at > $2
+		ORDER BY created_at, id`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		var deviceID, storeID sql.NullString
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&s.ID, &deviceID, &storeID, &s.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		s.DeviceID = deviceID.String
+		s.StoreID = storeID.String
+		if lastUsedAt.Valid {
+			s.LastUsedAt = &lastUsedAt.Time
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// recordEvictions records an audit event for each session a login ended
+func (h *AuthHandler) recordEvictions(c *gin.Context, userID, username string, evicted []Session) {
+	for _, s := range evicted {
+		h.recordEvent(c, audit.EventSessionEvicted, audit.OutcomeSuccess, userID, username, "session limit reached, ended session "+s.ID)
+	}
+}
+
+// abortSessionLimit rejects a login beyond the concurrent session limit
+func abortSessionLimit(c *gin.Context, err *sessionLimitError) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":        "Maximum number of active sessions reached",
+		"max_sessions": err.Limit,
+	})
+}
+
+// SetSessionLimit sets or clears the user's own concurrent session limit
+func (h *AuthHandler) SetSessionLimit(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var req SessionLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var maxSessions sql.NullInt64
+	if req.MaxSessions != nil {
+		maxSessions = sql.NullInt64{Int64: int64(*req.MaxSessions), Valid: true}
+	}
+
+	result, err := h.db.Exec(`UPDATE "user" SET max_sessions = $1 WHERE id = $2`, maxSessions, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update session limit"})
+		return
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":           userID,
+		"max_sessions": req.MaxSessions,
+	})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestSessionLimit(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.Sessions.MaxPerUser = 3
+	cfg.Auth.Sessions.Roles = map[string]int{"operator": 1, "admin": 0}
+	h := &AuthHandler{config: cfg}
+
+	tests := []struct {
+		name      string
+		role      string
+		userLimit sql.NullInt64
+		want      int
+	}{
+		{"global limit", "user", sql.NullInt64{}, 3},
+		{"role limit", "operator", sql.NullInt64{}, 1},
+		{"role without limit", "admin", sql.NullInt64{}, 0},
+		{"user limit wins", "operator", sql.NullInt64{Int64: 2, Valid: true}, 2},
+		{"user without limit", "operator", sql.NullInt64{Int64: 0, Valid: true}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := h.sessionLimit(tt.role, tt.userLimit); got != tt.want {
+				t.Errorf("Expected limit %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSessionsToEvict(t *testing.T) {
+	active := []Session{{ID: "oldest"}, {ID: "middle"}, {ID: "newest"}}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  []string
+	}{
+		{"unlimited", 0, nil},
+		{"room left", 4, nil},
+		{"at the limit", 3, []string{"oldest"}},
+		{"one session", 1, []string{"oldest", "middle", "newest"}},
+		{"limit lowered", 2, []string{"oldest", "middle"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sessionsToEvict(active, tt.limit)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d sessions, got %d", len(tt.want), len(got))
+			}
+			for i, s := range got {
+				if s.ID != tt.want[i] {
+					t.Errorf("Expected session %s at %d, got %s", tt.want[i], i, s.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
+)
+
+// errStoreNotAllowed is returned when a user asks for a store they are not a member of
+var errStoreNotAllowed = errors.New("user is not a member of the store")
+
+// StoreMembership represents a store the user can work in
+type StoreMembership struct {
+	StoreID   string `json:"store_id"`
+	StoreName string `json:"store_name"`
+	TenantID  string `json:"tenant_id"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// SwitchStoreRequest represents the request body for switching the active store
+type SwitchStoreRequest struct {
+	StoreID      string `json:"store_id" binding:"required"`
+	RefreshToken string `json:"refresh_token"` // taken from the cookie in cookie session mode
+}
+
+// ListStores returns the stores the authenticated user is a member of
+func (h *AuthHandler) ListStores(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	rows, err := h.db.Query(`
+		SELECT s.id, s.name, s.tenant_id, us.is_default
+		FROM user_store us
+		JOIN store s ON s.id = us.store_id
+		WHERE us.user_id = $1 AND s.is_active
+		ORDER BY us.is_default DESC, s.name`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list stores"})
+		return
+	}
+	defer rows.Close()
+
+	stores := []StoreMembership{}
+	for rows.Next() {
+		var m StoreMembership
+		if err := rows.Scan(&m.StoreID, &m.StoreName, &m.TenantID, &m.IsDefault); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list stores"})
+			return
+		}
+		stores = append(stores, m)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active_store_id": c.GetString("store_id"),
+		"stores":          stores,
+	})
+}
+
+// SwitchStore moves the authenticated session to another store the user is a member of.
+// The refresh token is rotated so the new store sticks across refreshes.
+func (h *AuthHandler) SwitchStore(c *gin.Context) {
+	var req SwitchStoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	userID := c.GetString("user_id")
+
+	refreshToken, fromCookie, err := h.presentedRefreshToken(c, req.RefreshToken)
+	if err == errCSRFMismatch {
+		h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, c.GetString("username"), "invalid CSRF token")
+		abortCSRF(c)
+		return
+	}
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	var deviceID, boundJKT, sessionScope sql.NullString
+	err = h.db.QueryRow(`
+		SELECT device_id, dpop_jkt, scope
+		FROM refresh_tokens
+		WHERE token = $1 AND user_id = $2 AND expires_at > $3`,
+		refreshToken, userID, time.Now()).Scan(&deviceID, &boundJKT, &sessionScope)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	jkt, err := h.dpopProofKey(c, boundJKT.String)
+	if err != nil {
+		h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, c.GetString("username"), "invalid DPoP proof")
+		abortInvalidDPoP(c)
+		return
+	}
+
+	// A session opened on a kiosk stays in the store the kiosk is installed in
+	if deviceID.String != "" {
+		_, deviceStoreID, err := h.lookupDevice(deviceID.String)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device"})
+			return
+		}
+		if req.StoreID != deviceStoreID {
+			h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, c.GetString("username"), "store not allowed on device")
+			c.JSON(http.StatusForbidden, gin.H{"error": "Store is not allowed on this device"})
+			return
+		}
+	}
+
+	storeID, err := h.resolveStore(userID, req.StoreID)
+	if err == errStoreNotAllowed {
+		h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, c.GetString("username"), "store not allowed")
+		c.JSON(http.StatusForbidden, gin.H{"error": "User is not a member of the requested store"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve store"})
+		return
+	}
+
+	var username, email, firstName, lastName, role string
+	var tenantID sql.NullString
+	userQuery := `SELECT username, email, first_name, last_name, role, tenant_id FROM "user" WHERE id = $1`
+	err = h.db.QueryRow(userQuery, userID).Scan(&username, &email, &firstName, &lastName, &role, &tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User not found"})
+		return
+	}
+
+	deviceScopes, err := h.deviceScopes(deviceID.String)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve scope"})
+		return
+	}
+	scope, _ := grantScope("", h.roleScopes(role), scopeLimit(sessionScope.String), deviceScopes)
+
+	// The new store may keep other hours than the current one
+	accessUntil, err := h.accessUntil(role, storeID, time.Now())
+	if err == errOutsideAccessHours {
+		h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, username, "outside access hours")
+		abortOutsideAccessHours(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve access hours"})
+		return
+	}
+
+	claims := newUserClaims(userID, username, email, firstName, lastName, role)
+	claims.DeviceID = deviceID.String
+	claims.TenantID = tenantID.String
+	claims.StoreID = storeID
+	claims.Scope = scope
+	claims.AccessUntil = accessUntil
+	tokenType := claims.bindToKey(jkt)
+
+	accessToken, err := h.generateAccessToken(claims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+		return
+	}
+
+	// Consume the old refresh token before issuing the new one, in one
+	// transaction, so two concurrent switches cannot both rotate it
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new refresh token"})
+		return
+	}
+	defer tx.Rollback()
+
+	var consumed string
+	err = tx.QueryRow(`DELETE FROM refresh_tokens WHERE token = $1 AND user_id = $2 RETURNING token`, refreshToken, userID).Scan(&consumed)
+	if err == sql.ErrNoRows {
+		h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeFailure, userID, username, "refresh token already used")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh token"})
+		return
+	}
+
+	newRefreshToken, err := insertRefreshToken(tx, userID, sessionContext{DeviceID: deviceID.String, StoreID: storeID, Scope: scope, DPoPJKT: jkt})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new refresh token"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save new refresh token"})
+		return
+	}
+
+	h.recordEvent(c, audit.EventSwitchStore, audit.OutcomeSuccess, userID, username, "")
+
+	h.respondWithSession(c, LoginResponse{
+		AccessToken:  accessToken,
+		TokenType:    tokenType,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		Scope:        scope,
+	}, fromCookie || h.wantsCookieSession(c))
+}
+
+// resolveStore picks the store for a new session. A requested store must be one
+// of the user's stores. Without a request the default store is used, or the only
+// store when the user has just one; otherwise the session has no store until the
+// user switches to one.
+func (h *AuthHandler) resolveStore(userID, requestedStoreID string) (string, error) {
+	if requestedStoreID != "" {
+		member, err := h.isStoreMember(userID, requestedStoreID)
+		if err != nil {
+			return "", err
+		}
+		if !member {
+			return "", errStoreNotAllowed
+		}
+		return requestedStoreID, nil
+	}
+
+	rows, err := h.db.Query(`
+		SELECT us.store_id, us.is_default
+		FROM user_store us
+		JOIN store s ON s.id = us.store_id
+		WHERE us.user_id = $1 AND s.is_active
+		ORDER BY us.is_default DESC
+		LIMIT 2`, userID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var storeIDs []string
+	for rows.Next() {
+		var storeID string
+		var isDefault bool
+		if err := rows.Scan(&storeID, &isDefault); err != nil {
+			return "", err
+		}
+		if isDefault {
+			return storeID, nil
+		}
+		storeIDs = append(storeIDs, storeID)
+	}
+
+	if len(storeIDs) == 1 {
+		return storeIDs[0], nil
+	}
+	return "", rows.Err()
+}
+
+// isStoreMember reports whether the user is a member of the store and the store is active
+func (h *AuthHandler) isStoreMember(userID, storeID string) (bool, error) {
+	var exists bool
+	err := h.db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM user_store us
+			JOIN store s ON s.id = us.store_id
+			WHERE us.user_id = $1 AND us.store_id = $2 AND s.is_active
+		)`, userID, storeID).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestResolveStore(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		member    bool
+		stores    [][]driver.Value // store_id and is_default of the user's stores
+		want      string
+		wantErr   error
+	}{
+		{"requested member store", "store-2", true, nil, "store-2", nil},
+		{"requested other store", "store-9", false, nil, "", errStoreNotAllowed},
+		{"default store", "", false, [][]driver.Value{{"store-2", true}, {"store-1", false}}, "store-2", nil},
+		{"only store", "", false, [][]driver.Value{{"store-1", false}}, "store-1", nil},
+		{"several stores without a default", "", false, [][]driver.Value{{"store-1", false}, {"store-2", false}}, "", nil},
+		{"no store", "", false, nil, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, _ := newFakeDB(t,
+				fakeQuery{match: "SELECT EXISTS", answer: rows([]driver.Value{tt.member})},
+				fakeQuery{match: "SELECT us.store_id, us.is_default", answer: rows(tt.stores...)},
+			)
+			h := &AuthHandler{db: db, config: &config.Config{}}
+
+			got, err := h.resolveStore("user-1", tt.requested)
+			if err != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected store %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSwitchStore_NotMember(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t,
+		fakeQuery{match: "FROM refresh_tokens", answer: rows([]driver.Value{nil, nil, nil})},
+		fakeQuery{match: "SELECT EXISTS", answer: func(args []driver.Value) ([][]driver.Value, error) {
+			if args[0] != "user-1" || args[1] != "store-9" {
+				t.Errorf("Expected the membership of user-1 in store-9 checked, got %v", args)
+			}
+			return [][]driver.Value{{false}}, nil
+		}},
+	)
+	h := &AuthHandler{db: db, config: &config.Config{}}
+
+	router := gin.New()
+	router.POST("/api/v1/auth/switch-store", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+		c.Next()
+	}, h.SwitchStore)
+
+	body, _ := json.Marshal(SwitchStoreRequest{StoreID: "store-9", RefreshToken: "refresh-token"})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/switch-store", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status 403, got %d", w.Code)
+	}
+	if fake.ran(`INSERT INTO "refresh_tokens"`) || fake.ran("DELETE FROM refresh_tokens") {
+		t.Error("Expected the session left in its store")
+	}
+}
+
+func TestSwitchStore_ExpiredRefreshToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, fakeQuery{match: "FROM refresh_tokens", answer: func(args []driver.Value) ([][]driver.Value, error) {
+		if expiresAfter, ok := args[2].(time.Time); !ok || time.Since(expiresAfter) > time.Minute {
+			t.Errorf("Expected only unexpired tokens looked up, got %v", args[2])
+		}
+		return nil, nil
+	}})
+	h := &AuthHandler{db: db, config: &config.Config{}}
+
+	router := gin.New()
+	router.POST("/api/v1/auth/switch-store", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+		c.Next()
+	}, h.SwitchStore)
+
+	body, _ := json.Marshal(SwitchStoreRequest{StoreID: "store-1", RefreshToken: "expired-token"})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/switch-store", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+	if fake.ran("SELECT EXISTS") {
+		t.Error("Expected no store checked without a session")
+	}
+}
+
+// switchStore posts a store switch for user-1 with the refresh token
+func switchStore(h *AuthHandler, storeID string) int {
+	router := gin.New()
+	router.POST("/api/v1/auth/switch-store", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+		c.Next()
+	}, h.SwitchStore)
+
+	body, _ := json.Marshal(SwitchStoreRequest{StoreID: storeID, RefreshToken: "refresh-token"})
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/auth/switch-store", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestSwitchStore_DeviceSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t,
+		fakeQuery{match: "SELECT device_id, dpop_jkt, scope", answer: rows([]driver.Value{"kiosk-1", nil, nil})},
+		fakeQuery{match: `SELECT is_active, store_id FROM "kiosk_device"`, answer: rows([]driver.Value{true, "store-1"})},
+		// The user is a member of the other store too
+		fakeQuery{match: "SELECT EXISTS", answer: rows([]driver.Value{true})},
+	)
+	h := &AuthHandler{db: db, config: &config.Config{}}
+
+	if code := switchStore(h, "store-2"); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for a store other than the kiosk's, got %d", code)
+	}
+	if fake.ran("SELECT EXISTS") || fake.ran(`INSERT INTO "refresh_tokens"`) {
+		t.Error("Expected the session left in the kiosk's store")
+	}
+}
+
+// switchStoreQueries answers a store switch up to the refresh token rotation,
+// where the old token is consumed by deleteAnswer
+func switchStoreQueries(deleteAnswer func([]driver.Value) ([][]driver.Value, error)) []fakeQuery {
+	return []fakeQuery{
+		{match: "DELETE FROM refresh_tokens", answer: deleteAnswer},
+		{match: "SELECT device_id, dpop_jkt, scope", answer: rows([]driver.Value{nil, nil, nil})},
+		{match: "SELECT EXISTS", answer: rows([]driver.Value{true})},
+		{match: `FROM "user"`, answer: rows([]driver.Value{"cashier1", "cashier1@example.com", "Cashier", "One", "cashier", nil})},
+		{match: "SELECT timezone, access_hours", answer: rows()},
+		{match: `INSERT INTO "refresh_tokens"`, answer: rows([]driver.Value{})},
+	}
+}
+
+func TestSwitchStore_ConsumesOldTokenFirst(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, switchStoreQueries(rows([]driver.Value{"refresh-token"}))...)
+	h := &AuthHandler{db: db, config: &config.Config{Keys: setupSigningKeys(t)}}
+
+	if code := switchStore(h, "store-2"); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+
+	deleted, inserted := -1, -1
+	for i, query := range fake.seen {
+		if strings.Contains(query, "DELETE FROM refresh_tokens") {
+			deleted = i
+		}
+		if strings.Contains(query, `INSERT INTO "refresh_tokens"`) {
+			inserted = i
+		}
+	}
+	if deleted == -1 || inserted < deleted {
+		t.Errorf("Expected the old token deleted before the new one is saved, got delete %d and insert %d", deleted, inserted)
+	}
+}
+
+func TestSwitchStore_AlreadyUsedToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Another switch consumed the token after it was looked up
+	db, fake := newFakeDB(t, switchStoreQueries(rows())...)
+	h := &AuthHandler{db: db, config: &config.Config{Keys: setupSigningKeys(t)}}
+
+	if code := switchStore(h, "store-2"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", code)
+	}
+	if fake.ran(`INSERT INTO "refresh_tokens"`) {
+		t.Error("Expected no new refresh token for a used one")
+	}
+}
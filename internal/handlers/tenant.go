@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantHandler handles tenant (merchant) and store administration requests
+type TenantHandler struct {
+	db *sql.DB
+}
+
+// NewTenantHandler creates a new tenant handler
+func NewTenantHandler(db *sql.DB) *TenantHandler {
+	return &TenantHandler{
+		db: db,
+	}
+}
+
+// CreateTenantRequest represents the request body for tenant creation
+type CreateTenantRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// CreateStoreRequest represents the request body for store creation
+type CreateStoreRequest struct {
+	TenantID string `json:"tenant_id" binding:"required"`
+	Name     string `json:"name" binding:"required"`
+}
+
+// AddStoreMemberRequest represents the request body for adding a user to a store
+type AddStoreMemberRequest struct {
+	UserID    string `json:"user_id" binding:"required"`
+	IsDefault bool   `json:"is_default"`
+}
+
+// CreateTenant creates a new tenant
+func (h *TenantHandler) CreateTenant(c *gin.Context) {
+	var req CreateTenantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tenant name cannot be empty"})
+		return
+	}
+
+	var tenantID string
+	err := h.db.QueryRow(`INSERT INTO "tenant" (name) VALUES ($1) RETURNING id`, req.Name).Scan(&tenantID)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during inserting tenant : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tenant"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      tenantID,
+		"message": "Tenant created successfully",
+	})
+}
+
+// CreateStore creates a new store under a tenant
+func (h *TenantHandler) CreateStore(c *gin.Context) {
+	var req CreateStoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Store name cannot be empty"})
+		return
+	}
+
+	var storeID string
+	err := h.db.QueryRow(`INSERT INTO "store" (tenant_id, name) VALUES ($1, $2) RETURNING id`, req.TenantID, req.Name).Scan(&storeID)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during inserting store : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create store"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":      storeID,
+		"message": "Store created successfully",
+	})
+}
+
+// AddStoreMember adds a user to a store. A user without a tenant joins the
+// store's tenant; a user of another tenant is rejected.
+func (h *TenantHandler) AddStoreMember(c *gin.Context) {
+	storeID := c.Param("id")
+
+	var req AddStoreMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Invalid request body",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	var storeTenantID string
+	if err := h.db.QueryRow(`SELECT tenant_id FROM "store" WHERE id = $1`, storeID).Scan(&storeTenantID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+		return
+	}
+
+	var userTenantID sql.NullString
+	if err := h.db.QueryRow(`SELECT tenant_id FROM "user" WHERE id = $1`, req.UserID).Scan(&userTenantID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if userTenantID.Valid && userTenantID.String != storeTenantID {
+		c.JSON(http.StatusConflict, gin.H{"error": "User belongs to another tenant"})
+		return
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add store member"})
+		return
+	}
+	defer tx.Rollback()
+
+	if !userTenantID.Valid {
+		if _, err := tx.Exec(`UPDATE "user" SET tenant_id = $1 WHERE id = $2`, storeTenantID, req.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add store member"})
+			return
+		}
+	}
+
+	if req.IsDefault {
+		if _, err := tx.Exec(`UPDATE "user_store" SET is_default = false WHERE user_id = $1`, req.UserID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add store member"})
+			return
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO "user_store" (user_id, store_id, is_default)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, store_id) DO UPDATE SET is_default = EXCLUDED.is_default`,
+		req.UserID, storeID, req.IsDefault)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add store member"})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add store member"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User added to store successfully"})
+}
+
+// RemoveStoreMember removes a user from a store
+func (h *TenantHandler) RemoveStoreMember(c *gin.Context) {
+	result, err := h.db.Exec(`DELETE FROM "user_store" WHERE store_id = $1 AND user_id = $2`, c.Param("id"), c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove store member"})
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Store membership not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User removed from store successfully"})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupTenantRouter(t *testing.T, queries ...fakeQuery) (*gin.Engine, *fakeDB) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, fake := newFakeDB(t, queries...)
+	h := NewTenantHandler(db)
+
+	router := gin.New()
+	router.POST("/api/v1/admin/stores/:id/members", h.AddStoreMember)
+	router.DELETE("/api/v1/admin/stores/:id/members/:user_id", h.RemoveStoreMember)
+	return router, fake
+}
+
+func addStoreMember(router *gin.Engine, storeID string, req AddStoreMemberRequest) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(req)
+	httpReq, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/stores/"+storeID+"/members", bytes.NewReader(body))
+	httpReq.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httpReq)
+	return w
+}
+
+func TestAddStoreMember(t *testing.T) {
+	tests := []struct {
+		name         string
+		userTenantID driver.Value
+		isDefault    bool
+		wantStatus   int
+		wantTenant   bool // the user joins the store's tenant
+		wantDefault  bool // the user's other stores stop being the default
+	}{
+		{"user without a tenant", nil, false, http.StatusOK, true, false},
+		{"user of the store's tenant", "tenant-1", false, http.StatusOK, false, false},
+		{"default store", "tenant-1", true, http.StatusOK, false, true},
+		{"user of another tenant", "tenant-2", false, http.StatusConflict, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, fake := setupTenantRouter(t,
+				fakeQuery{match: `SELECT tenant_id FROM "store"`, answer: rows([]driver.Value{"tenant-1"})},
+				fakeQuery{match: `SELECT tenant_id FROM "user"`, answer: rows([]driver.Value{tt.userTenantID})},
+				fakeQuery{match: `UPDATE "user" SET tenant_id`, answer: rows([]driver.Value{})},
+				fakeQuery{match: `UPDATE "user_store" SET is_default = false`, answer: rows([]driver.Value{})},
+				fakeQuery{match: `INSERT INTO "user_store"`, answer: rows([]driver.Value{})},
+			)
+
+			w := addStoreMember(router, "store-1", AddStoreMemberRequest{UserID: "user-1", IsDefault: tt.isDefault})
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if got := fake.ran(`UPDATE "user" SET tenant_id`); got != tt.wantTenant {
+				t.Errorf("Expected tenant set %v, got %v", tt.wantTenant, got)
+			}
+			if got := fake.ran(`SET is_default = false`); got != tt.wantDefault {
+				t.Errorf("Expected other defaults cleared %v, got %v", tt.wantDefault, got)
+			}
+			if got := fake.ran(`INSERT INTO "user_store"`); got != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Expected membership added %v, got %v", tt.wantStatus == http.StatusOK, got)
+			}
+		})
+	}
+}
+
+func TestAddStoreMember_UnknownStore(t *testing.T) {
+	router, fake := setupTenantRouter(t, fakeQuery{match: `SELECT tenant_id FROM "store"`, answer: rows()})
+
+	w := addStoreMember(router, "store-9", AddStoreMemberRequest{UserID: "user-1"})
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if fake.ran(`INSERT INTO "user_store"`) {
+		t.Error("Expected no membership added")
+	}
+}
+
+func TestRemoveStoreMember(t *testing.T) {
+	tests := []struct {
+		name       string
+		deleted    [][]driver.Value
+		wantStatus int
+	}{
+		{"member", [][]driver.Value{{}}, http.StatusOK},
+		{"not a member", nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := setupTenantRouter(t, fakeQuery{match: `DELETE FROM "user_store"`, answer: func(args []driver.Value) ([][]driver.Value, error) {
+				if args[0] != "store-1" || args[1] != "user-1" {
+					t.Errorf("Expected user-1 removed from store-1, got %v", args)
+				}
+				return tt.deleted, nil
+			}})
+
+			req, _ := http.NewRequest(http.MethodDelete, "/api/v1/admin/stores/store-1/members/user-1", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+		})
+	}
+}
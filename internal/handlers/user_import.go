@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User import limits
+const (
+	maxImportRows  = 500
+	maxImportBytes = 1 << 20
+)
+
+// importColumns are the CSV columns accepted by the import, and whether each is required
+var importColumns = map[string]bool{
+	"username":   true,
+	"email":      true,
+	"first_name": true,
+	"last_name":  true,
+	"role":       false,
+	"password":   false,
+}
+
+// importFieldColumns maps RegisterRequest fields to their CSV columns
+var importFieldColumns = map[string]string{
+	"Username":  "username",
+	"Email":     "email",
+	"FirstName": "first_name",
+	"LastName":  "last_name",
+	"Password":  "password",
+}
+
+// UserHandler handles bulk user administration requests
+type UserHandler struct {
+	db     *sql.DB
+	mailer mailer.Mailer
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(db *sql.DB, mail mailer.Mailer) *UserHandler {
+	return &UserHandler{
+		db:     db,
+		mailer: mail,
+	}
+}
+
+// ImportRowError describes a problem with one CSV row. Rows are numbered as in
+// a spreadsheet, so the header is row 1.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportedUser represents a user created by an import
+type ImportedUser struct {
+	Row                   int    `json:"row"`
+	ID                    string `json:"id"`
+	Username              string `json:"username"`
+	TemporaryPasswordSent bool   `json:"temporary_password_sent"`
+}
+
+// importRow is a validated CSV row
+type importRow struct {
+	Row               int
+	User              RegisterRequest
+	Role              string
+	TemporaryPassword bool
+}
+
+// ImportUsers creates users from a CSV upload, in the "file" form field or as
+// the request body. Every row is validated like a registration; if any row
+// fails nothing is created. Pass dry_run=true to only validate, and store_id
+// to add every user to a store. Users without a password get a temporary one
+// by email.
+func (h *UserHandler) ImportUsers(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+
+	var storeID, tenantID string
+	if storeID = c.Query("store_id"); storeID != "" {
+		if err := h.db.QueryRow(`SELECT tenant_id FROM "store" WHERE id = $1 AND is_active`, storeID).Scan(&tenantID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Store not found"})
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	var body io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing CSV file in the \"file\" field"})
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read CSV file"})
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	rows, rowErrors, err := parseUserImport(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existingErrors, err := h.checkExistingUsers(rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing users"})
+		return
+	}
+	rowErrors = append(rowErrors, existingErrors...)
+
+	if len(rowErrors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"dry_run": dryRun,
+			"total":   len(rows),
+			"errors":  rowErrors,
+		})
+		return
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run": true,
+			"total":   len(rows),
+			"errors":  []ImportRowError{},
+		})
+		return
+	}
+
+	created, err := h.createUsers(rows, storeID, tenantID, c.GetString("username"))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == postgresUniqueViolation {
+			c.JSON(http.StatusConflict, gin.H{"error": "A user in the file was created by someone else during the import, nothing was imported"})
+			return
+		}
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during importing users : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import users"})
+		return
+	}
+
+	for i, row := range rows {
+		if row.TemporaryPassword {
+			sendMail(h.mailer, mailer.Message{
+				To:      row.User.Email,
+				Subject: "Your new account",
+				Body: fmt.Sprintf("Hello %s,\n\nAn account has been created for you.\n\nUsername: %s\nTemporary password: %s\n\nPlease sign in and set up your PIN before your first shift.\n",
+					row.User.FirstName, row.User.Username, row.User.Password),
+			})
+			created[i].TemporaryPasswordSent = h.mailer != nil
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"dry_run": false,
+		"total":   len(rows),
+		"created": created,
+	})
+}
+
+// createUsers inserts the rows in a single transaction
+func (h *UserHandler) createUsers(rows []importRow, storeID, tenantID, createdBy string) ([]ImportedUser, error) {
+	// Hash before opening the transaction, bcrypt is slow on purpose
+	hashes := make([]string, len(rows))
+	for i, row := range rows {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(row.User.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = string(hashedPassword)
+	}
+
+	tx, err := h.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	created := make([]ImportedUser, 0, len(rows))
+	for i, row := range rows {
+		var userID string
+		err := tx.QueryRow(`
+			INSERT INTO "user" (username, email, first_name, last_name, password_hash, role, tenant_id, created_by, updated_by)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+			RETURNING id`,
+			row.User.Username, row.User.Email, row.User.FirstName, row.User.LastName, hashes[i], row.Role,
+			nullString(tenantID), createdBy).Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if storeID != "" {
+			_, err := tx.Exec(`INSERT INTO "user_store" (user_id, store_id, is_default) VALUES ($1, $2, true)`, userID, storeID)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		created = append(created, ImportedUser{Row: row.Row, ID: userID, Username: row.User.Username})
+	}
+
+	return created, tx.Commit()
+}
+
+// checkExistingUsers reports rows whose username or email is already taken
+func (h *UserHandler) checkExistingUsers(rows []importRow) ([]ImportRowError, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	usernames := make([]string, len(rows))
+	emails := make([]string, len(rows))
+	for i, row := range rows {
+		usernames[i] = row.User.Username
+		emails[i] = strings.ToLower(row.User.Email)
+	}
+
+	result, err := h.db.Query(`
+		SELECT username, lower(email)
+		FROM "user"
+		WHERE username = ANY($1) OR lower(email) = ANY($2)`, pq.Array(usernames), pq.Array(emails))
+	if err != nil {
+		return nil, err
+	}
+	defer result.Close()
+
+	takenUsernames := make(map[string]bool)
+	takenEmails := make(map[string]bool)
+	for result.Next() {
+		var username, email string
+		if err := result.Scan(&username, &email); err != nil {
+			return nil, err
+		}
+		takenUsernames[username] = true
+		takenEmails[email] = true
+	}
+	if err := result.Err(); err != nil {
+		return nil, err
+	}
+
+	var rowErrors []ImportRowError
+	for _, row := range rows {
+		if takenUsernames[row.User.Username] {
+			rowErrors = append(rowErrors, ImportRowError{Row: row.Row, Field: "username", Error: "already exists"})
+		}
+		if takenEmails[strings.ToLower(row.User.Email)] {
+			rowErrors = append(rowErrors, ImportRowError{Row: row.Row, Field: "email", Error: "already exists"})
+		}
+	}
+	return rowErrors, nil
+}
+
+// parseUserImport reads and validates the CSV. Header problems are returned as
+// an error; problems with individual rows are collected as row errors.
+func parseUserImport(r io.Reader) ([]importRow, []ImportRowError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, known := importColumns[name]; !known {
+			return nil, nil, fmt.Errorf("unknown column %q", name)
+		}
+		if _, duplicate := columns[name]; duplicate {
+			return nil, nil, fmt.Errorf("duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+	for name, required := range importColumns {
+		if _, ok := columns[name]; required && !ok {
+			return nil, nil, fmt.Errorf("missing required column %q", name)
+		}
+	}
+
+	var rows []importRow
+	var rowErrors []ImportRowError
+	count := 0
+	seenUsernames := make(map[string]int)
+	seenEmails := make(map[string]int)
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if count++; count > maxImportRows {
+			return nil, nil, fmt.Errorf("too many rows, at most %d users can be imported at once", maxImportRows)
+		}
+		if len(record) != len(header) {
+			rowErrors = append(rowErrors, ImportRowError{Row: line, Error: fmt.Sprintf("expected %d fields, got %d", len(header), len(record))})
+			continue
+		}
+
+		value := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := importRow{
+			Row: line,
+			User: RegisterRequest{
+				Username:  value("username"),
+				Email:     value("email"),
+				FirstName: value("first_name"),
+				LastName:  value("last_name"),
+				Password:  value("password"),
+			},
+			Role: strings.ToLower(value("role")),
+		}
+
+		if row.User.Password == "" {
+			password, err := generateTemporaryPassword()
+			if err != nil {
+				return nil, nil, err
+			}
+			row.User.Password = password
+			row.TemporaryPassword = true
+		}
+		if row.Role == "" {
+			row.Role = RoleCashier
+		}
+
+		errs := validateImportRow(row)
+		if previous, ok := seenUsernames[row.User.Username]; ok && row.User.Username != "" {
+			errs = append(errs, ImportRowError{Row: line, Field: "username", Error: fmt.Sprintf("duplicate of row %d", previous)})
+		}
+		if previous, ok := seenEmails[strings.ToLower(row.User.Email)]; ok && row.User.Email != "" {
+			errs = append(errs, ImportRowError{Row: line, Field: "email", Error: fmt.Sprintf("duplicate of row %d", previous)})
+		}
+		seenUsernames[row.User.Username] = line
+		seenEmails[strings.ToLower(row.User.Email)] = line
+
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	if count == 0 {
+		return nil, nil, errors.New("CSV file has no users")
+	}
+	return rows, rowErrors, nil
+}
+
+// validateImportRow applies the RegisterRequest validation rules and checks the role
+func validateImportRow(row importRow) []ImportRowError {
+	var rowErrors []ImportRowError
+
+	if err := binding.Validator.ValidateStruct(&row.User); err != nil {
+		var fieldErrors validator.ValidationErrors
+		if !errors.As(err, &fieldErrors) {
+			return []ImportRowError{{Row: row.Row, Error: err.Error()}}
+		}
+
+		for _, fe := range fieldErrors {
+			message := "is invalid"
+			switch fe.Tag() {
+			case "required":
+				message = "is required"
+			case "email":
+				message = "must be a valid email address"
+			case "min":
+				message = fmt.Sprintf("must be at least %s characters", fe.Param())
+			}
+			rowErrors = append(rowErrors, ImportRowError{Row: row.Row, Field: importFieldColumns[fe.Field()], Error: message})
+		}
+	}
+
+	if row.Role != RoleAdmin && row.Role != RoleManager && row.Role != RoleCashier {
+		rowErrors = append(rowErrors, ImportRowError{Row: row.Row, Field: "role", Error: "must be admin, manager or cashier"})
+	}
+
+	return rowErrors
+}
+
+// ExportUsers streams users as CSV. Pass store_id to only export a store's members.
+func (h *UserHandler) ExportUsers(c *gin.Context) {
+	query := `
+		SELECT u.id, u.username, u.email, u.first_name, u.last_name, u.role, u.tenant_id, u.is_active, u.created_at
+		FROM "user" u`
+	var args []interface{}
+	if storeID := c.Query("store_id"); storeID != "" {
+		query += ` JOIN "user_store" us ON us.user_id = u.id WHERE us.store_id = $1`
+		args = append(args, storeID)
+	}
+	query += ` ORDER BY u.username`
+
+	rows, err := h.db.QueryContext(c.Request.Context(), query, args...)
+	if err != nil {
+		if gin.Mode() == "debug" {
+			fmt.Printf("Error during querying users : %s\n", err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export users"})
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="users.csv"`)
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "username", "email", "first_name", "last_name", "role", "tenant_id", "is_active", "created_at"})
+
+	for rows.Next() {
+		var id, username, email, firstName, lastName, role string
+		var tenantID sql.NullString
+		var isActive bool
+		var createdAt sql.NullTime
+		if err := rows.Scan(&id, &username, &email, &firstName, &lastName, &role, &tenantID, &isActive, &createdAt); err != nil {
+			// Headers are already sent, so the export can only be cut short
+			c.Error(err)
+			break
+		}
+
+		var created string
+		if createdAt.Valid {
+			created = createdAt.Time.Format(time.RFC3339)
+		}
+		writeCSVRow(w, id, username, email, firstName, lastName, role, tenantID.String, fmt.Sprint(isActive), created)
+	}
+
+	w.Flush()
+}
+
+// temporaryPasswordAlphabet leaves out characters that are easy to misread
+const temporaryPasswordAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+
+// generateTemporaryPassword generates a random 12 character password
+func generateTemporaryPassword() (string, error) {
+	b := make([]byte, 12)
+	max := big.NewInt(int64(len(temporaryPasswordAlphabet)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = temporaryPasswordAlphabet[n.Int64()]
+	}
+	return string(b), nil
+}
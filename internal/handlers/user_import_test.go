@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseUserImport(t *testing.T) {
+	csv := "\ufeffUsername,Email,First_Name,Last_Name,Role,Password\n" +
+		"jane,jane@example.com,Jane,Doe,manager,secret123\n" +
+		"john,john@example.com,John,Roe,,\n"
+
+	rows, rowErrors, err := parseUserImport(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rowErrors) != 0 {
+		t.Fatalf("Unexpected row errors: %+v", rowErrors)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+
+	if rows[0].Row != 2 || rows[0].Role != RoleManager || rows[0].TemporaryPassword {
+		t.Errorf("Unexpected first row: %+v", rows[0])
+	}
+	if rows[1].Row != 3 || rows[1].Role != RoleCashier || !rows[1].TemporaryPassword || len(rows[1].User.Password) != 12 {
+		t.Errorf("Unexpected second row: %+v", rows[1])
+	}
+}
+
+func TestParseUserImport_RowErrors(t *testing.T) {
+	csv := "username,email,first_name,last_name,role,password\n" +
+		"jane,not-an-email,Jane,Doe,cashier,\n" +
+		"john,john@example.com,,Roe,owner,123\n" +
+		"jane,JOHN@example.com,Jane,Doe,,\n" +
+		"short,row\n"
+
+	rows, rowErrors, err := parseUserImport(strings.NewReader(csv))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("Expected no valid rows, got %d", len(rows))
+	}
+
+	expected := []ImportRowError{
+		{Row: 2, Field: "email", Error: "must be a valid email address"},
+		{Row: 3, Field: "first_name", Error: "is required"},
+		{Row: 3, Field: "password", Error: "must be at least 6 characters"},
+		{Row: 3, Field: "role", Error: "must be admin, manager or cashier"},
+		{Row: 4, Field: "username", Error: "duplicate of row 2"},
+		{Row: 4, Field: "email", Error: "duplicate of row 3"},
+		{Row: 5, Error: "expected 6 fields, got 2"},
+	}
+	if len(rowErrors) != len(expected) {
+		t.Fatalf("Expected %d row errors, got %+v", len(expected), rowErrors)
+	}
+	for i := range expected {
+		if rowErrors[i] != expected[i] {
+			t.Errorf("Row error %d: expected %+v, got %+v", i, expected[i], rowErrors[i])
+		}
+	}
+}
+
+func TestParseUserImport_InvalidHeader(t *testing.T) {
+	for _, csv := range []string{
+		"",
+		"username,email,first_name\n",
+		"username,email,first_name,last_name,nickname\n",
+		"username,email,first_name,last_name,email\n",
+		"username,email,first_name,last_name\n",
+	} {
+		if _, _, err := parseUserImport(strings.NewReader(csv)); err == nil {
+			t.Errorf("Expected error for %q", csv)
+		}
+	}
+}
+
+func TestExportUsers_EscapesFormulas(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, _ := newFakeDB(t, fakeQuery{match: `FROM "user" u`, answer: rows([]driver.Value{
+		"user-1", "+jane", "jane@example.com", "=HYPERLINK(\"http://evil.test\")", "-Doe", "cashier", nil, true, nil,
+	})})
+	h := NewUserHandler(db, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/admin/users/export", nil)
+	h.ExportUsers(c)
+
+	records, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	if err != nil || len(records) != 2 {
+		t.Fatalf("Expected a header and one user, got %v, %v", records, err)
+	}
+	want := []string{"user-1", "'+jane", "jane@example.com", "'=HYPERLINK(\"http://evil.test\")", "'-Doe", "cashier", "", "true", ""}
+	for i, cell := range want {
+		if records[1][i] != cell {
+			t.Errorf("Expected %s %q, got %q", records[0][i], cell, records[1][i])
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package identity
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned when a provider rejects the supplied credentials
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrAccountDisabled is returned when the user's account has been deactivated
+var ErrAccountDisabled = errors.New("account is deactivated")
+
+// User is a gateway user, as resolved by an identity provider
+type User struct {
+	ID        string
+	Username  string
+	Email     string
+	FirstName string
+	LastName  string
+	Role      string
+	TenantID  string
+	Active    bool
+}
+
+// Identity is a user identity asserted by an external identity provider
+type Identity struct {
+	Provider          string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	FirstName         string
+	LastName          string
+}
+
+// PasswordProvider authenticates users with a username and password
+type PasswordProvider interface {
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+}
+
+// FederatedProvider authenticates users through an external
+// authorization-code flow with PKCE
+type FederatedProvider interface {
+	// Name returns the provider name used in login URLs and identity links
+	Name() string
+
+	// AuthCodeURL returns the URL the browser is sent to for sign-in
+	AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+
+	// Exchange redeems the authorization code and returns the verified identity
+	Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error)
+}
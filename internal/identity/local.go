@@ -0,0 +1,50 @@
+package identity
+
+import (
+	"context"
+	"database/sql"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LocalProvider authenticates users against the password hashes in the "user" table
+type LocalProvider struct {
+	db *sql.DB
+}
+
+// NewLocalProvider creates a new local password provider
+func NewLocalProvider(db *sql.DB) *LocalProvider {
+	return &LocalProvider{
+		db: db,
+	}
+}
+
+// Authenticate checks the username and password and returns the matching user.
+// On a wrong password or a deactivated account the user is returned alongside
+// the error so the caller can attribute the failed attempt.
+func (p *LocalProvider) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	var user User
+	var hashedPassword string
+	var tenantID sql.NullString
+
+	query := `SELECT id, username, password_hash, first_name, last_name, email, role, tenant_id, is_active FROM "user" WHERE username = $1`
+	err := p.db.QueryRowContext(ctx, query, username).Scan(
+		&user.ID, &user.Username, &hashedPassword, &user.FirstName, &user.LastName, &user.Email, &user.Role, &tenantID, &user.Active,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return &user, ErrInvalidCredentials
+	}
+	if !user.Active {
+		return &user, ErrAccountDisabled
+	}
+
+	user.TenantID = tenantID.String
+	return &user, nil
+}
@@ -0,0 +1,309 @@
+package identity
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// defaultOIDCScopes are requested when a provider has no scopes configured
+var defaultOIDCScopes = []string{"openid", "email", "profile"}
+
+// OIDCProvider runs a generic OpenID Connect authorization-code flow with PKCE.
+// Endpoints and signing keys are discovered from the issuer on first use.
+type OIDCProvider struct {
+	name   string
+	config config.OIDCProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// oidcDiscovery holds the fields of the issuer's discovery document the gateway uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcIDTokenClaims holds the ID token claims the gateway uses
+type oidcIDTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	GivenName         string `json:"given_name"`
+	FamilyName        string `json:"family_name"`
+	jwt.RegisteredClaims
+}
+
+// NewOIDCProvider creates a new OpenID Connect provider
+func NewOIDCProvider(name string, cfg config.OIDCProviderConfig) *OIDCProvider {
+	return &OIDCProvider{
+		name:   name,
+		config: cfg,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Name returns the provider name
+func (p *OIDCProvider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the authorization endpoint URL for a new sign-in
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and verifies the returned ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var tokenResponse struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokenResponse.IDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	claims, err := p.verifyIDToken(ctx, tokenResponse.IDToken, discovery.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("id_token nonce mismatch")
+	}
+
+	return &Identity{
+		Provider:          p.name,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		FirstName:         claims.GivenName,
+		LastName:          claims.FamilyName,
+	}, nil
+}
+
+// verifyIDToken checks the ID token signature, issuer, audience and expiry
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, idToken, issuer string) (*oidcIDTokenClaims, error) {
+	claims := &oidcIDTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+
+	return claims, nil
+}
+
+// signingKey returns the issuer's public key with the given key ID.
+// The key set is fetched again when the key is unknown, to pick up key rotation.
+func (p *OIDCProvider) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	discovery, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := p.fetchKeys(ctx, discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// discover fetches and caches the issuer's discovery document
+func (p *OIDCProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	var discovery oidcDiscovery
+	if err := p.getJSON(ctx, discoveryURL, &discovery); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(p.config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery issuer %q does not match configured issuer", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is missing endpoints")
+	}
+
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// fetchKeys downloads the issuer's JSON Web Key Set and returns its RSA keys by key ID
+func (p *OIDCProvider) fetchKeys(ctx context.Context, jwksURI string) (map[string]*rsa.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, jwksURI, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// getJSON performs a GET request and decodes the JSON response
+func (p *OIDCProvider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// CodeChallenge derives the S256 PKCE code challenge from a code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
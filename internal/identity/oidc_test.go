@@ -0,0 +1,224 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// fakeOIDCServer is a minimal OpenID Connect provider for tests. The token
+// endpoint returns an ID token built from the claims in the idToken field.
+type fakeOIDCServer struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	idToken  jwt.MapClaims
+	verifier string
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	f := &fakeOIDCServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.verifier = r.PostForm.Get("code_verifier")
+		if r.PostForm.Get("code") != "good-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, f.idToken)
+		token.Header["kid"] = "test-key"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	f.idToken = jwt.MapClaims{
+		"iss":                f.URL,
+		"sub":                "corp-123",
+		"aud":                "gateway",
+		"exp":                time.Now().Add(time.Minute).Unix(),
+		"nonce":              "test-nonce",
+		"email":              "jane@example.com",
+		"email_verified":     true,
+		"preferred_username": "jane",
+		"given_name":         "Jane",
+		"family_name":        "Doe",
+	}
+	return f
+}
+
+func (f *fakeOIDCServer) provider() *OIDCProvider {
+	return NewOIDCProvider("corporate", config.OIDCProviderConfig{
+		Issuer:      f.URL,
+		ClientID:    "gateway",
+		RedirectURL: "http://gateway.test/api/v1/auth/oidc/corporate/callback",
+	})
+}
+
+func TestOIDCProviderAuthCodeURL(t *testing.T) {
+	f := newFakeOIDCServer(t)
+
+	authURL, err := f.provider().AuthCodeURL(context.Background(), "test-state", "test-nonce", CodeChallenge("test-verifier"))
+	if err != nil {
+		t.Fatalf("AuthCodeURL() error = %v", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() returned invalid URL: %v", err)
+	}
+	if u.Path != "/authorize" {
+		t.Errorf("path = %q, want /authorize", u.Path)
+	}
+
+	query := u.Query()
+	expected := map[string]string{
+		"response_type":         "code",
+		"client_id":             "gateway",
+		"state":                 "test-state",
+		"nonce":                 "test-nonce",
+		"scope":                 "openid email profile",
+		"code_challenge":        CodeChallenge("test-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for name, want := range expected {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestOIDCProviderExchange(t *testing.T) {
+	f := newFakeOIDCServer(t)
+
+	id, err := f.provider().Exchange(context.Background(), "good-code", "test-verifier", "test-nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+
+	if f.verifier != "test-verifier" {
+		t.Errorf("code_verifier sent = %q, want test-verifier", f.verifier)
+	}
+
+	want := Identity{
+		Provider:          "corporate",
+		Subject:           "corp-123",
+		Email:             "jane@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "jane",
+		FirstName:         "Jane",
+		LastName:          "Doe",
+	}
+	if *id != want {
+		t.Errorf("Exchange() = %+v, want %+v", *id, want)
+	}
+}
+
+func TestOIDCProviderExchangeRejectsInvalidTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		nonce  string
+		mutate func(claims jwt.MapClaims)
+	}{
+		{
+			name:  "rejected code",
+			code:  "bad-code",
+			nonce: "test-nonce",
+		},
+		{
+			name:  "nonce mismatch",
+			code:  "good-code",
+			nonce: "other-nonce",
+		},
+		{
+			name:   "wrong audience",
+			code:   "good-code",
+			nonce:  "test-nonce",
+			mutate: func(claims jwt.MapClaims) { claims["aud"] = "someone-else" },
+		},
+		{
+			name:   "wrong issuer",
+			code:   "good-code",
+			nonce:  "test-nonce",
+			mutate: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" },
+		},
+		{
+			name:   "expired",
+			code:   "good-code",
+			nonce:  "test-nonce",
+			mutate: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() },
+		},
+		{
+			name:   "missing subject",
+			code:   "good-code",
+			nonce:  "test-nonce",
+			mutate: func(claims jwt.MapClaims) { delete(claims, "sub") },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOIDCServer(t)
+			if tt.mutate != nil {
+				tt.mutate(f.idToken)
+			}
+
+			if _, err := f.provider().Exchange(context.Background(), tt.code, "test-verifier", tt.nonce); err == nil {
+				t.Error("Exchange() expected an error")
+			}
+		})
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("CodeChallenge() = %q, want %q", got, want)
+	}
+}
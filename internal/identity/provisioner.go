@@ -0,0 +1,173 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Provisioning errors
+var (
+	ErrMissingEmail     = errors.New("identity provider did not return an email")
+	ErrEmailNotVerified = errors.New("email is not verified by the identity provider")
+)
+
+// Provisioner maps external identities to gateway users. Known identities are
+// looked up by their link, existing accounts are linked by verified email, and
+// anyone else gets a new account just in time.
+type Provisioner struct {
+	db *sql.DB
+}
+
+// NewProvisioner creates a new provisioner
+func NewProvisioner(db *sql.DB) *Provisioner {
+	return &Provisioner{
+		db: db,
+	}
+}
+
+// Resolve returns the gateway user for the identity, linking or creating it as needed.
+// New users get defaultRole.
+func (p *Provisioner) Resolve(ctx context.Context, id *Identity, defaultRole string) (*User, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Identity already linked
+	user, err := scanUser(tx.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.email, u.first_name, u.last_name, u.role, u.tenant_id, u.is_active
+		FROM user_identity ui
+		JOIN "user" u ON u.id = ui.user_id
+		WHERE ui.provider = $1 AND ui.subject = $2`, id.Provider, id.Subject))
+	if err == nil {
+		if !user.Active {
+			return nil, ErrAccountDisabled
+		}
+		return user, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if id.Email == "" {
+		return nil, ErrMissingEmail
+	}
+
+	// Existing account with the same email
+	user, err = scanUser(tx.QueryRowContext(ctx, `
+		SELECT id, username, email, first_name, last_name, role, tenant_id, is_active
+		FROM "user"
+		WHERE lower(email) = lower($1)`, id.Email))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows {
+		user, err = p.createUser(ctx, tx, id, defaultRole)
+		if err != nil {
+			return nil, err
+		}
+	} else if !id.EmailVerified {
+		return nil, ErrEmailNotVerified
+	} else if !user.Active {
+		return nil, ErrAccountDisabled
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO "user_identity" (provider, subject, user_id, email)
+		VALUES ($1, $2, $3, $4)`, id.Provider, id.Subject, user.ID, id.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, tx.Commit()
+}
+
+// createUser provisions a new user for the identity. The account gets an
+// unusable random password, so it can only sign in through the provider.
+func (p *Provisioner) createUser(ctx context.Context, tx *sql.Tx, id *Identity, role string) (*User, error) {
+	username, err := p.availableUsername(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(secret)), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	firstName := id.FirstName
+	if firstName == "" {
+		firstName = username
+	}
+
+	user := &User{
+		Username:  username,
+		Email:     id.Email,
+		FirstName: firstName,
+		LastName:  id.LastName,
+		Role:      role,
+		Active:    true,
+	}
+
+	// The account is created by the provider that asserted the identity
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO "user" (username, email, first_name, last_name, password_hash, role, is_active, created_by, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)
+		RETURNING id`,
+		user.Username, user.Email, user.FirstName, user.LastName, string(hashedPassword), user.Role, user.Active, id.Provider).Scan(&user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// availableUsername picks the preferred username if it is free, then the
+// email, then the email suffixed with part of the provider subject
+func (p *Provisioner) availableUsername(ctx context.Context, tx *sql.Tx, id *Identity) (string, error) {
+	suffix := strings.ToLower(id.Subject)
+	if len(suffix) > 8 {
+		suffix = suffix[:8]
+	}
+
+	candidates := []string{id.PreferredUsername, id.Email, id.Email + "-" + suffix}
+	for _, candidate := range candidates {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "" {
+			continue
+		}
+
+		var exists bool
+		err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM "user" WHERE username = $1)`, candidate).Scan(&exists)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+	}
+
+	return "", errors.New("no available username for identity")
+}
+
+// scanUser reads a user row in the column order id, username, email, first_name, last_name, role, tenant_id, is_active
+func scanUser(row *sql.Row) (*User, error) {
+	var user User
+	var tenantID sql.NullString
+	if err := row.Scan(&user.ID, &user.Username, &user.Email, &user.FirstName, &user.LastName, &user.Role, &tenantID, &user.Active); err != nil {
+		return nil, err
+	}
+	user.TenantID = tenantID.String
+	return &user, nil
+}
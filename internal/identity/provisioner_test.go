@@ -0,0 +1,199 @@
+package identity
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeUsers is a "user" and "user_identity" table behind a database/sql driver,
+// enough for the queries of the provisioner
+type fakeUsers struct {
+	users      []fakeUser
+	identities map[string]string // provider/subject to user id
+}
+
+type fakeUser struct {
+	id, username, email, role string
+	active                    bool
+}
+
+// notNullColumns have no default in the initial schema
+var notNullColumns = []string{"username", "email", "first_name", "last_name", "password_hash", "created_by", "updated_by", "is_active"}
+
+var insertColumns = regexp.MustCompile(`INSERT INTO "user" \(([^)]*)\)`)
+
+func (f *fakeUsers) Connect(context.Context) (driver.Conn, error) { return f, nil }
+func (f *fakeUsers) Driver() driver.Driver                        { return nil }
+func (f *fakeUsers) Prepare(string) (driver.Stmt, error)          { return nil, errors.New("not supported") }
+func (f *fakeUsers) Close() error                                 { return nil }
+func (f *fakeUsers) Begin() (driver.Tx, error)                    { return f, nil }
+func (f *fakeUsers) Commit() error                                { return nil }
+func (f *fakeUsers) Rollback() error                              { return nil }
+
+func (f *fakeUsers) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	userRow := func(u fakeUser) []driver.Value {
+		return []driver.Value{u.id, u.username, u.email, "First", "Last", u.role, nil, u.active}
+	}
+	columns := []string{"id", "username", "email", "first_name", "last_name", "role", "tenant_id", "is_active"}
+
+	switch {
+	case strings.Contains(query, "FROM user_identity"):
+		userID := f.identities[args[0].Value.(string)+"/"+args[1].Value.(string)]
+		for _, u := range f.users {
+			if u.id == userID {
+				return &fakeRows{columns: columns, rows: [][]driver.Value{userRow(u)}}, nil
+			}
+		}
+		return &fakeRows{columns: columns}, nil
+
+	case strings.Contains(query, "lower(email)"):
+		for _, u := range f.users {
+			if strings.EqualFold(u.email, args[0].Value.(string)) {
+				return &fakeRows{columns: columns, rows: [][]driver.Value{userRow(u)}}, nil
+			}
+		}
+		return &fakeRows{columns: columns}, nil
+
+	case strings.Contains(query, "SELECT EXISTS"):
+		exists := false
+		for _, u := range f.users {
+			exists = exists || u.username == args[0].Value.(string)
+		}
+		return &fakeRows{columns: []string{"exists"}, rows: [][]driver.Value{{exists}}}, nil
+
+	case strings.Contains(query, `INSERT INTO "user"`):
+		columns := strings.Split(insertColumns.FindStringSubmatch(query)[1], ", ")
+		for _, column := range notNullColumns {
+			if !slices.Contains(columns, column) {
+				return nil, errors.New(`null value in column "` + column + `" violates not-null constraint`)
+			}
+		}
+		u := fakeUser{id: "new-user", username: args[0].Value.(string), email: args[1].Value.(string), role: args[5].Value.(string), active: true}
+		f.users = append(f.users, u)
+		return &fakeRows{columns: []string{"id"}, rows: [][]driver.Value{{u.id}}}, nil
+	}
+	return nil, errors.New("unexpected query: " + query)
+}
+
+func (f *fakeUsers) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !strings.Contains(query, `INSERT INTO "user_identity"`) {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	f.identities[args[0].Value.(string)+"/"+args[1].Value.(string)] = args[2].Value.(string)
+	return driver.RowsAffected(1), nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func newFakeProvisioner(users ...fakeUser) (*Provisioner, *fakeUsers) {
+	f := &fakeUsers{users: users, identities: map[string]string{}}
+	return NewProvisioner(sql.OpenDB(f)), f
+}
+
+func corporateIdentity() *Identity {
+	return &Identity{
+		Provider:          "corporate",
+		Subject:           "corp-123",
+		Email:             "jane@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "jane",
+		FirstName:         "Jane",
+		LastName:          "Doe",
+	}
+}
+
+func TestProvisionerResolve_CreatesUser(t *testing.T) {
+	p, f := newFakeProvisioner(fakeUser{id: "other", username: "jane", email: "other@example.com", role: "cashier", active: true})
+
+	user, err := p.Resolve(context.Background(), corporateIdentity(), "cashier")
+	if err != nil {
+		t.Fatalf("Expected a new user, got %v", err)
+	}
+	if user.ID != "new-user" || user.Role != "cashier" || !user.Active {
+		t.Errorf("Expected an active cashier, got %+v", user)
+	}
+	// The preferred username is taken, so the email is used
+	if user.Username != "jane@example.com" {
+		t.Errorf("Expected the email as username, got %s", user.Username)
+	}
+	if f.identities["corporate/corp-123"] != "new-user" {
+		t.Errorf("Expected the identity linked to the new user, got %v", f.identities)
+	}
+
+	// The next sign-in finds the linked user
+	again, err := p.Resolve(context.Background(), corporateIdentity(), "cashier")
+	if err != nil || again.ID != "new-user" || len(f.users) != 2 {
+		t.Errorf("Expected the linked user, got %+v, %v", again, err)
+	}
+}
+
+func TestProvisionerResolve_LinksByEmail(t *testing.T) {
+	existing := fakeUser{id: "jane", username: "jdoe", email: "Jane@Example.com", role: "manager", active: true}
+
+	tests := []struct {
+		name     string
+		user     fakeUser
+		verified bool
+		wantErr  error
+	}{
+		{"verified email", existing, true, nil},
+		{"unverified email", existing, false, ErrEmailNotVerified},
+		{"disabled account", fakeUser{id: "jane", username: "jdoe", email: "jane@example.com", active: false}, true, ErrAccountDisabled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, f := newFakeProvisioner(tt.user)
+			id := corporateIdentity()
+			id.EmailVerified = tt.verified
+
+			user, err := p.Resolve(context.Background(), id, "cashier")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				if len(f.identities) != 0 {
+					t.Errorf("Expected no identity linked, got %v", f.identities)
+				}
+				return
+			}
+			if user.ID != "jane" || user.Role != "manager" || len(f.users) != 1 {
+				t.Errorf("Expected the existing user, got %+v", user)
+			}
+			if f.identities["corporate/corp-123"] != "jane" {
+				t.Errorf("Expected the identity linked to the existing user, got %v", f.identities)
+			}
+		})
+	}
+}
+
+func TestProvisionerResolve_MissingEmail(t *testing.T) {
+	p, _ := newFakeProvisioner()
+	id := corporateIdentity()
+	id.Email = ""
+
+	if _, err := p.Resolve(context.Background(), id, "cashier"); !errors.Is(err, ErrMissingEmail) {
+		t.Errorf("Expected ErrMissingEmail, got %v", err)
+	}
+}
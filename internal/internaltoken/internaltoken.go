@@ -0,0 +1,201 @@
+// Package internaltoken issues the short-lived identity tokens the gateway
+// attaches to the requests it proxies, so services can verify that a request
+// came through the gateway and who made it. The verification keys are
+// published as a JSON Web Key Set.
+package internaltoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
+)
+
+// Header carries the internal token on proxied requests
+const Header = "X-Gateway-Token"
+
+// Claims are the verified identity of a proxied request. The registered claims
+// name the gateway as issuer and the service as audience.
+type Claims struct {
+	SubjectType string `json:"sub_type,omitempty"`
+	Username    string `json:"username,omitempty"`
+	Email       string `json:"email,omitempty"`
+	Fullname    string `json:"full_name,omitempty"`
+	Role        string `json:"role,omitempty"`
+	GuestID     string `json:"guest_id,omitempty"`
+	DeviceID    string `json:"device_id,omitempty"`
+	TenantID    string `json:"tenant_id,omitempty"`
+	StoreID     string `json:"store_id,omitempty"`
+	Scope       string `json:"scope,omitempty"`
+	RequestID   string `json:"request_id,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// JSONWebKey is a published RSA verification key
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// KeySet is a JSON Web Key Set
+type KeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// Signer signs internal tokens
+type Signer struct {
+	key    *rsa.PrivateKey
+	keyID  string
+	issuer string
+	ttl    time.Duration
+	keySet KeySet
+}
+
+// NewSigner loads the signing key and the retired keys still published for rotation
+func NewSigner(cfg config.InternalTokenConfig) (*Signer, error) {
+	key, err := loadPrivateKey(cfg.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load internal token signing key: %w", err)
+	}
+
+	signingKey, err := publicJWK(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	signer := &Signer{
+		key:    key,
+		keyID:  signingKey.Kid,
+		issuer: cfg.Issuer,
+		ttl:    time.Duration(cfg.TTL) * time.Second,
+		keySet: KeySet{Keys: []JSONWebKey{signingKey}},
+	}
+
+	for _, path := range cfg.PublishedKeyPaths {
+		publicKey, err := loadPublicKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load published key %s: %w", path, err)
+		}
+		jwk, err := publicJWK(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		if jwk.Kid != signer.keyID {
+			signer.keySet.Keys = append(signer.keySet.Keys, jwk)
+		}
+	}
+
+	return signer, nil
+}
+
+// Sign issues a token for the identity, valid for the given service only
+func (s *Signer) Sign(claims Claims, audience string, now time.Time) (string, error) {
+	tokenID, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	claims.Issuer = s.issuer
+	claims.Audience = jwt.ClaimStrings{audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(s.ttl))
+	claims.ID = tokenID
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.keyID
+	return token.SignedString(s.key)
+}
+
+// KeySet returns the keys services verify internal tokens with
+func (s *Signer) KeySet() KeySet {
+	return s.keySet
+}
+
+// publicJWK describes an RSA public key, identified by its RFC 7638 thumbprint
+func publicJWK(key *rsa.PublicKey) (JSONWebKey, error) {
+	jwk := dpop.JWK{
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+	thumbprint, err := jwk.Thumbprint()
+	if err != nil {
+		return JSONWebKey{}, err
+	}
+
+	return JSONWebKey{Kty: jwk.Kty, Use: "sig", Alg: "RS256", Kid: thumbprint, N: jwk.N, E: jwk.E}, nil
+}
+
+// loadPrivateKey reads a PEM encoded PKCS #1 or PKCS #8 RSA private key
+func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA private key")
+	}
+	return rsaKey, nil
+}
+
+// loadPublicKey reads a PEM encoded PKIX RSA public key
+func loadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	return block, nil
+}
+
+// randomID returns a random token ID
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
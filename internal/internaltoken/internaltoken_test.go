@@ -0,0 +1,139 @@
+package internaltoken
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
+)
+
+// writeTestKey generates an RSA key and writes its private and public halves to temp files
+func writeTestKey(t *testing.T) (privateKeyPath, publicKeyPath string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	dir := t.TempDir()
+	privateKeyPath = filepath.Join(dir, "internalPrivateKey.pem")
+	publicKeyPath = filepath.Join(dir, "internalPublicKey.pem")
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	if err := os.WriteFile(privateKeyPath, privateKeyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write private key: %v", err)
+	}
+	if err := os.WriteFile(publicKeyPath, publicKeyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return privateKeyPath, publicKeyPath
+}
+
+// verify checks a token against the published keys the way a service would
+func verify(t *testing.T, keySet KeySet, tokenString, audience string) *Claims {
+	t.Helper()
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		for _, key := range keySet.Keys {
+			if key.Kid == token.Header["kid"] {
+				jwk := dpop.JWK{Kty: key.Kty, N: key.N, E: key.E}
+				return jwk.PublicKey()
+			}
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer("mini-kiosk-central-gateway"), jwt.WithAudience(audience))
+	if err != nil {
+		t.Fatalf("Failed to verify token: %v", err)
+	}
+	return token.Claims.(*Claims)
+}
+
+func TestSigner(t *testing.T) {
+	privateKeyPath, _ := writeTestKey(t)
+	signer, err := NewSigner(config.InternalTokenConfig{
+		PrivateKeyPath: privateKeyPath,
+		Issuer:         "mini-kiosk-central-gateway",
+		TTL:            60,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	now := time.Now()
+	claims := Claims{Username: "existinguser", TenantID: "tenant-id", Scope: "orders:read"}
+	claims.Subject = "subject-id"
+	token, err := signer.Sign(claims, "order_service", now)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+
+	got := verify(t, signer.KeySet(), token, "order_service")
+	if got.Subject != "subject-id" || got.Username != "existinguser" || got.TenantID != "tenant-id" || got.Scope != "orders:read" {
+		t.Errorf("Expected the identity claims, got %+v", got)
+	}
+	if got.ID == "" {
+		t.Error("Expected a token ID")
+	}
+	if got.ExpiresAt.Sub(now) > time.Minute {
+		t.Errorf("Expected the token to expire within the TTL, got %v", got.ExpiresAt)
+	}
+
+	if _, err := jwt.ParseWithClaims(token, &Claims{}, func(*jwt.Token) (interface{}, error) {
+		return &signer.key.PublicKey, nil
+	}, jwt.WithAudience("payment_service")); err == nil {
+		t.Error("Expected the token to be rejected for another service")
+	}
+}
+
+func TestSigner_PublishedKeys(t *testing.T) {
+	privateKeyPath, publicKeyPath := writeTestKey(t)
+	_, retiredKeyPath := writeTestKey(t)
+
+	signer, err := NewSigner(config.InternalTokenConfig{
+		PrivateKeyPath:    privateKeyPath,
+		PublishedKeyPaths: []string{retiredKeyPath, publicKeyPath},
+		TTL:               60,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+
+	keys := signer.KeySet().Keys
+	if len(keys) != 2 {
+		t.Fatalf("Expected the signing key and the retired key, got %d keys", len(keys))
+	}
+	if keys[0].Kid != signer.keyID || keys[1].Kid == signer.keyID {
+		t.Errorf("Expected the signing key first, got %+v", keys)
+	}
+	for _, key := range keys {
+		if key.Kty != "RSA" || key.Use != "sig" || key.Alg != "RS256" {
+			t.Errorf("Expected an RS256 signing key, got %+v", key)
+		}
+	}
+}
+
+func TestNewSigner_MissingKey(t *testing.T) {
+	_, err := NewSigner(config.InternalTokenConfig{PrivateKeyPath: filepath.Join(t.TempDir(), "missing.pem")})
+	if err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
+
+func TestHeaderIsReserved(t *testing.T) {
+	if !config.IsIdentityHeader(Header) {
+		t.Errorf("Expected %s to be in the identity header namespace", Header)
+	}
+}
@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// Mail drivers
+const (
+	DriverLog  = "log"
+	DriverSMTP = "smtp"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New creates the mailer selected by the configured driver. An empty driver
+// sends no mail, and New returns a nil Mailer.
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "":
+		return nil, nil
+	case DriverLog:
+		return &LogMailer{}, nil
+	case DriverSMTP:
+		if cfg.SMTP.Host == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mailer requires mail.smtp.host and mail.from")
+		}
+		return &SMTPMailer{
+			from:   cfg.From,
+			config: cfg.SMTP,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
+
+// LogMailer writes messages to the log instead of sending them, for local
+// development. Bodies carry sign-in links, invite codes and passwords, so only
+// the recipient and subject are logged.
+type LogMailer struct{}
+
+// Send logs the recipient and subject of the message
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("Mail to %s: %s (body not logged)", msg.To, msg.Subject)
+	return nil
+}
+
+// SMTPMailer sends messages through an SMTP relay
+type SMTPMailer struct {
+	from   string
+	config config.SMTPConfig
+}
+
+// Send delivers the message. The relay is authenticated with PLAIN auth when a username is configured.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port))
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+	}
+
+	if err := smtp.SendMail(addr, auth, m.from, []string{msg.To}, formatMessage(m.from, msg)); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// formatMessage builds the RFC 5322 message. Header values are stripped of line
+// breaks so user input cannot add headers.
+func formatMessage(from string, msg Message) []byte {
+	clean := strings.NewReplacer("\r", "", "\n", "")
+
+	var b strings.Builder
+	b.WriteString("From: " + clean.Replace(from) + "\r\n")
+	b.WriteString("To: " + clean.Replace(msg.To) + "\r\n")
+	b.WriteString("Subject: " + clean.Replace(msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	if m, err := New(config.MailConfig{}); err != nil || m != nil {
+		t.Errorf("Expected no mailer for empty driver, got %T, %v", m, err)
+	}
+	if m, err := New(config.MailConfig{Driver: DriverLog}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if _, ok := m.(*LogMailer); !ok {
+		t.Errorf("Expected log mailer, got %T", m)
+	}
+
+	smtpConfig := config.MailConfig{Driver: DriverSMTP, From: "no-reply@example.com", SMTP: config.SMTPConfig{Host: "smtp.example.com", Port: 587}}
+	if m, err := New(smtpConfig); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	} else if _, ok := m.(*SMTPMailer); !ok {
+		t.Errorf("Expected SMTP mailer, got %T", m)
+	}
+
+	if _, err := New(config.MailConfig{Driver: DriverSMTP}); err == nil {
+		t.Error("Expected error for SMTP without host")
+	}
+	if _, err := New(config.MailConfig{Driver: "carrier-pigeon"}); err == nil {
+		t.Error("Expected error for unknown driver")
+	}
+}
+
+func TestLogMailer_RedactsBody(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	(&LogMailer{}).Send(context.Background(), Message{To: "jane@example.com", Subject: "Sign in", Body: "https://example.com/magic-link?token=secret"})
+
+	if !strings.Contains(out.String(), "jane@example.com") || strings.Contains(out.String(), "secret") {
+		t.Errorf("Expected the recipient logged without the body, got %q", out.String())
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	msg := formatMessage("no-reply@example.com", Message{
+		To:      "jane@example.com\r\nBcc: everyone@example.com",
+		Subject: "Sign in",
+		Body:    "Hello\nWorld",
+	})
+
+	s := string(msg)
+	if strings.Contains(s, "\r\nBcc:") {
+		t.Error("Header injection was not stripped")
+	}
+	if !strings.Contains(s, "Subject: Sign in\r\n") {
+		t.Error("Missing subject header")
+	}
+	if !strings.HasSuffix(s, "\r\n\r\nHello\r\nWorld") {
+		t.Errorf("Unexpected body in %q", s)
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+)
+
+// dpopScheme is the authorization scheme for DPoP-bound access tokens
+const dpopScheme = "DPoP "
+
+// splitAuthorization returns the token from an Authorization header and
+// whether it was sent with the DPoP scheme
+func splitAuthorization(header string) (string, bool) {
+	if token, ok := strings.CutPrefix(header, dpopScheme); ok {
+		return token, true
+	}
+	return strings.TrimPrefix(header, "Bearer "), false
+}
+
+// verifyDPoP checks that a DPoP-bound token comes with a fresh proof from its
+// key, and records the key for handlers that issue new tokens. Unbound tokens
+// must not be sent with the DPoP scheme.
+func verifyDPoP(c *gin.Context, claims *handlers.Claims, token string, sentWithDPoP bool) error {
+	if claims.Confirmation == nil || claims.Confirmation.JKT == "" {
+		if sentWithDPoP {
+			return errors.New("token is not bound to a dpop key")
+		}
+		return nil
+	}
+
+	// A bound token sent as a bearer token may have been copied off the client
+	if !sentWithDPoP {
+		return errors.New("dpop-bound token sent with the bearer scheme")
+	}
+
+	proof, err := dpop.Verify(c.GetHeader(dpop.HeaderName), c.Request.Method, dpop.RequestURL(c.Request), token)
+	if err != nil {
+		return err
+	}
+	if proof.JKT != claims.Confirmation.JKT {
+		return errors.New("dpop proof key does not match the token")
+	}
+
+	c.Set("dpop_jkt", proof.JKT)
+	return nil
+}
+
+// abortInvalidDPoP rejects a request whose DPoP proof could not be verified
+func abortInvalidDPoP(c *gin.Context) {
+	c.Header("WWW-Authenticate", `DPoP error="invalid_dpop_proof"`)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid DPoP proof"})
+	c.Abort()
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+)
+
+// newTestDPoPKey generates a proof key and returns it with its JWK and thumbprint
+func newTestDPoPKey(t *testing.T) (*ecdsa.PrivateKey, *dpop.JWK, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	jwk := &dpop.JWK{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+	jkt, err := jwk.Thumbprint()
+	if err != nil {
+		t.Fatalf("Failed to compute thumbprint: %v", err)
+	}
+	return key, jwk, jkt
+}
+
+// signTestDPoPProof signs a proof for the request, bound to the access token
+func signTestDPoPProof(t *testing.T, key *ecdsa.PrivateKey, jwk *dpop.JWK, method, url, accessToken string) string {
+	t.Helper()
+
+	sum := sha256.Sum256([]byte(accessToken))
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"jti": uuid.New().String(),
+		"htm": method,
+		"htu": url,
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(sum[:]),
+	})
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = jwk
+
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("Failed to sign proof: %v", err)
+	}
+	return proof
+}
+
+func TestAuthMiddleware_DPoP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+	proofKey, jwk, jkt := newTestDPoPKey(t)
+	otherKey, otherJWK, _ := newTestDPoPKey(t)
+
+	claims := &handlers.Claims{
+		Username:     "existinguser",
+		SubjectType:  handlers.SubjectTypeUser,
+		Confirmation: &handlers.Confirmation{JKT: jkt},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "subject-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+		},
+	}
+	boundToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	bearerToken := signTestToken(t, privateKey, handlers.SubjectTypeUser)
+
+	router := gin.New()
+	router.GET("/protected", JWTAuthMiddleware(publicKeyPath), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("dpop_jkt"))
+	})
+
+	const url = "http://example.com/protected"
+	tests := []struct {
+		name          string
+		authorization string
+		proof         string
+		want          int
+	}{
+		{"bound token with proof", "DPoP " + boundToken, signTestDPoPProof(t, proofKey, jwk, "GET", url, boundToken), http.StatusOK},
+		{"bound token without proof", "DPoP " + boundToken, "", http.StatusUnauthorized},
+		{"bound token as bearer", "Bearer " + boundToken, signTestDPoPProof(t, proofKey, jwk, "GET", url, boundToken), http.StatusUnauthorized},
+		{"proof from another key", "DPoP " + boundToken, signTestDPoPProof(t, otherKey, otherJWK, "GET", url, boundToken), http.StatusUnauthorized},
+		{"proof for another token", "DPoP " + boundToken, signTestDPoPProof(t, proofKey, jwk, "GET", url, bearerToken), http.StatusUnauthorized},
+		{"proof for another url", "DPoP " + boundToken, signTestDPoPProof(t, proofKey, jwk, "GET", "http://example.com/other", boundToken), http.StatusUnauthorized},
+		{"unbound token as dpop", "DPoP " + bearerToken, signTestDPoPProof(t, proofKey, jwk, "GET", url, bearerToken), http.StatusUnauthorized},
+		{"unbound token as bearer", "Bearer " + bearerToken, "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", url, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			if tt.proof != "" {
+				req.Header.Set("DPoP", tt.proof)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusOK && tt.proof != "" && w.Body.String() != jkt {
+				t.Errorf("Expected the proof key to be recorded, got %q", w.Body.String())
+			}
+		})
+	}
+
+	t.Run("replayed proof", func(t *testing.T) {
+		proof := signTestDPoPProof(t, proofKey, jwk, "GET", url, boundToken)
+		for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+			req, _ := http.NewRequest("GET", url, nil)
+			req.Header.Set("Authorization", "DPoP "+boundToken)
+			req.Header.Set("DPoP", proof)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != want {
+				t.Errorf("Request %d: expected status %d, got %d", i+1, want, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// StripIdentityHeaders removes the identity headers from incoming requests. The
+// authentication middleware sets them again from verified token claims, so a
+// client cannot pose as another user by sending them itself, even on routes
+// that need no token.
+func StripIdentityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, name := range config.IdentityHeaders {
+			c.Request.Header.Del(name)
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+)
+
+func TestStripIdentityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var forwarded http.Header
+	router := gin.New()
+	router.Use(StripIdentityHeaders())
+	router.GET("/public", func(c *gin.Context) {
+		forwarded = c.Request.Header
+		c.Status(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest(http.MethodGet, "/public", nil)
+	for _, name := range config.IdentityHeaders {
+		req.Header.Set(name, "forged")
+	}
+	req.Header.Set("x-user-id", "forged")
+	req.Header.Set("X-Order-Source", "kiosk")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	for _, name := range config.IdentityHeaders {
+		if forwarded.Get(name) != "" {
+			t.Errorf("Expected %s to be removed", name)
+		}
+	}
+	if forwarded.Get("X-Order-Source") != "kiosk" {
+		t.Error("Expected other headers to be kept")
+	}
+}
+
+func TestAuthMiddleware_SetsOnlyIdentityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+
+	var forwarded http.Header
+	router := gin.New()
+	router.GET("/protected", GuestAuthMiddleware(publicKeyPath), func(c *gin.Context) {
+		forwarded = c.Request.Header
+		c.Status(http.StatusOK)
+	})
+
+	for _, subjectType := range []string{handlers.SubjectTypeUser, handlers.SubjectTypeGuest} {
+		t.Run(subjectType, func(t *testing.T) {
+			claims := &handlers.Claims{
+				Username:    "existinguser",
+				Email:       "user@example.com",
+				Fullname:    "Existing User",
+				Role:        handlers.RoleManager,
+				SubjectType: subjectType,
+				GuestID:     "guest-id",
+				DeviceID:    "device-id",
+				TenantID:    "tenant-id",
+				StoreID:     "store-id",
+				Scope:       "orders:read",
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "subject-id",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+			if err != nil {
+				t.Fatalf("Failed to sign token: %v", err)
+			}
+
+			w := performRequest(router, http.MethodGet, "/protected", token)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			for name := range forwarded {
+				if name != "Authorization" && !config.IsIdentityHeader(name) {
+					t.Errorf("Expected %s to be in the identity header namespace", name)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_TenantAndStoreFromClaims(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+
+	var forwarded http.Header
+	router := gin.New()
+	router.Use(StripIdentityHeaders())
+	router.GET("/protected", GuestAuthMiddleware(publicKeyPath), func(c *gin.Context) {
+		forwarded = c.Request.Header
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		tenantID string
+		storeID  string
+	}{
+		{"store session", "tenant-1", "store-1"},
+		{"session without a store", "tenant-1", ""},
+		{"user without a tenant", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &handlers.Claims{
+				Username:    "existinguser",
+				SubjectType: handlers.SubjectTypeUser,
+				TenantID:    tt.tenantID,
+				StoreID:     tt.storeID,
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "subject-id",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+			if err != nil {
+				t.Fatalf("Failed to sign token: %v", err)
+			}
+
+			// The client asks for a tenant and store of its choosing
+			req, _ := http.NewRequest(http.MethodGet, "/protected", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("X-Tenant-ID", "other-tenant")
+			req.Header.Set("x-store-id", "other-store")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", w.Code)
+			}
+			if got := forwarded.Values("X-Tenant-ID"); len(got) > 1 || forwarded.Get("X-Tenant-ID") != tt.tenantID {
+				t.Errorf("Expected X-Tenant-ID %q from the token, got %v", tt.tenantID, got)
+			}
+			if got := forwarded.Values("X-Store-ID"); len(got) > 1 || forwarded.Get("X-Store-ID") != tt.storeID {
+				t.Errorf("Expected X-Store-ID %q from the token, got %v", tt.storeID, got)
+			}
+		})
+	}
+}
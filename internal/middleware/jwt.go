@@ -1,58 +1,196 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
 )
 
-// JWTAuthMiddleware creates a JWT authentication middleware
+// JWTAuthMiddleware creates a JWT authentication middleware that only accepts user tokens
 func JWTAuthMiddleware(publicKeyPath string) gin.HandlerFunc {
+	return authenticate(publicKeyPath, false)
+}
+
+// GuestAuthMiddleware creates a JWT authentication middleware that accepts
+// user tokens and anonymous guest session tokens
+func GuestAuthMiddleware(publicKeyPath string) gin.HandlerFunc {
+	return authenticate(publicKeyPath, true)
+}
+
+// authenticate validates the bearer token and forwards the identity it carries
+func authenticate(publicKeyPath string, allowGuests bool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" {
+			tokenString = upgradeAuthorization(c.Request)
+		}
 		if tokenString == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
 			c.Abort()
 			return
 		}
 
-		// Remove "Bearer " prefix
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+		tokenString, isDPoP := splitAuthorization(tokenString)
+		claims, err := handlers.ParseToken(tokenString, publicKeyPath)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
 
-		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &handlers.Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
+		if err := verifyDPoP(c, claims, tokenString, isDPoP); err != nil {
+			abortInvalidDPoP(c)
+			return
+		}
 
-			// Load public key
-			return handlers.LoadRSAPublicKey(publicKeyPath)
-		})
+		// Device tokens only authenticate the kiosk itself, and guest
+		// tokens are only accepted on routes that allow guests
+		isGuest := claims.SubjectType == handlers.SubjectTypeGuest
+		if !claims.IsUser() && !(allowGuests && isGuest) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// Tokens outlive the access hours they were issued in
+		if claims.AccessUntil != nil && !time.Now().Before(claims.AccessUntil.Time) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "Outside allowed access hours",
+				"code":  handlers.ErrorCodeOutsideAccessHours,
+			})
 			c.Abort()
 			return
 		}
 
-		if claims, ok := token.Claims.(*handlers.Claims); ok {
-			// Add user info to headers for downstream services
+		// Add identity info to headers for downstream services
+		if isGuest {
+			c.Request.Header.Set("X-Subject-Type", handlers.SubjectTypeGuest)
+		} else {
+			c.Request.Header.Set("X-Subject-Type", handlers.SubjectTypeUser)
 			c.Request.Header.Set("X-User-ID", claims.Username)
 			c.Request.Header.Set("X-User-Email", claims.Email)
 			c.Request.Header.Set("X-User-Name", claims.Fullname)
+			c.Request.Header.Set("X-User-Role", claims.Role)
+		}
+		if claims.GuestID != "" {
+			c.Request.Header.Set("X-Guest-ID", claims.GuestID)
+		}
+		if claims.DeviceID != "" {
+			c.Request.Header.Set("X-Device-ID", claims.DeviceID)
+		}
+		if claims.TenantID != "" {
+			c.Request.Header.Set("X-Tenant-ID", claims.TenantID)
+		}
+		if claims.StoreID != "" {
+			c.Request.Header.Set("X-Store-ID", claims.StoreID)
+		}
+		c.Request.Header.Set("X-Scope", claims.Scope)
 
-			// Set in context for current request
-			c.Set("username", claims.Username)
-			c.Set("email", claims.Email)
-			c.Set("fullname", claims.Fullname)
-			c.Next()
+		// Set in context for current request
+		if isGuest {
+			c.Set("sub_type", handlers.SubjectTypeGuest)
 		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+			c.Set("sub_type", handlers.SubjectTypeUser)
+			c.Set("user_id", claims.Subject)
+		}
+		c.Set("username", claims.Username)
+		c.Set("email", claims.Email)
+		c.Set("fullname", claims.Fullname)
+		c.Set("role", claims.Role)
+		c.Set("guest_id", claims.GuestID)
+		c.Set("device_id", claims.DeviceID)
+		c.Set("tenant_id", claims.TenantID)
+		c.Set("store_id", claims.StoreID)
+		c.Set("scope", claims.Scope)
+		c.Next()
+	}
+}
+
+// DeviceAuthMiddleware creates a middleware that only accepts kiosk device tokens
+func DeviceAuthMiddleware(publicKeyPath string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString := c.GetHeader("Authorization")
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
 			c.Abort()
+			return
 		}
+
+		tokenString, isDPoP := splitAuthorization(tokenString)
+		claims, err := handlers.ParseToken(tokenString, publicKeyPath)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.Abort()
+			return
+		}
+
+		if err := verifyDPoP(c, claims, tokenString, isDPoP); err != nil {
+			abortInvalidDPoP(c)
+			return
+		}
+
+		if claims.SubjectType != handlers.SubjectTypeDevice || claims.Subject == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device token required"})
+			c.Abort()
+			return
+		}
+
+		c.Set("device_id", claims.Subject)
+		c.Set("store_id", claims.StoreID)
+		c.Next()
+	}
+}
+
+// RequireUser creates a middleware that rejects guest sessions on routes
+// that otherwise accept them. It must run after GuestAuthMiddleware.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("sub_type") != handlers.SubjectTypeUser {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Sign in required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireRole creates a middleware that only lets users with one of the given roles through.
+// It must run after JWTAuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// RequireScope creates a middleware that only lets tokens granted every one of
+// the given scopes through. It must run after JWTAuthMiddleware or GuestAuthMiddleware.
+func RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := strings.Fields(c.GetString("scope"))
+		for _, required := range scopes {
+			if !slices.Contains(granted, required) {
+				c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+				c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient scope"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
 	}
 }
@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+)
+
+// setupTestKeys generates an RSA key pair and writes the public key to a temp file
+func setupTestKeys(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+
+	publicKeyPath := filepath.Join(t.TempDir(), "publicKey.pem")
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	if err := os.WriteFile(publicKeyPath, publicKeyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+
+	return privateKey, publicKeyPath
+}
+
+// signTestToken signs a token with the given subject type
+func signTestToken(t *testing.T, privateKey *rsa.PrivateKey, subjectType string) string {
+	t.Helper()
+
+	claims := &handlers.Claims{
+		Username:    "existinguser",
+		SubjectType: subjectType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "subject-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func performRequest(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddleware_SubjectTypes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+
+	router := gin.New()
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/user-only", JWTAuthMiddleware(publicKeyPath), ok)
+	router.GET("/guest-allowed", GuestAuthMiddleware(publicKeyPath), ok)
+	router.PUT("/guest-allowed", GuestAuthMiddleware(publicKeyPath), RequireUser(), ok)
+	router.POST("/device-only", DeviceAuthMiddleware(publicKeyPath), ok)
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		subjectType string
+		want        int
+	}{
+		{"user on user route", "GET", "/user-only", handlers.SubjectTypeUser, http.StatusOK},
+		{"legacy token on user route", "GET", "/user-only", "", http.StatusOK},
+		{"guest on user route", "GET", "/user-only", handlers.SubjectTypeGuest, http.StatusUnauthorized},
+		{"device on user route", "GET", "/user-only", handlers.SubjectTypeDevice, http.StatusUnauthorized},
+		{"user on guest route", "GET", "/guest-allowed", handlers.SubjectTypeUser, http.StatusOK},
+		{"guest on guest route", "GET", "/guest-allowed", handlers.SubjectTypeGuest, http.StatusOK},
+		{"device on guest route", "GET", "/guest-allowed", handlers.SubjectTypeDevice, http.StatusUnauthorized},
+		{"guest on user-only action", "PUT", "/guest-allowed", handlers.SubjectTypeGuest, http.StatusForbidden},
+		{"user on user-only action", "PUT", "/guest-allowed", handlers.SubjectTypeUser, http.StatusOK},
+		{"device on device route", "POST", "/device-only", handlers.SubjectTypeDevice, http.StatusOK},
+		{"user on device route", "POST", "/device-only", handlers.SubjectTypeUser, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := performRequest(router, tt.method, tt.path, signTestToken(t, privateKey, tt.subjectType))
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_NoToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, publicKeyPath := setupTestKeys(t)
+
+	router := gin.New()
+	router.GET("/protected", JWTAuthMiddleware(publicKeyPath), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	if w := performRequest(router, "GET", "/protected", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestAuthMiddleware_AccessHours(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+
+	router := gin.New()
+	router.GET("/protected", JWTAuthMiddleware(publicKeyPath), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name        string
+		accessUntil *jwt.NumericDate
+		want        int
+	}{
+		{"not limited", nil, http.StatusOK},
+		{"within access hours", jwt.NewNumericDate(time.Now().Add(time.Hour)), http.StatusOK},
+		{"after access hours", jwt.NewNumericDate(time.Now().Add(-time.Minute)), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &handlers.Claims{
+				Username:    "existinguser",
+				SubjectType: handlers.SubjectTypeUser,
+				AccessUntil: tt.accessUntil,
+				RegisteredClaims: jwt.RegisteredClaims{
+					Subject:   "subject-id",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+				},
+			}
+			token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+			if err != nil {
+				t.Fatalf("Failed to sign token: %v", err)
+			}
+
+			w := performRequest(router, "GET", "/protected", token)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusForbidden && !strings.Contains(w.Body.String(), handlers.ErrorCodeOutsideAccessHours) {
+				t.Errorf("Expected the access hours error code, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name  string
+		scope string
+		want  int
+	}{
+		{"granted", "orders:read inventory:write", http.StatusOK},
+		{"missing", "orders:read", http.StatusForbidden},
+		{"no scope", "", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.PUT("/inventory/:id", func(c *gin.Context) {
+				c.Set("scope", tt.scope)
+			}, RequireScope("inventory:write"), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := performRequest(router, "PUT", "/inventory/1", "")
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusForbidden && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate challenge")
+			}
+		})
+	}
+}
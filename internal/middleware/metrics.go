@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsAuthMiddleware authenticates the metrics scraper with a static bearer
+// token. Every request is rejected when no token is configured.
+func MetricsAuthMiddleware(bearerToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, isBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if bearerToken == "" || !isBearer || subtle.ConstantTimeCompare([]byte(token), []byte(bearerToken)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing bearer token"})
+			return
+		}
+
+		c.Next()
+	}
+}
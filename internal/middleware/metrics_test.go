@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMetricsAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		configured     string
+		header         string
+		expectedStatus int
+	}{
+		{"valid token", "scrape-secret", "Bearer scrape-secret", http.StatusOK},
+		{"wrong token", "scrape-secret", "Bearer guessed", http.StatusUnauthorized},
+		{"missing token", "scrape-secret", "", http.StatusUnauthorized},
+		{"token without scheme", "scrape-secret", "scrape-secret", http.StatusUnauthorized},
+		{"not configured", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/metrics", MetricsAuthMiddleware(tt.configured), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -2,9 +2,13 @@ package middleware
 
 import (
 	"fmt"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/dpop"
 )
 
 // Logger returns a gin middleware for logging requests
@@ -14,7 +18,7 @@ func Logger() gin.HandlerFunc {
 			param.ClientIP,
 			param.TimeStamp.Format(time.RFC1123),
 			param.Method,
-			param.Path,
+			redactAccessToken(param.Path),
 			param.Request.Proto,
 			param.StatusCode,
 			param.Latency,
@@ -24,12 +28,26 @@ func Logger() gin.HandlerFunc {
 	})
 }
 
+// redactAccessToken hides an access token sent in the query of a WebSocket upgrade
+func redactAccessToken(path string) string {
+	route, rawQuery, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil || !query.Has(AccessTokenQueryParam) {
+		return path
+	}
+	query.Set(AccessTokenQueryParam, "REDACTED")
+	return route + "?" + query.Encode()
+}
+
 // CORS returns a gin middleware for handling CORS
 func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, DPoP, X-CSRF-Token, X-Session-Mode")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -40,6 +58,15 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// TrustedProxies lets DPoP proof checks take the request scheme from the
+// X-Forwarded-Proto header of the load balancers in front of the gateway
+func TrustedProxies(proxies []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(dpop.WithTrustedProxies(c.Request.Context(), proxies))
+		c.Next()
+	}
+}
+
 // RequestID adds a unique request ID to each request
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
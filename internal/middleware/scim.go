@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMAuthMiddleware authenticates the SCIM provisioning client with a static
+// bearer token. Every request is rejected when no token is configured.
+func SCIMAuthMiddleware(bearerToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, isBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if bearerToken == "" || !isBearer || subtle.ConstantTimeCompare([]byte(token), []byte(bearerToken)) != 1 {
+			c.Header("Content-Type", "application/scim+json; charset=utf-8")
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+				"status":  "401",
+				"detail":  "Invalid or missing bearer token",
+			})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSCIMAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		configured     string
+		header         string
+		expectedStatus int
+	}{
+		{"valid token", "provisioning-secret", "Bearer provisioning-secret", http.StatusOK},
+		{"wrong token", "provisioning-secret", "Bearer guessed", http.StatusUnauthorized},
+		{"missing token", "provisioning-secret", "", http.StatusUnauthorized},
+		{"token without scheme", "provisioning-secret", "provisioning-secret", http.StatusUnauthorized},
+		{"other scheme", "provisioning-secret", "Basic provisioning-secret", http.StatusUnauthorized},
+		{"not configured", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/scim/v2/Users", SCIMAuthMiddleware(tt.configured), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
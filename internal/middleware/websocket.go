@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/proxy"
+)
+
+// Browsers cannot set the Authorization header on a WebSocket upgrade request,
+// so upgrade requests may carry the access token in a query parameter or as a
+// subprotocol instead
+const (
+	AccessTokenQueryParam   = "access_token"
+	BearerSubprotocolPrefix = "bearer."
+	webSocketProtocolHeader = "Sec-WebSocket-Protocol"
+)
+
+// upgradeAuthorization takes the access token of a WebSocket upgrade request
+// from the access_token query parameter or a bearer.<token> subprotocol. The
+// token is moved to the Authorization header, so the service receives it the
+// same way as on other requests and does not see it in the URL or have to
+// negotiate the subprotocol. It returns the new Authorization header, or an
+// empty string when the request carries no token.
+func upgradeAuthorization(r *http.Request) string {
+	if !proxy.IsWebSocketUpgrade(r) {
+		return ""
+	}
+
+	query := r.URL.Query()
+	token := query.Get(AccessTokenQueryParam)
+	if token != "" {
+		query.Del(AccessTokenQueryParam)
+		r.URL.RawQuery = query.Encode()
+	} else {
+		var protocols []string
+		for _, value := range r.Header.Values(webSocketProtocolHeader) {
+			for _, protocol := range strings.Split(value, ",") {
+				protocol = strings.TrimSpace(protocol)
+				if bearer, ok := strings.CutPrefix(protocol, BearerSubprotocolPrefix); ok && token == "" {
+					token = bearer
+					continue
+				}
+				protocols = append(protocols, protocol)
+			}
+		}
+		if token == "" {
+			return ""
+		}
+
+		r.Header.Del(webSocketProtocolHeader)
+		if len(protocols) > 0 {
+			r.Header.Set(webSocketProtocolHeader, strings.Join(protocols, ", "))
+		}
+	}
+
+	authorization := "Bearer " + token
+	r.Header.Set("Authorization", authorization)
+	return authorization
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+)
+
+func TestAuthMiddleware_WebSocketToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	privateKey, publicKeyPath := setupTestKeys(t)
+	token := signTestToken(t, privateKey, handlers.SubjectTypeUser)
+
+	var forwarded *http.Request
+	router := gin.New()
+	router.GET("/ws", JWTAuthMiddleware(publicKeyPath), func(c *gin.Context) {
+		forwarded = c.Request
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name          string
+		query         string
+		protocols     string
+		upgrade       bool
+		want          int
+		wantQuery     string
+		wantProtocols string
+	}{
+		{"query parameter", "?access_token=" + token + "&store=1", "", true, http.StatusOK, "store=1", ""},
+		{"subprotocol", "", "orders.v1, " + BearerSubprotocolPrefix + token, true, http.StatusOK, "", "orders.v1"},
+		{"only the token subprotocol", "", BearerSubprotocolPrefix + token, true, http.StatusOK, "", ""},
+		{"query parameter without upgrade", "?access_token=" + token, "", false, http.StatusUnauthorized, "", ""},
+		{"no token", "", "orders.v1", true, http.StatusUnauthorized, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			forwarded = nil
+			req, _ := http.NewRequest(http.MethodGet, "/ws"+tt.query, nil)
+			if tt.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			if tt.protocols != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", tt.protocols)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+			if got := forwarded.Header.Get("Authorization"); got != "Bearer "+token {
+				t.Errorf("Expected the token in the Authorization header, got %q", got)
+			}
+			if forwarded.URL.RawQuery != tt.wantQuery {
+				t.Errorf("Expected query %q, got %q", tt.wantQuery, forwarded.URL.RawQuery)
+			}
+			if got := forwarded.Header.Get("Sec-WebSocket-Protocol"); got != tt.wantProtocols {
+				t.Errorf("Expected subprotocols %q, got %q", tt.wantProtocols, got)
+			}
+		})
+	}
+}
+
+func TestRedactAccessToken(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/orders/updates", "/api/v1/orders/updates"},
+		{"/api/v1/orders?status=open", "/api/v1/orders?status=open"},
+		{"/api/v1/orders/updates?access_token=secret", "/api/v1/orders/updates?access_token=REDACTED"},
+	}
+
+	for _, tt := range tests {
+		if got := redactAccessToken(tt.path); got != tt.want {
+			t.Errorf("Expected %q, got %q", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// ringReplicas is the number of points an endpoint of weight 1 has on a consistent hash ring
+const ringReplicas = 100
+
+// endpoint is an instance of a service
+type endpoint struct {
+	url    *url.URL
+	weight int
+
+	active  atomic.Int64 // requests in flight
+	healthy atomic.Bool
+
+	current int // smooth weighted round robin, guarded by the pool's mutex
+
+	// Health check results in a row, only used by the checking goroutine
+	passed int
+	failed int
+}
+
+// path returns the escaped and unescaped path of a request to the endpoint.
+// suffix is the escaped path below the endpoint's base path.
+func (e *endpoint) path(suffix string) (string, string) {
+	rawPath := strings.TrimSuffix(e.url.EscapedPath(), "/") + suffix
+	path, _ := url.PathUnescape(rawPath) // both parts are escaped by url
+	return path, rawPath
+}
+
+// release ends a request to the endpoint
+func (e *endpoint) release() {
+	e.active.Add(-1)
+}
+
+// ringPoint is a point of an endpoint on a consistent hash ring
+type ringPoint struct {
+	hash     uint32
+	endpoint *endpoint
+}
+
+// servicePool spreads the requests to a service across its healthy endpoints
+type servicePool struct {
+	name      string
+	policy    string
+	hashKey   string
+	endpoints []*endpoint
+	ring      []ringPoint // sorted by hash, for consistent_hash
+	check     config.HealthCheckConfig
+	client    *http.Client // for health checks
+
+	mu   sync.Mutex // guards the round robin weights
+	next atomic.Uint64
+}
+
+func newServicePool(name string, service config.ServiceConfig, transport http.RoundTripper) *servicePool {
+	endpoints := service.Endpoints
+	if len(endpoints) == 0 && service.BaseURL != "" {
+		endpoints = []config.EndpointConfig{{URL: service.BaseURL}}
+	}
+
+	pool := &servicePool{
+		name:    name,
+		policy:  service.LoadBalancing.Policy,
+		hashKey: service.LoadBalancing.HashKey,
+		check:   service.HealthCheck,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(service.HealthCheck.Timeout) * time.Second,
+		},
+	}
+	for _, cfg := range endpoints {
+		// Validated by config.Load
+		target, err := url.Parse(cfg.URL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			continue
+		}
+		e := &endpoint{url: target, weight: max(cfg.Weight, 1)}
+		e.healthy.Store(true)
+		pool.endpoints = append(pool.endpoints, e)
+
+		for i := range e.weight * ringReplicas {
+			pool.ring = append(pool.ring, ringPoint{hash: hashString(cfg.URL + "#" + strconv.Itoa(i)), endpoint: e})
+		}
+	}
+	slices.SortFunc(pool.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return pool
+}
+
+// pick chooses the endpoint for a request and counts the request against it.
+// exclude is an endpoint that just failed the request, which is avoided when
+// another one is healthy. It returns nil when no endpoint is healthy.
+func (p *servicePool) pick(c *gin.Context, exclude *endpoint) *endpoint {
+	candidates := make([]*endpoint, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		if e.healthy.Load() && e != exclude {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 && exclude != nil && exclude.healthy.Load() {
+		candidates = append(candidates, exclude)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	var chosen *endpoint
+	switch p.policy {
+	case config.BalanceLeastConnections:
+		chosen = p.leastConnections(candidates)
+	case config.BalanceConsistentHash:
+		if key := p.requestKey(c); key != "" {
+			chosen = p.consistentHash(key, candidates)
+		}
+	}
+	if chosen == nil {
+		chosen = p.roundRobin(candidates)
+	}
+
+	chosen.active.Add(1)
+	return chosen
+}
+
+// roundRobin takes the candidates in turn in proportion to their weights, with
+// the heavier ones spread out rather than in a row (smooth weighted round robin)
+func (p *servicePool) roundRobin(candidates []*endpoint) *endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *endpoint
+	total := 0
+	for _, e := range candidates {
+		e.current += e.weight
+		total += e.weight
+		if best == nil || e.current > best.current {
+			best = e
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// leastConnections takes the candidate with the fewest requests in flight for
+// its weight. Ties go to the candidates in turn.
+func (p *servicePool) leastConnections(candidates []*endpoint) *endpoint {
+	start := int(p.next.Add(1) % uint64(len(candidates)))
+	var best *endpoint
+	var bestActive int64
+	for i := range candidates {
+		e := candidates[(start+i)%len(candidates)]
+		active := e.active.Load()
+		if best == nil || active*int64(best.weight) < bestActive*int64(e.weight) {
+			best, bestActive = e, active
+		}
+	}
+	return best
+}
+
+// consistentHash takes the first candidate on the ring at or after the key's
+// hash, so a key keeps its endpoint while that endpoint stays healthy
+func (p *servicePool) consistentHash(key string, candidates []*endpoint) *endpoint {
+	hash := hashString(key)
+	start, _ := slices.BinarySearchFunc(p.ring, hash, func(point ringPoint, hash uint32) int {
+		return cmp.Compare(point.hash, hash)
+	})
+	for i := range p.ring {
+		e := p.ring[(start+i)%len(p.ring)].endpoint
+		if slices.Contains(candidates, e) {
+			return e
+		}
+	}
+	return nil
+}
+
+// requestKey returns the consistent hash key of a request, or "" without one
+func (p *servicePool) requestKey(c *gin.Context) string {
+	if p.hashKey == config.HashByStore {
+		return c.GetString("store_id")
+	}
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	if guestID := c.GetString("guest_id"); guestID != "" {
+		return "guest:" + guestID
+	}
+	return ""
+}
+
+// hashString hashes a key or ring point. FNV-1a alone spreads short, similar
+// keys such as numeric store IDs poorly, so its result is mixed further with
+// the murmur3 finalizer.
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	x := h.Sum32()
+	x ^= x >> 16
+	x *= 0x85ebca6b
+	x ^= x >> 13
+	x *= 0xc2b2ae35
+	x ^= x >> 16
+	return x
+}
+
+// runHealthChecks checks every endpoint each interval until ctx is done
+func (p *servicePool) runHealthChecks(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(p.check.Interval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+		for _, e := range p.endpoints {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ok := p.checkEndpoint(ctx, e)
+				if ctx.Err() == nil {
+					p.recordCheck(e, ok)
+				}
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkEndpoint reports whether the endpoint answers its health check with a 2xx status
+func (p *servicePool) checkEndpoint(ctx context.Context, e *endpoint) bool {
+	target := *e.url
+	target.Path, target.RawPath = e.path(p.check.Path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// recordCheck takes an endpoint out of rotation after enough failed checks in
+// a row, and puts it back after enough passed ones
+func (p *servicePool) recordCheck(e *endpoint, ok bool) {
+	if ok {
+		e.failed = 0
+		e.passed++
+		if !e.healthy.Load() && e.passed >= p.check.HealthyThreshold {
+			e.healthy.Store(true)
+			log.Printf("Endpoint %s of %s service is healthy again", e.url.Redacted(), p.name)
+		}
+		return
+	}
+
+	e.passed = 0
+	e.failed++
+	if e.healthy.Load() && e.failed >= p.check.UnhealthyThreshold {
+		e.healthy.Store(false)
+		log.Printf("Endpoint %s of %s service is unhealthy, taken out of rotation", e.url.Redacted(), p.name)
+	}
+}
+
+// StartHealthChecks starts checking the endpoints of the services with a health check path
+func (p *ProxyHandler) StartHealthChecks() {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopHealthChecks = cancel
+
+	for _, pool := range p.pools {
+		if pool.check.Path == "" || len(pool.endpoints) == 0 {
+			continue
+		}
+		p.healthChecks.Add(1)
+		go func() {
+			defer p.healthChecks.Done()
+			pool.runHealthChecks(ctx)
+		}()
+	}
+}
+
+// StopHealthChecks stops the health checks and waits for running ones until ctx is done
+func (p *ProxyHandler) StopHealthChecks(ctx context.Context) {
+	if p.stopHealthChecks == nil {
+		return
+	}
+	p.stopHealthChecks()
+
+	done := make(chan struct{})
+	go func() {
+		p.healthChecks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
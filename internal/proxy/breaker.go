@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// States of a circuit breaker
+const (
+	BreakerClosed   = "closed"    // requests pass
+	BreakerOpen     = "open"      // requests fail fast
+	BreakerHalfOpen = "half_open" // trial requests pass to test whether the service recovered
+)
+
+// callOutcome is how a proxied request went, as far as the breaker is concerned
+type callOutcome int
+
+const (
+	callSucceeded callOutcome = iota
+	callFailed
+	callIgnored // the client went away before the service answered
+)
+
+// circuitBreaker stops sending requests to a service that is failing or too
+// slow, so clients fail fast instead of waiting for the timeout
+type circuitBreaker struct {
+	cfg              config.CircuitBreakerConfig
+	slowCallDuration time.Duration
+	openDuration     time.Duration
+
+	mu       sync.Mutex
+	state    string
+	gen      int // changes with the state, so late outcomes are not counted against the new one
+	openedAt time.Time
+	counts   rollingWindow[breakerCounts]
+	trials   int // half-open trial requests let through
+	passed   int // half-open trial requests that succeeded
+	opened   int // times the breaker opened
+	rejected int // requests failed fast
+}
+
+// breakerCounts are the finished requests of one second
+type breakerCounts struct {
+	requests int
+	failures int
+	slow     int
+}
+
+func newCircuitBreaker(cfg config.CircuitBreakerConfig) *circuitBreaker {
+	return &circuitBreaker{
+		cfg:              cfg,
+		slowCallDuration: time.Duration(cfg.SlowCallDuration) * time.Millisecond,
+		openDuration:     time.Duration(cfg.OpenDuration) * time.Second,
+		state:            BreakerClosed,
+	}
+}
+
+// allow reports whether a request may be sent to the service, and the state
+// generation to record its outcome under. When it may not, it returns how long
+// until the breaker lets requests through again.
+func (b *circuitBreaker) allow(now time.Time) (bool, int, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		if wait := b.openedAt.Add(b.openDuration).Sub(now); wait > 0 {
+			b.rejected++
+			return false, b.gen, wait
+		}
+		b.setState(BreakerHalfOpen)
+		b.trials, b.passed = 0, 0
+	}
+
+	if b.state == BreakerHalfOpen {
+		if b.trials >= b.cfg.HalfOpenRequests {
+			// Wait for the outcome of the trial requests
+			b.rejected++
+			return false, b.gen, time.Second
+		}
+		b.trials++
+	}
+	return true, b.gen, 0
+}
+
+// record counts the outcome of a request let through by allow. elapsed is the
+// time until the response headers arrived.
+func (b *circuitBreaker) record(now time.Time, gen int, outcome callOutcome, elapsed time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if gen != b.gen {
+		return
+	}
+
+	slow := b.slowCallDuration > 0 && elapsed >= b.slowCallDuration
+	switch b.state {
+	case BreakerHalfOpen:
+		switch {
+		case outcome == callIgnored:
+			// Give the trial to another request
+			b.trials--
+		case outcome == callFailed || slow:
+			b.open(now)
+		default:
+			if b.passed++; b.passed >= b.cfg.HalfOpenRequests {
+				b.setState(BreakerClosed)
+				b.counts.reset()
+			}
+		}
+
+	case BreakerClosed:
+		if outcome == callIgnored {
+			return
+		}
+		counts := b.counts.current(now)
+		counts.requests++
+		if outcome == callFailed {
+			counts.failures++
+		}
+		if slow {
+			counts.slow++
+		}
+
+		requests, failures, slowCalls := 0, 0, 0
+		for _, counts := range b.counts.recent(now) {
+			requests += counts.requests
+			failures += counts.failures
+			slowCalls += counts.slow
+		}
+		if requests >= b.cfg.MinRequests &&
+			(failures*100 >= b.cfg.FailureRate*requests || slowCalls*100 >= b.cfg.SlowCallRate*requests) {
+			b.open(now)
+		}
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.setState(BreakerOpen)
+	b.openedAt = now
+	b.opened++
+	b.counts.reset()
+}
+
+func (b *circuitBreaker) setState(state string) {
+	b.state = state
+	b.gen++
+}
+
+// breakerStatus is a snapshot of a circuit breaker for readiness and metrics
+type breakerStatus struct {
+	state    string
+	opened   int
+	rejected int
+}
+
+func (b *circuitBreaker) status(now time.Time) breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == BreakerOpen && !now.Before(b.openedAt.Add(b.openDuration)) {
+		// The next request is let through as a trial
+		state = BreakerHalfOpen
+	}
+	return breakerStatus{state: state, opened: b.opened, rejected: b.rejected}
+}
+
+// breakerCall is a request let through by a circuit breaker. A nil call
+// belongs to a service without a breaker.
+type breakerCall struct {
+	breaker *circuitBreaker
+	gen     int
+	started time.Time
+	once    sync.Once
+}
+
+// start lets a request through the breaker, or returns false and how long until
+// it lets requests through again. A nil breaker lets every request through.
+func (b *circuitBreaker) start(now time.Time) (*breakerCall, time.Duration, bool) {
+	if b == nil {
+		return nil, 0, true
+	}
+	ok, gen, wait := b.allow(now)
+	if !ok {
+		return nil, wait, false
+	}
+	return &breakerCall{breaker: b, gen: gen, started: now}, 0, true
+}
+
+// done records the outcome of the request. Only the first outcome counts.
+func (c *breakerCall) done(outcome callOutcome) {
+	if c == nil {
+		return
+	}
+	c.once.Do(func() {
+		now := time.Now()
+		c.breaker.record(now, c.gen, outcome, now.Sub(c.started))
+	})
+}
@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// testBreaker opens after half of at least four requests fail or are slow
+var testBreaker = config.CircuitBreakerConfig{
+	FailureRate:      50,
+	SlowCallRate:     50,
+	SlowCallDuration: 1000,
+	MinRequests:      4,
+	OpenDuration:     30,
+	HalfOpenRequests: 2,
+}
+
+// call sends a request through the breaker and records the outcome
+func call(t *testing.T, b *circuitBreaker, now time.Time, outcome callOutcome, elapsed time.Duration) {
+	t.Helper()
+	ok, gen, _ := b.allow(now)
+	if !ok {
+		t.Fatalf("Expected the request let through in state %s", b.state)
+	}
+	b.record(now, gen, outcome, elapsed)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+
+	t.Run("opens on failure rate", func(t *testing.T) {
+		b := newCircuitBreaker(testBreaker)
+		call(t, b, now, callFailed, 0)
+		call(t, b, now, callFailed, 0)
+		call(t, b, now, callSucceeded, 0)
+		if b.state != BreakerClosed {
+			t.Fatalf("Expected the breaker closed below min requests, got %s", b.state)
+		}
+		call(t, b, now, callSucceeded, 0)
+		if b.state != BreakerOpen {
+			t.Fatalf("Expected the breaker open, got %s", b.state)
+		}
+
+		ok, _, wait := b.allow(now.Add(10 * time.Second))
+		if ok || wait != 20*time.Second {
+			t.Errorf("Expected the request rejected for another 20s, got %v %s", ok, wait)
+		}
+	})
+
+	t.Run("opens on slow calls", func(t *testing.T) {
+		b := newCircuitBreaker(testBreaker)
+		for i := range 4 {
+			elapsed := time.Duration(0)
+			if i%2 == 0 {
+				elapsed = 2 * time.Second
+			}
+			call(t, b, now, callSucceeded, elapsed)
+		}
+		if b.state != BreakerOpen {
+			t.Errorf("Expected the breaker open, got %s", b.state)
+		}
+	})
+
+	t.Run("ignores cancelled requests", func(t *testing.T) {
+		b := newCircuitBreaker(testBreaker)
+		for range 4 {
+			call(t, b, now, callIgnored, 0)
+		}
+		call(t, b, now, callFailed, 0)
+		if b.state != BreakerClosed {
+			t.Errorf("Expected the breaker closed, got %s", b.state)
+		}
+	})
+
+	t.Run("forgets old failures", func(t *testing.T) {
+		b := newCircuitBreaker(testBreaker)
+		call(t, b, now, callFailed, 0)
+		call(t, b, now, callFailed, 0)
+		later := now.Add(windowSeconds * time.Second)
+		call(t, b, later, callSucceeded, 0)
+		call(t, b, later, callSucceeded, 0)
+		if b.state != BreakerClosed {
+			t.Errorf("Expected the breaker closed, got %s", b.state)
+		}
+	})
+
+	open := func(t *testing.T) *circuitBreaker {
+		b := newCircuitBreaker(testBreaker)
+		for range 4 {
+			call(t, b, now, callFailed, 0)
+		}
+		return b
+	}
+	halfOpen := now.Add(30 * time.Second)
+
+	t.Run("closes after successful trials", func(t *testing.T) {
+		b := open(t)
+		call(t, b, halfOpen, callSucceeded, 0)
+		if b.state != BreakerHalfOpen {
+			t.Fatalf("Expected the breaker half-open, got %s", b.state)
+		}
+		call(t, b, halfOpen, callSucceeded, 0)
+		if b.state != BreakerClosed {
+			t.Errorf("Expected the breaker closed, got %s", b.state)
+		}
+	})
+
+	t.Run("limits trial requests", func(t *testing.T) {
+		b := open(t)
+		for range testBreaker.HalfOpenRequests {
+			if ok, _, _ := b.allow(halfOpen); !ok {
+				t.Fatal("Expected a trial request let through")
+			}
+		}
+		if ok, _, wait := b.allow(halfOpen); ok || wait <= 0 {
+			t.Errorf("Expected further requests rejected while the trials run, got %v %s", ok, wait)
+		}
+	})
+
+	t.Run("reopens on a failed trial", func(t *testing.T) {
+		b := open(t)
+		call(t, b, halfOpen, callFailed, 0)
+		if b.state != BreakerOpen || b.opened != 2 {
+			t.Errorf("Expected the breaker opened again, got %s after %d openings", b.state, b.opened)
+		}
+	})
+
+	t.Run("ignores outcomes from an earlier state", func(t *testing.T) {
+		b := newCircuitBreaker(testBreaker)
+		_, gen, _ := b.allow(now)
+		for range 4 {
+			call(t, b, now, callFailed, 0)
+		}
+		b.record(halfOpen, gen, callSucceeded, 0)
+		call(t, b, halfOpen, callSucceeded, 0)
+		if b.state != BreakerHalfOpen || b.passed != 1 {
+			t.Errorf("Expected only the trial counted, got %s with %d passed", b.state, b.passed)
+		}
+	})
+}
+
+func TestProxyToService_CircuitOpen(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"payment_service": {BaseURL: backend.URL, CircuitBreaker: testBreaker},
+	}, config.ProxyConfig{})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.GET("/api/payments", proxyHandler.ProxyToService("payment_service", "/payments", 0, nil))
+	router.GET("/metrics", proxyHandler.Metrics)
+
+	for range testBreaker.MinRequests {
+		req, _ := http.NewRequest(http.MethodGet, "/api/payments", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/payments", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Expected Retry-After 30, got %q", got)
+	}
+	if got := int(requests.Load()); got != testBreaker.MinRequests {
+		t.Errorf("Expected the open breaker to keep the request from the service, got %d requests", got)
+	}
+	if got := proxyHandler.CircuitStates()["payment_service"]; got != BreakerOpen {
+		t.Errorf("Expected the breaker reported open, got %q", got)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, "/metrics", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	for _, line := range []string{
+		`gateway_circuit_breaker_state{service="payment_service",state="open"} 1`,
+		`gateway_circuit_breaker_state{service="payment_service",state="closed"} 0`,
+		`gateway_circuit_breaker_opened_total{service="payment_service"} 1`,
+		`gateway_circuit_breaker_rejected_total{service="payment_service"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, w.Body.String())
+		}
+	}
+}
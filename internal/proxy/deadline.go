@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// States of an upstream deadline
+const (
+	deadlineArmed int32 = iota
+	deadlineStopped
+	deadlineExpired
+)
+
+// upstreamDeadline cancels an upstream request when the service does not start
+// responding within the timeout. Unlike a context deadline it can be stopped
+// once the response arrives, so the body can be streamed for as long as it takes.
+type upstreamDeadline struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	timer  *time.Timer // nil without a timeout
+	at     time.Time
+	state  atomic.Int32
+}
+
+// startDeadline derives the upstream request context from the client's, which
+// still cancels the upstream request when the client goes away. A timeout of 0
+// or less sets no deadline.
+func startDeadline(parent context.Context, timeout time.Duration) *upstreamDeadline {
+	ctx, cancel := context.WithCancelCause(parent)
+	d := &upstreamDeadline{ctx: ctx, cancel: cancel}
+	if timeout > 0 {
+		d.at = time.Now().Add(timeout)
+		d.timer = time.AfterFunc(timeout, func() {
+			if d.state.CompareAndSwap(deadlineArmed, deadlineExpired) {
+				cancel(errServiceTimeout)
+			}
+		})
+	}
+	return d
+}
+
+// remaining returns the time left before the deadline, and false when there is none
+func (d *upstreamDeadline) remaining() (time.Duration, bool) {
+	if d.timer == nil {
+		return 0, false
+	}
+	return max(time.Until(d.at), 0), true
+}
+
+// stop disarms the deadline and reports whether it had not passed yet
+func (d *upstreamDeadline) stop() bool {
+	if d.timer == nil {
+		return true
+	}
+	if d.state.CompareAndSwap(deadlineArmed, deadlineStopped) {
+		d.timer.Stop()
+	}
+	return d.state.Load() == deadlineStopped
+}
+
+// expired reports whether the deadline passed and cancelled the request
+func (d *upstreamDeadline) expired() bool {
+	return d.state.Load() == deadlineExpired
+}
+
+// close releases the context once the request is done
+func (d *upstreamDeadline) close() {
+	d.stop()
+	d.cancel(nil)
+}
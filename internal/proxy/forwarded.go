@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http/httputil"
+	"strings"
+)
+
+// setForwardedHeaders tells the service who the client is and what it asked
+// for, in X-Forwarded-For, X-Forwarded-Host, X-Forwarded-Proto, Forwarded and
+// X-Real-IP. The headers a trusted proxy in front of the gateway sent are
+// extended. From anyone else they are replaced, since clients could forge them.
+func (p *ProxyHandler) setForwardedHeaders(r *httputil.ProxyRequest, clientIP string) {
+	peer, _, err := net.SplitHostPort(r.In.RemoteAddr)
+	if err != nil {
+		peer = r.In.RemoteAddr
+	}
+
+	proto := "http"
+	if r.In.TLS != nil {
+		proto = "https"
+	}
+	originalHost, originalProto := r.In.Host, proto
+
+	var forwarded []string
+	if p.isTrustedProxy(peer) {
+		r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+		if host := r.In.Header.Get("X-Forwarded-Host"); host != "" {
+			originalHost = host
+		}
+		if proto := r.In.Header.Get("X-Forwarded-Proto"); proto != "" {
+			originalProto = proto
+		}
+		forwarded = r.In.Header.Values("Forwarded")
+	}
+
+	// Appends the peer to X-Forwarded-For
+	r.SetXForwarded()
+	r.Out.Header.Set("X-Forwarded-Host", originalHost)
+	r.Out.Header.Set("X-Forwarded-Proto", originalProto)
+
+	// Forwarded (RFC 7239) gets an element for this hop
+	forwarded = append(forwarded, fmt.Sprintf("for=%s;host=%s;proto=%s",
+		forwardedValue(forwardedNode(peer)), forwardedValue(r.In.Host), proto))
+	r.Out.Header.Set("Forwarded", strings.Join(forwarded, ", "))
+
+	r.Out.Header.Set("X-Real-IP", clientIP)
+}
+
+// isTrustedProxy reports whether the address belongs to a trusted proxy
+func (p *ProxyHandler) isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedNode formats an address as a Forwarded node, which puts IPv6
+// addresses in brackets
+func forwardedNode(addr string) string {
+	if strings.Contains(addr, ":") {
+		return "[" + addr + "]"
+	}
+	return addr
+}
+
+// forwardedValue quotes a Forwarded parameter value unless it is a plain token
+func forwardedValue(value string) string {
+	for _, r := range value {
+		if !isTokenChar(r) {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+		}
+	}
+	return value
+}
+
+// isTokenChar reports whether r may appear in an HTTP token (RFC 7230)
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/internaltoken"
+)
+
+// internalToken signs the verified identity of the request for the service,
+// or returns an empty string when internal tokens are disabled. Requests on
+// routes without authentication get a token without a subject, which still
+// proves they came through the gateway.
+func (p *ProxyHandler) internalToken(c *gin.Context, serviceName string) (string, error) {
+	if p.signer == nil {
+		return "", nil
+	}
+
+	claims := internaltoken.Claims{
+		SubjectType: c.GetString("sub_type"),
+		Username:    c.GetString("username"),
+		Email:       c.GetString("email"),
+		Fullname:    c.GetString("fullname"),
+		Role:        c.GetString("role"),
+		GuestID:     c.GetString("guest_id"),
+		DeviceID:    c.GetString("device_id"),
+		TenantID:    c.GetString("tenant_id"),
+		StoreID:     c.GetString("store_id"),
+		Scope:       c.GetString("scope"),
+		RequestID:   c.GetString("request_id"),
+	}
+	claims.Subject = c.GetString("user_id")
+	if claims.Subject == "" {
+		claims.Subject = claims.GuestID
+	}
+
+	return p.signer.Sign(claims, serviceName, time.Now())
+}
+
+// InternalKeys publishes the keys that verify internal tokens as a JSON Web Key Set
+func (p *ProxyHandler) InternalKeys(c *gin.Context) {
+	keySet := internaltoken.KeySet{Keys: []internaltoken.JSONWebKey{}}
+	if p.signer != nil {
+		keySet = p.signer.KeySet()
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keySet)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// breakerStates are the circuit breaker states in metric order
+var breakerStates = []string{BreakerClosed, BreakerHalfOpen, BreakerOpen}
+
+// CircuitStates returns the circuit breaker state of every service that has one
+func (p *ProxyHandler) CircuitStates() map[string]string {
+	now := time.Now()
+	states := make(map[string]string, len(p.breakers))
+	for name, breaker := range p.breakers {
+		states[name] = breaker.status(now).state
+	}
+	return states
+}
+
+// Metrics serves the proxy's circuit breaker and endpoint metrics in the
+// Prometheus text format
+func (p *ProxyHandler) Metrics(c *gin.Context) {
+	now := time.Now()
+	names := make([]string, 0, len(p.breakers))
+	for name := range p.breakers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	statuses := make(map[string]breakerStatus, len(names))
+	for _, name := range names {
+		statuses[name] = p.breakers[name].status(now)
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP gateway_circuit_breaker_state Whether the service's circuit breaker is in the state.\n")
+	b.WriteString("# TYPE gateway_circuit_breaker_state gauge\n")
+	for _, name := range names {
+		for _, state := range breakerStates {
+			value := 0
+			if statuses[name].state == state {
+				value = 1
+			}
+			fmt.Fprintf(&b, "gateway_circuit_breaker_state{service=%q,state=%q} %d\n", name, state, value)
+		}
+	}
+
+	b.WriteString("# HELP gateway_circuit_breaker_opened_total Times the service's circuit breaker opened.\n")
+	b.WriteString("# TYPE gateway_circuit_breaker_opened_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "gateway_circuit_breaker_opened_total{service=%q} %d\n", name, statuses[name].opened)
+	}
+
+	b.WriteString("# HELP gateway_circuit_breaker_rejected_total Requests failed fast by the service's circuit breaker.\n")
+	b.WriteString("# TYPE gateway_circuit_breaker_rejected_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "gateway_circuit_breaker_rejected_total{service=%q} %d\n", name, statuses[name].rejected)
+	}
+
+	poolNames := make([]string, 0, len(p.pools))
+	for name := range p.pools {
+		poolNames = append(poolNames, name)
+	}
+	slices.Sort(poolNames)
+
+	b.WriteString("# HELP gateway_upstream_healthy Whether the endpoint is in rotation.\n")
+	b.WriteString("# TYPE gateway_upstream_healthy gauge\n")
+	for _, name := range poolNames {
+		for _, e := range p.pools[name].endpoints {
+			healthy := 0
+			if e.healthy.Load() {
+				healthy = 1
+			}
+			fmt.Fprintf(&b, "gateway_upstream_healthy{service=%q,endpoint=%q} %d\n", name, e.url.Redacted(), healthy)
+		}
+	}
+
+	b.WriteString("# HELP gateway_upstream_active_requests Requests in flight to the endpoint.\n")
+	b.WriteString("# TYPE gateway_upstream_active_requests gauge\n")
+	for _, name := range poolNames {
+		for _, e := range p.pools[name].endpoints {
+			fmt.Fprintf(&b, "gateway_upstream_active_requests{service=%q,endpoint=%q} %d\n", name, e.url.Redacted(), e.active.Load())
+		}
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
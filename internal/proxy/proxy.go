@@ -1,115 +1,351 @@
 package proxy
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/internaltoken"
 )
 
-// ProxyHandler handles proxying requests to downstream services
+// TimeoutHeader tells the service how many milliseconds are left before the
+// gateway stops waiting for its response
+const TimeoutHeader = "X-Request-Timeout"
+
+// errServiceTimeout cancels an upstream request that got no response in time
+var errServiceTimeout = errors.New("service did not respond in time")
+
+// ProxyHandler handles proxying requests to downstream services. Request and
+// response bodies are streamed rather than buffered, so uploads, CSV exports
+// and server-sent events pass through as they are produced. WebSocket upgrades
+// are tunnelled to the service in both directions.
 type ProxyHandler struct {
-	client   *http.Client
-	services *config.ServicesConfig
+	transport      http.RoundTripper
+	services       config.ServicesConfig
+	trustedProxies []*net.IPNet
+	signer         *internaltoken.Signer // nil when internal tokens are disabled
+	pools          map[string]*servicePool
+	retryBudgets   map[string]*retryBudget
+	breakers       map[string]*circuitBreaker
+
+	stopHealthChecks context.CancelFunc
+	healthChecks     sync.WaitGroup
+
+	idleTimeout    time.Duration // of WebSocket connections
+	maxConnections int           // WebSocket connections per user
+
+	mu           sync.Mutex
+	connections  map[string]int // open WebSocket connections by owner
+	tunnels      map[*tunnelConn]struct{}
+	shuttingDown bool
 }
 
 // NewProxyHandler creates a new proxy handler
-func NewProxyHandler(services *config.ServicesConfig) *ProxyHandler {
-	return &ProxyHandler{
-		client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		services: services,
+func NewProxyHandler(services config.ServicesConfig, cfg config.ProxyConfig) (*ProxyHandler, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// Validated by config.Load
+	trustedProxies, _ := config.ParseTrustedProxies(cfg.TrustedProxies)
+
+	var signer *internaltoken.Signer
+	if cfg.InternalToken.Enabled {
+		var err error
+		if signer, err = internaltoken.NewSigner(cfg.InternalToken); err != nil {
+			return nil, err
+		}
 	}
+
+	pools := make(map[string]*servicePool, len(services))
+	retryBudgets := make(map[string]*retryBudget, len(services))
+	breakers := make(map[string]*circuitBreaker, len(services))
+	for name, service := range services {
+		pools[name] = newServicePool(name, service, transport)
+		retryBudgets[name] = newRetryBudget(service.Retry.Budget)
+		// Services configured through config.Load always have a breaker
+		if service.CircuitBreaker.MinRequests > 0 {
+			breakers[name] = newCircuitBreaker(service.CircuitBreaker)
+		}
+	}
+
+	return &ProxyHandler{
+		transport:      transport,
+		services:       services,
+		trustedProxies: trustedProxies,
+		signer:         signer,
+		pools:          pools,
+		retryBudgets:   retryBudgets,
+		breakers:       breakers,
+		idleTimeout:    time.Duration(cfg.WebSocket.IdleTimeout) * time.Second,
+		maxConnections: cfg.WebSocket.MaxConnectionsPerUser,
+		connections:    make(map[string]int),
+		tunnels:        make(map[*tunnelConn]struct{}),
+	}, nil
 }
 
-// ProxyToService forwards a request to the specified service
-func (p *ProxyHandler) ProxyToService(serviceName string) gin.HandlerFunc {
+// ProxyToService forwards a request to the specified service. upstreamPath is
+// the path on the service, with :name and *name filled in from the route's path
+// parameters, so a route /api/v1/orders/:id with upstream path /orders/:id
+// forwards /api/v1/orders/42 to /orders/42. The service has timeout to start
+// responding, or its own configured timeout when timeout is 0. retry overrides
+// fields of the service's retry policy and may be nil.
+func (p *ProxyHandler) ProxyToService(serviceName, upstreamPath string, timeout time.Duration, retry *config.RetryConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var baseURL string
-
-		// Get the base URL for the service
-		switch serviceName {
-		case "auth":
-			baseURL = p.services.AuthService.BaseURL
-		case "order":
-			baseURL = p.services.OrderService.BaseURL
-		case "inventory":
-			baseURL = p.services.InventoryService.BaseURL
-		case "payment":
-			baseURL = p.services.PaymentService.BaseURL
-		default:
+		service, ok := p.services[serviceName]
+		if !ok {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
 			return
 		}
+		pool := p.pools[serviceName]
 
-		// Build the target URL
-		targetURL := baseURL + c.Request.URL.Path
-		if c.Request.URL.RawQuery != "" {
-			targetURL += "?" + c.Request.URL.RawQuery
+		// Build the path below the endpoint's base path, keeping escaped
+		// characters in parameters
+		suffix, err := expandPath(upstreamPath, c.Params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request path"})
+			return
 		}
-
-		// Read the request body
-		var bodyBytes []byte
-		var err error
-		if c.Request.Body != nil {
-			bodyBytes, err = io.ReadAll(c.Request.Body)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read request body"})
-				return
-			}
+		if _, err := url.PathUnescape(suffix); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request path"})
+			return
 		}
 
-		// Create the proxy request
-		req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewReader(bodyBytes))
+		internalToken, err := p.internalToken(c, serviceName)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy request"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign internal token"})
 			return
 		}
 
-		// Copy headers from original request
-		for key, values := range c.Request.Header {
-			for _, value := range values {
-				req.Header.Add(key, value)
+		// A WebSocket connection stays open for as long as the tunnel does
+		if IsWebSocketUpgrade(c.Request) {
+			release, err := p.acquireConnection(connectionOwner(c))
+			if err != nil {
+				p.abortWebSocketUpgrade(c, err)
+				return
 			}
+			defer release()
 		}
 
-		// Add request ID for tracing
-		if requestID, exists := c.Get("request_id"); exists {
-			req.Header.Set("X-Request-ID", requestID.(string))
+		// The handler is shared by concurrent requests, so the route's timeout
+		// is left as it is
+		effective := timeout
+		if effective == 0 {
+			effective = time.Duration(service.Timeout) * time.Second
 		}
+		deadline := startDeadline(c.Request.Context(), effective)
+		defer deadline.close()
 
-		// Make the request to the downstream service
-		resp, err := p.client.Do(req)
-		if err != nil {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error":   "Service unavailable",
-				"service": serviceName,
-				"message": fmt.Sprintf("Failed to connect to %s service", serviceName),
-			})
+		// Spread the requests across the healthy endpoints of the service
+		target := pool.pick(c, nil)
+		if target == nil {
+			serviceUnavailable(c, serviceName)
 			return
 		}
-		defer resp.Body.Close()
+		defer func() { target.release() }()
 
-		// Copy response headers
-		for key, values := range resp.Header {
-			for _, value := range values {
-				c.Header(key, value)
+		// Retried requests keep their body in memory so it can be sent again
+		budget := p.retryBudgets[serviceName]
+		budget.recordRequest(time.Now())
+		transport := p.transport
+		if policy := service.Retry.Override(retry); retryable(c.Request, policy) {
+			if err := bufferBody(c.Request); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+				return
+			}
+			transport = &retryTransport{
+				base:        p.transport,
+				policy:      policy,
+				budget:      budget,
+				deadline:    deadline,
+				serviceName: serviceName,
+				// Send the retry to another endpoint when there is a healthy one
+				reroute: func(req *http.Request) {
+					next := pool.pick(c, target)
+					if next == nil {
+						return
+					}
+					target.release()
+					target = next
+					req.URL.Scheme, req.URL.Host = target.url.Scheme, target.url.Host
+					req.URL.Path, req.URL.RawPath = target.path(suffix)
+					req.Host = ""
+				},
 			}
 		}
 
-		// Copy response body
-		respBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read response body"})
+		// A service that keeps failing is not sent requests for a while
+		call, wait, ok := p.breakers[serviceName].start(time.Now())
+		if !ok {
+			circuitOpen(c, serviceName, wait)
 			return
 		}
+		defer call.done(callIgnored)
 
-		// Return the response
-		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
+		reverseProxy := &httputil.ReverseProxy{
+			Transport: transport,
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.SetURL(target.url)
+				r.Out.URL.Path, r.Out.URL.RawPath = target.path(suffix)
+				r.Out.URL.RawQuery = r.In.URL.RawQuery
+				p.setForwardedHeaders(r, c.ClientIP())
+				filterHeaders(r.Out.Header, service)
+				if internalToken != "" {
+					r.Out.Header.Set(internaltoken.Header, internalToken)
+				}
+				if remaining, ok := deadline.remaining(); ok {
+					r.Out.Header.Set(TimeoutHeader, strconv.FormatInt(remaining.Milliseconds(), 10))
+				}
+
+				// Add request ID for tracing
+				if requestID := c.GetString("request_id"); requestID != "" {
+					r.Out.Header.Set("X-Request-ID", requestID)
+				}
+			},
+			// Write every chunk through as soon as it arrives, so streamed
+			// responses such as server-sent events are not held back
+			FlushInterval: -1,
+			// The timeout covers the wait for the response headers. Streamed
+			// bodies and WebSocket tunnels may stay open after that.
+			ModifyResponse: func(resp *http.Response) error {
+				if !deadline.stop() {
+					return errServiceTimeout
+				}
+				if resp.StatusCode >= http.StatusInternalServerError {
+					call.done(callFailed)
+				} else {
+					call.done(callSucceeded)
+				}
+				return nil
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				if deadline.expired() || errors.Is(err, errServiceTimeout) {
+					call.done(callFailed)
+					if gin.Mode() == "debug" {
+						log.Printf("Proxy to %s service timed out after %s", serviceName, effective)
+					}
+					gatewayTimeout(c, serviceName, effective)
+					return
+				}
+
+				// The client went away and cancelled the upstream request, so
+				// there is no one left to answer
+				if errors.Is(err, context.Canceled) {
+					call.done(callIgnored)
+					c.Abort()
+					return
+				}
+
+				call.done(callFailed)
+				if gin.Mode() == "debug" {
+					log.Printf("Proxy to %s service failed: %v", serviceName, err)
+				}
+				serviceUnavailable(c, serviceName)
+			},
+		}
+
+		// The request context is cancelled when the client disconnects, which
+		// aborts the upstream request as well
+		reverseProxy.ServeHTTP(responseWriter{ResponseWriter: c.Writer, proxy: p}, c.Request.WithContext(deadline.ctx))
+	}
+}
+
+// responseWriter hides the deprecated CloseNotify method of gin's writer, which
+// panics when the underlying writer lacks it. Disconnects are observed through
+// the request context instead. Flush is reached through Unwrap.
+type responseWriter struct {
+	http.ResponseWriter
+	proxy *ProxyHandler
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// filterHeaders removes the identity headers the service does not receive, and
+// the headers it reserves for itself
+func filterHeaders(header http.Header, service config.ServiceConfig) {
+	if len(service.IdentityHeaders) > 0 {
+		for _, name := range config.IdentityHeaders {
+			if !slices.ContainsFunc(service.IdentityHeaders, func(allowed string) bool {
+				return strings.EqualFold(allowed, name)
+			}) {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range service.ReservedHeaders {
+		header.Del(name)
+	}
+}
+
+// serviceUnavailable responds that the service could not be reached
+func serviceUnavailable(c *gin.Context, serviceName string) {
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":   "Service unavailable",
+		"service": serviceName,
+		"message": fmt.Sprintf("Failed to connect to %s service", serviceName),
+	})
+}
+
+// circuitOpen responds that the service's circuit breaker is open, and when to try again
+func circuitOpen(c *gin.Context, serviceName string, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":   "Service unavailable",
+		"service": serviceName,
+		"message": fmt.Sprintf("%s service is failing, try again later", serviceName),
+	})
+}
+
+// gatewayTimeout responds that the service did not respond in time
+func gatewayTimeout(c *gin.Context, serviceName string, timeout time.Duration) {
+	c.AbortWithStatusJSON(http.StatusGatewayTimeout, gin.H{
+		"error":   "Gateway timeout",
+		"service": serviceName,
+		"message": fmt.Sprintf("%s service did not respond within %s", serviceName, timeout),
+	})
+}
+
+// errDotSegment is returned for path parameters that would move the upstream
+// path up or across a level, which the service would resolve to a path the
+// route table does not expose
+var errDotSegment = errors.New("path parameter is a dot segment")
+
+// expandPath fills the :name and *name parameters of a path template in from
+// the request's path parameters. The values are escaped. Values with a "." or
+// ".." segment are rejected, since escaping leaves them as they are.
+func expandPath(template string, params gin.Params) (string, error) {
+	segments := strings.Split(template, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+		value := params.ByName(segment[1:])
+		parts := []string{value}
+		if segment[0] == '*' {
+			// Catch-all values span segments and start with the slash before them
+			parts = strings.Split(strings.TrimPrefix(value, "/"), "/")
+		}
+		for j, part := range parts {
+			if part == "." || part == ".." {
+				return "", errDotSegment
+			}
+			parts[j] = url.PathEscape(part)
+		}
+		segments[i] = strings.Join(parts, "/")
 	}
+	return strings.Join(segments, "/"), nil
 }
@@ -0,0 +1,609 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/internaltoken"
+)
+
+// setupTestProxy serves the upstream's routes through the proxy at /api/*path
+func setupTestProxy(t *testing.T, cfg config.ProxyConfig, upstream http.Handler) *httptest.Server {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	backend := httptest.NewServer(upstream)
+	t.Cleanup(backend.Close)
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"order_service": {BaseURL: backend.URL},
+	}, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.SetTrustedProxies(cfg.TrustedProxies)
+	router.Any("/api/*path", proxyHandler.ProxyToService("order_service", "/*path", 0, nil))
+
+	gateway := httptest.NewServer(router)
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+func TestExpandPath(t *testing.T) {
+	params := gin.Params{
+		{Key: "id", Value: "42"},
+		{Key: "name", Value: "a b/c"},
+		{Key: "path", Value: "/seasonal/redeem"},
+		{Key: "file", Value: "/reports/may 2026.csv"},
+		{Key: "parent", Value: ".."},
+		{Key: "current", Value: "."},
+		{Key: "up", Value: "/seasonal/../../admin"},
+		{Key: "here", Value: "/./redeem"},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"/orders/", "/orders/", false},
+		{"/orders/:id", "/orders/42", false},
+		{"/v2/orders/:id/items", "/v2/orders/42/items", false},
+		{"/items/:name", "/items/a%20b%2Fc", false},
+		{"/rewards/*path", "/rewards/seasonal/redeem", false},
+		{"/exports/*file", "/exports/reports/may%202026.csv", false},
+		{"/orders/:missing", "/orders/", false},
+		{"/payments/:parent/refund", "", true},
+		{"/payments/:current/refund", "", true},
+		{"/rewards/*up", "", true},
+		{"/rewards/*here", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := expandPath(tt.template, params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestProxyToService_RejectsDotSegments(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var requests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer backend.Close()
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"payment_service": {BaseURL: backend.URL + "/v1"},
+	}, config.ProxyConfig{})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.POST("/api/v1/payments/:id/refund", proxyHandler.ProxyToService("payment_service", "/payments/:id/refund", 0, nil))
+	router.GET("/api/v1/rewards/*path", proxyHandler.ProxyToService("payment_service", "/rewards/*path", 0, nil))
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/v1/payments/../refund"},
+		{http.MethodPost, "/api/v1/payments/./refund"},
+		{http.MethodGet, "/api/v1/rewards/../../admin/users"},
+		{http.MethodGet, "/api/v1/rewards/seasonal/%2e%2e/redeem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("Expected no request to reach the service, got %d", got)
+	}
+}
+
+func TestProxyToService_StreamsResponse(t *testing.T) {
+	release := make(chan struct{})
+	gateway := setupTestProxy(t, config.ProxyConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+
+		// Hold the rest back until the client has seen the first event
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+
+	resp, err := http.Get(gateway.URL + "/api/events")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	reader := bufio.NewReader(resp.Body)
+	received := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		received <- line
+	}()
+
+	select {
+	case line := <-received:
+		if line != "data: first\n" {
+			t.Errorf("Expected the first event, got %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("First event was not flushed before the upstream finished")
+	}
+	close(release)
+
+	rest, _ := io.ReadAll(reader)
+	if !strings.Contains(string(rest), "data: second") {
+		t.Errorf("Expected the second event, got %q", rest)
+	}
+}
+
+func TestProxyToService_StreamsRequestBody(t *testing.T) {
+	gateway := setupTestProxy(t, config.ProxyConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Upstream-Path", r.URL.EscapedPath())
+		w.Write(body)
+	}))
+
+	// A pipe has no known length, so the request is sent chunked
+	bodyReader, bodyWriter := io.Pipe()
+	go func() {
+		for i := 0; i < 3; i++ {
+			io.WriteString(bodyWriter, "sku,qty\n")
+		}
+		bodyWriter.Close()
+	}()
+
+	req, _ := http.NewRequest(http.MethodPost, gateway.URL+"/api/imports/stock%20take.csv", bodyReader)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != strings.Repeat("sku,qty\n", 3) {
+		t.Errorf("Expected the request body echoed, got %q", body)
+	}
+	if got := resp.Header.Get("X-Upstream-Path"); got != "/imports/stock%20take.csv" {
+		t.Errorf("Expected upstream path /imports/stock%%20take.csv, got %s", got)
+	}
+}
+
+func TestProxyToService_ClientDisconnectCancelsUpstream(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	gateway := setupTestProxy(t, config.ProxyConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, gateway.URL+"/api/slow", nil)
+	go func() {
+		<-started
+		cancel()
+	}()
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upstream request was not cancelled after the client disconnected")
+	}
+}
+
+func TestProxyToService_Unavailable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"order_service": {BaseURL: "http://127.0.0.1:1"},
+	}, config.ProxyConfig{})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.GET("/api/orders", proxyHandler.ProxyToService("order_service", "/orders", 0, nil))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "Service unavailable") {
+		t.Errorf("Expected a service unavailable error, got %s", w.Body.String())
+	}
+}
+
+// setupTimeoutProxy serves the upstream at /api/orders through a route with the given timeout.
+// The service's own timeout is 30 seconds.
+func setupTimeoutProxy(t *testing.T, timeout time.Duration, upstream http.Handler) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	backend := httptest.NewServer(upstream)
+	t.Cleanup(backend.Close)
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"order_service": {BaseURL: backend.URL, Timeout: 30},
+	}, config.ProxyConfig{})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.GET("/api/orders", proxyHandler.ProxyToService("order_service", "/orders", timeout, nil))
+	return router
+}
+
+func TestProxyToService_Timeout(t *testing.T) {
+	cancelled := make(chan struct{})
+	router := setupTimeoutProxy(t, 100*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status 504, got %d", w.Code)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body["error"] != "Gateway timeout" || body["service"] != "order_service" {
+		t.Errorf("Expected a gateway timeout error for order_service, got %v", body)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the upstream request to be cancelled")
+	}
+}
+
+func TestProxyToService_TimeoutHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		min     int64
+		max     int64
+	}{
+		{"route timeout", 2 * time.Second, 1000, 2000},
+		{"service timeout", 0, 29000, 30000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header string
+			router := setupTimeoutProxy(t, tt.timeout, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header.Get(TimeoutHeader)
+			}))
+
+			req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+			req.Header.Set(TimeoutHeader, "999999")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			remaining, err := strconv.ParseInt(header, 10, 64)
+			if err != nil || remaining < tt.min || remaining > tt.max {
+				t.Errorf("Expected %s between %d and %d, got %q", TimeoutHeader, tt.min, tt.max, header)
+			}
+		})
+	}
+}
+
+func TestProxyToService_TimeoutEndsWithHeaders(t *testing.T) {
+	router := setupTimeoutProxy(t, 100*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(300 * time.Millisecond)
+		io.WriteString(w, "done")
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("Expected the slow body streamed in full, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestProxyToService_ConcurrentRequests(t *testing.T) {
+	// Routes without a timeout of their own use the service's, for every request at once
+	router := setupTimeoutProxy(t, 0, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(TimeoutHeader))
+	}))
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			remaining, err := strconv.ParseInt(w.Body.String(), 10, 64)
+			if w.Code != http.StatusOK || err != nil || remaining < 29000 {
+				t.Errorf("Expected the service timeout, got %d %q", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestProxyToService_ForwardingHeaders(t *testing.T) {
+	echoHeaders := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(r.Header)
+	})
+
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		want           map[string]string
+	}{
+		{
+			name: "untrusted peer",
+			want: map[string]string{
+				"X-Forwarded-For":   "127.0.0.1",
+				"X-Forwarded-Host":  "gateway.test",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         `for=127.0.0.1;host=gateway.test;proto=http`,
+				"X-Real-Ip":         "127.0.0.1",
+			},
+		},
+		{
+			name:           "trusted load balancer",
+			trustedProxies: []string{"127.0.0.0/8"},
+			want: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7, 127.0.0.1",
+				"X-Forwarded-Host":  "shop.example",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         `for=203.0.113.7;proto=https, for=127.0.0.1;host=gateway.test;proto=http`,
+				"X-Real-Ip":         "203.0.113.7",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := setupTestProxy(t, config.ProxyConfig{TrustedProxies: tt.trustedProxies}, echoHeaders)
+
+			req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/orders", nil)
+			req.Host = "gateway.test"
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			req.Header.Set("X-Forwarded-Host", "shop.example")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("Forwarded", "for=203.0.113.7;proto=https")
+			req.Header.Set("X-Real-IP", "198.51.100.1")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			defer resp.Body.Close()
+
+			var headers http.Header
+			if err := json.NewDecoder(resp.Body).Decode(&headers); err != nil {
+				t.Fatalf("Failed to decode headers: %v", err)
+			}
+			for name, want := range tt.want {
+				if got := strings.Join(headers[name], ", "); got != want {
+					t.Errorf("Expected %s %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyToService_HopByHopHeaders(t *testing.T) {
+	var received http.Header
+	gateway := setupTestProxy(t, config.ProxyConfig{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Order-Count", "3")
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/api/orders", nil)
+	req.Header.Set("Connection", "X-Client-Hop")
+	req.Header.Set("X-Client-Hop", "1")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("Proxy-Authorization", "Basic c2VjcmV0")
+	req.Header.Set("X-Order-Source", "kiosk")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	for _, name := range []string{"X-Client-Hop", "Keep-Alive", "Proxy-Authorization"} {
+		if received.Get(name) != "" {
+			t.Errorf("Expected %s to be removed from the request", name)
+		}
+	}
+	if received.Get("X-Order-Source") != "kiosk" {
+		t.Error("Expected end-to-end request headers to be forwarded")
+	}
+
+	for _, name := range []string{"X-Upstream-Hop", "Keep-Alive"} {
+		if resp.Header.Get(name) != "" {
+			t.Errorf("Expected %s to be removed from the response", name)
+		}
+	}
+	if resp.Header.Get("X-Order-Count") != "3" {
+		t.Error("Expected end-to-end response headers to be forwarded")
+	}
+}
+
+func TestFilterHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		service config.ServiceConfig
+		want    []string
+	}{
+		{"all identity headers", config.ServiceConfig{}, []string{"X-User-Id", "X-User-Email", "X-Tenant-Id", "X-Payment-Signature", "X-Order-Source"}},
+		{"some identity headers", config.ServiceConfig{IdentityHeaders: []string{"x-user-id", "X-Tenant-ID"}}, []string{"X-User-Id", "X-Tenant-Id", "X-Payment-Signature", "X-Order-Source"}},
+		{"reserved header", config.ServiceConfig{ReservedHeaders: []string{"X-Payment-Signature"}}, []string{"X-User-Id", "X-User-Email", "X-Tenant-Id", "X-Order-Source"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, name := range []string{"X-User-ID", "X-User-Email", "X-Tenant-ID", "X-Payment-Signature", "X-Order-Source"} {
+				header.Set(name, "value")
+			}
+
+			filterHeaders(header, tt.service)
+
+			if len(header) != len(tt.want) {
+				t.Errorf("Expected headers %v, got %v", tt.want, header)
+			}
+			for _, name := range tt.want {
+				if header.Get(name) == "" {
+					t.Errorf("Expected %s to be kept", name)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyToService_InternalToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "internalPrivateKey.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	var received string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get(internaltoken.Header)
+	}))
+	defer backend.Close()
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"order_service": {BaseURL: backend.URL, IdentityHeaders: []string{"X-User-ID"}},
+	}, config.ProxyConfig{InternalToken: config.InternalTokenConfig{
+		Enabled:        true,
+		PrivateKeyPath: keyPath,
+		Issuer:         "mini-kiosk-central-gateway",
+		TTL:            60,
+	}})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+
+	router := gin.New()
+	router.GET("/.well-known/internal-jwks.json", proxyHandler.InternalKeys)
+	router.GET("/api/orders", func(c *gin.Context) {
+		c.Set("sub_type", "user")
+		c.Set("user_id", "subject-id")
+		c.Set("username", "existinguser")
+		c.Set("tenant_id", "tenant-id")
+		c.Set("scope", "orders:read")
+	}, proxyHandler.ProxyToService("order_service", "/orders", 0, nil))
+
+	req, _ := http.NewRequest(http.MethodGet, "/api/orders", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	if received == "" {
+		t.Fatal("Expected an internal token on the proxied request")
+	}
+
+	// Verify it the way a service would, with the published keys
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/internal-jwks.json", nil))
+	var keySet internaltoken.KeySet
+	if err := json.Unmarshal(w.Body.Bytes(), &keySet); err != nil || len(keySet.Keys) != 1 {
+		t.Fatalf("Expected one published key, got %s", w.Body.String())
+	}
+
+	claims := &internaltoken.Claims{}
+	_, err = jwt.ParseWithClaims(received, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != keySet.Keys[0].Kid {
+			return nil, jwt.ErrTokenUnverifiable
+		}
+		return &key.PublicKey, nil
+	}, jwt.WithAudience("order_service"), jwt.WithIssuer("mini-kiosk-central-gateway"))
+	if err != nil {
+		t.Fatalf("Failed to verify internal token: %v", err)
+	}
+	if claims.Subject != "subject-id" || claims.Username != "existinguser" || claims.TenantID != "tenant-id" || claims.Scope != "orders:read" {
+		t.Errorf("Expected the verified identity in the token, got %+v", claims)
+	}
+}
+
+func TestInternalKeys_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{}, config.ProxyConfig{})
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.GET("/.well-known/internal-jwks.json", proxyHandler.InternalKeys)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/.well-known/internal-jwks.json", nil))
+	if w.Code != http.StatusOK || w.Body.String() != `{"keys":[]}` {
+		t.Errorf("Expected an empty key set, got %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// IdempotencyKeyHeader marks a request the service deduplicates, which makes it
+// safe to retry whatever its method
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxRetryBodySize is the largest request body kept in memory so it can be sent
+// again. Requests with larger or chunked bodies are streamed and not retried.
+const maxRetryBodySize = 1 << 20
+
+// retryable reports whether a request may be retried under the policy
+func retryable(r *http.Request, policy config.RetryConfig) bool {
+	if policy.MaxAttempts < 2 || IsWebSocketUpgrade(r) {
+		return false
+	}
+	if r.Header.Get(IdempotencyKeyHeader) == "" && !slices.Contains(policy.Methods, r.Method) {
+		return false
+	}
+	return r.ContentLength >= 0 && r.ContentLength <= maxRetryBodySize
+}
+
+// bufferBody reads the request body into memory so every attempt can send it
+func bufferBody(r *http.Request) error {
+	if r.ContentLength == 0 {
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// retryTransport sends a request again when the service fails in one of the
+// ways the policy retries on, as long as the service's retry budget and the
+// request's deadline leave room for it
+type retryTransport struct {
+	base        http.RoundTripper
+	policy      config.RetryConfig
+	budget      *retryBudget
+	deadline    *upstreamDeadline
+	serviceName string
+	reroute     func(*http.Request) // points a retry at another endpoint, may be nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.policy.MaxAttempts || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := backoff(t.policy, attempt)
+		if remaining, ok := t.deadline.remaining(); ok && delay >= remaining {
+			return resp, err
+		}
+		if !t.budget.allowRetry(time.Now()) {
+			if gin.Mode() == "debug" {
+				log.Printf("Retry budget of %s service exhausted", t.serviceName)
+			}
+			return resp, err
+		}
+		next, replayErr := replay(req)
+		if replayErr != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			// Drain the failed response so its connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		if gin.Mode() == "debug" {
+			log.Printf("Retrying request to %s service in %s (attempt %d): %v", t.serviceName, delay, attempt+1, failure(resp, err))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		if remaining, ok := t.deadline.remaining(); ok {
+			next.Header.Set(TimeoutHeader, strconv.FormatInt(remaining.Milliseconds(), 10))
+		}
+		if t.reroute != nil {
+			t.reroute(next)
+		}
+		req = next
+	}
+}
+
+// shouldRetry reports whether the policy retries the outcome of an attempt
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err == nil {
+		return slices.Contains(t.policy.StatusCodes, resp.StatusCode)
+	}
+	// The client went away or the deadline passed
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return slices.Contains(t.policy.Errors, config.RetryOnConnect)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return slices.Contains(t.policy.Errors, config.RetryOnReset)
+	}
+	return false
+}
+
+// replay copies a request for another attempt, with a fresh copy of the body
+func replay(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+// failure describes a failed attempt for the log
+func failure(resp *http.Response, err error) any {
+	if err != nil {
+		return err
+	}
+	return resp.Status
+}
+
+// backoff returns the wait before the given retry. It doubles with every retry
+// up to the maximum, and the upper half is random so that clients failing
+// together do not retry in lockstep.
+func backoff(policy config.RetryConfig, retry int) time.Duration {
+	maxDelay := time.Duration(policy.MaxBackoff) * time.Millisecond
+	delay := time.Duration(policy.Backoff) * time.Millisecond
+	for i := 1; i < retry && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryBudget limits the retries to a service to a percentage of its requests
+// over the last windowSeconds seconds, so that retries cannot multiply the load
+// on a service that is already failing
+type retryBudget struct {
+	percent      int
+	minPerSecond int
+
+	mu     sync.Mutex
+	counts rollingWindow[budgetCounts]
+}
+
+// budgetCounts are the requests and retries of one second
+type budgetCounts struct {
+	requests int
+	retries  int
+}
+
+func newRetryBudget(cfg config.RetryBudgetConfig) *retryBudget {
+	return &retryBudget{percent: cfg.Percent, minPerSecond: cfg.MinPerSecond}
+}
+
+// recordRequest counts a request to the service
+func (b *retryBudget) recordRequest(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts.current(now).requests++
+}
+
+// allowRetry reports whether the budget has room for another retry, and counts it if so
+func (b *retryBudget) allowRetry(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	requests, retries := 0, 0
+	for _, counts := range b.counts.recent(now) {
+		requests += counts.requests
+		retries += counts.retries
+	}
+	if retries >= b.minPerSecond*windowSeconds+requests*b.percent/100 {
+		return false
+	}
+
+	b.counts.current(now).retries++
+	return true
+}
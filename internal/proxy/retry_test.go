@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// testRetryPolicy retries three times in quick succession with room in the budget
+var testRetryPolicy = config.RetryConfig{
+	MaxAttempts: 3,
+	Backoff:     1,
+	MaxBackoff:  5,
+	StatusCodes: []int{http.StatusServiceUnavailable},
+	Errors:      []string{config.RetryOnConnect, config.RetryOnReset},
+	Methods:     []string{"GET", "PUT"},
+	Budget:      config.RetryBudgetConfig{Percent: 100, MinPerSecond: 10},
+}
+
+// failingUpstream fails the first failures requests, either with a 503 or by
+// dropping the connection, and records the bodies it received
+type failingUpstream struct {
+	failures int
+	drop     bool
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (u *failingUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	u.mu.Lock()
+	u.bodies = append(u.bodies, string(body))
+	attempt := len(u.bodies)
+	u.mu.Unlock()
+
+	if attempt > u.failures {
+		io.WriteString(w, "ok")
+		return
+	}
+	if u.drop {
+		conn, _, _ := http.NewResponseController(w).Hijack()
+		conn.Close()
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+}
+
+func (u *failingUpstream) attempts() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.bodies
+}
+
+func TestProxyToService_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		key          string
+		route        *config.RetryConfig
+		upstream     *failingUpstream
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"retried status", http.MethodGet, "", nil, &failingUpstream{failures: 1}, http.StatusOK, 2},
+		{"dropped connection", http.MethodGet, "", nil, &failingUpstream{failures: 1, drop: true}, http.StatusOK, 2},
+		{"attempts exhausted", http.MethodGet, "", nil, &failingUpstream{failures: 5}, http.StatusServiceUnavailable, 3},
+		{"idempotent method with body", http.MethodPut, "", nil, &failingUpstream{failures: 1}, http.StatusOK, 2},
+		{"non-idempotent method", http.MethodPost, "", nil, &failingUpstream{failures: 1}, http.StatusServiceUnavailable, 1},
+		{"idempotency key", http.MethodPost, "order-7", nil, &failingUpstream{failures: 1}, http.StatusOK, 2},
+		{"disabled on the route", http.MethodGet, "", &config.RetryConfig{MaxAttempts: 1}, &failingUpstream{failures: 1}, http.StatusServiceUnavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			backend := httptest.NewServer(tt.upstream)
+			defer backend.Close()
+
+			proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+				"order_service": {BaseURL: backend.URL, Retry: testRetryPolicy},
+			}, config.ProxyConfig{})
+			if err != nil {
+				t.Fatalf("Failed to set up proxy: %v", err)
+			}
+			router := gin.New()
+			router.Any("/api/orders", proxyHandler.ProxyToService("order_service", "/orders", 0, tt.route))
+
+			req, _ := http.NewRequest(tt.method, "/api/orders", strings.NewReader("order"))
+			if tt.key != "" {
+				req.Header.Set(IdempotencyKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			attempts := tt.upstream.attempts()
+			if len(attempts) != tt.wantAttempts {
+				t.Fatalf("Expected %d attempts, got %d", tt.wantAttempts, len(attempts))
+			}
+			for i, body := range attempts {
+				if body != "order" {
+					t.Errorf("Expected attempt %d to send the body, got %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(config.RetryBudgetConfig{Percent: 50})
+	now := time.Unix(1_700_000_000, 0)
+
+	for range 4 {
+		budget.recordRequest(now)
+	}
+	for i := range 2 {
+		if !budget.allowRetry(now) {
+			t.Fatalf("Expected retry %d within the budget", i+1)
+		}
+	}
+	if budget.allowRetry(now) {
+		t.Error("Expected the budget to be exhausted")
+	}
+
+	// Requests and retries older than the window no longer count
+	later := now.Add(windowSeconds * time.Second)
+	budget.recordRequest(later)
+	budget.recordRequest(later)
+	if !budget.allowRetry(later) {
+		t.Error("Expected the budget to recover after the window")
+	}
+
+	minimum := newRetryBudget(config.RetryBudgetConfig{MinPerSecond: 1})
+	for i := range windowSeconds {
+		if !minimum.allowRetry(now) {
+			t.Fatalf("Expected retry %d within the minimum", i+1)
+		}
+	}
+	if minimum.allowRetry(now) {
+		t.Error("Expected the minimum to be used up")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	policy := config.RetryConfig{Backoff: 100, MaxBackoff: 300}
+
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 300 * time.Millisecond},
+		{10, 300 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for range 20 {
+			if got := backoff(policy, tt.retry); got < tt.max/2 || got > tt.max {
+				t.Errorf("Expected retry %d to wait between %s and %s, got %s", tt.retry, tt.max/2, tt.max, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// errTooManyConnections is returned when a user already has the maximum number of WebSocket connections open
+	errTooManyConnections = errors.New("too many websocket connections")
+
+	// errShuttingDown is returned for WebSocket upgrades once the gateway is shutting down
+	errShuttingDown = errors.New("gateway is shutting down")
+)
+
+// goingAwayFrame is an unmasked WebSocket close frame with status 1001 (going away)
+var goingAwayFrame = []byte{0x88, 0x02, 0x03, 0xe9}
+
+// IsWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol
+func IsWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, option := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(option), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// connectionOwner identifies the user or guest session a WebSocket connection
+// counts against. Connections on routes without authentication have no owner.
+func connectionOwner(c *gin.Context) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	if guestID := c.GetString("guest_id"); guestID != "" {
+		return "guest:" + guestID
+	}
+	return ""
+}
+
+// acquireConnection counts a WebSocket connection against its owner's limit.
+// The returned function releases it again.
+func (p *ProxyHandler) acquireConnection(owner string) (func(), error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shuttingDown {
+		return nil, errShuttingDown
+	}
+	if owner == "" {
+		return func() {}, nil
+	}
+	if p.maxConnections > 0 && p.connections[owner] >= p.maxConnections {
+		return nil, errTooManyConnections
+	}
+
+	p.connections[owner]++
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if p.connections[owner]--; p.connections[owner] <= 0 {
+			delete(p.connections, owner)
+		}
+	}, nil
+}
+
+// abortWebSocketUpgrade rejects an upgrade request that cannot be tunnelled
+func (p *ProxyHandler) abortWebSocketUpgrade(c *gin.Context, err error) {
+	if err == errTooManyConnections {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":           "Too many WebSocket connections",
+			"max_connections": p.maxConnections,
+		})
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Gateway is shutting down"})
+}
+
+// Hijack takes over the client connection of a switched protocol. It is wrapped
+// in a tunnel that enforces the idle timeout and can be closed on shutdown.
+func (w responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.proxy.openTunnel(conn), brw, nil
+}
+
+// openTunnel registers a hijacked client connection
+func (p *ProxyHandler) openTunnel(conn net.Conn) *tunnelConn {
+	tunnel := &tunnelConn{Conn: conn, idleTimeout: p.idleTimeout}
+	tunnel.onClose = func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		delete(p.tunnels, tunnel)
+	}
+
+	// Clear the read and write deadlines the server set for the handshake
+	tunnel.extendDeadline()
+
+	p.mu.Lock()
+	p.tunnels[tunnel] = struct{}{}
+	shuttingDown := p.shuttingDown
+	p.mu.Unlock()
+
+	if shuttingDown {
+		tunnel.goAway()
+	}
+	return tunnel
+}
+
+// ShutdownTunnels closes the open WebSocket connections for a server shutdown,
+// which does not wait for hijacked connections. Each client is sent a going
+// away close frame so it can reconnect to another gateway instance. Connections
+// still open when ctx is done are closed without one.
+func (p *ProxyHandler) ShutdownTunnels(ctx context.Context) {
+	p.mu.Lock()
+	p.shuttingDown = true
+	tunnels := make([]*tunnelConn, 0, len(p.tunnels))
+	for tunnel := range p.tunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	p.mu.Unlock()
+
+	for _, tunnel := range tunnels {
+		tunnel.goAway()
+	}
+
+	// Tunnels in the middle of a message close once the current frame is written
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		p.mu.Lock()
+		open := len(p.tunnels)
+		p.mu.Unlock()
+		if open == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, tunnel := range tunnels {
+				tunnel.Close()
+			}
+			return
+		}
+	}
+}
+
+// tunnelConn is the client side of a proxied WebSocket connection. Any traffic
+// in either direction postpones the idle timeout. Writes are tracked frame by
+// frame so a close frame can be sent between two of the service's frames.
+type tunnelConn struct {
+	net.Conn
+	idleTimeout time.Duration
+	onClose     func()
+	closeOnce   sync.Once
+
+	mu      sync.Mutex // serializes the service's writes with the close frame
+	frames  frameTracker
+	closing bool
+}
+
+func (t *tunnelConn) Read(p []byte) (int, error) {
+	n, err := t.Conn.Read(p)
+	if n > 0 {
+		t.extendDeadline()
+	}
+	return n, err
+}
+
+func (t *tunnelConn) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	written := 0
+	for len(p) > 0 {
+		if t.closing && t.frames.atBoundary() {
+			t.goAwayLocked()
+			return written, net.ErrClosed
+		}
+
+		// While closing, stop at the end of the current frame
+		n := len(p)
+		if t.closing {
+			n = t.frames.advance(p)
+		} else {
+			t.frames.consume(p)
+		}
+
+		m, err := t.Conn.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	if t.closing && t.frames.atBoundary() {
+		t.goAwayLocked()
+	}
+
+	t.extendDeadline()
+	return written, nil
+}
+
+func (t *tunnelConn) Close() error {
+	err := net.ErrClosed
+	t.closeOnce.Do(func() {
+		err = t.Conn.Close()
+		t.onClose()
+	})
+	return err
+}
+
+// extendDeadline postpones the idle timeout
+func (t *tunnelConn) extendDeadline() {
+	if t.idleTimeout > 0 {
+		t.Conn.SetDeadline(time.Now().Add(t.idleTimeout))
+	} else {
+		t.Conn.SetDeadline(time.Time{})
+	}
+}
+
+// goAway sends the client a going away close frame and closes the connection,
+// or has the next write do so when the service is in the middle of a frame
+func (t *tunnelConn) goAway() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closing = true
+	if t.frames.atBoundary() {
+		t.goAwayLocked()
+	}
+}
+
+func (t *tunnelConn) goAwayLocked() {
+	t.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	t.Conn.Write(goingAwayFrame)
+	t.Close()
+}
+
+// frameTracker follows the frame boundaries in a stream of WebSocket frames
+type frameTracker struct {
+	header    []byte // the part of the current frame header seen so far
+	remaining uint64 // payload bytes left in the current frame
+	inPayload bool
+}
+
+// atBoundary reports whether the stream is between two frames
+func (f *frameTracker) atBoundary() bool {
+	return !f.inPayload && len(f.header) == 0
+}
+
+// consume advances past all of p
+func (f *frameTracker) consume(p []byte) {
+	for len(p) > 0 {
+		p = p[f.advance(p):]
+	}
+}
+
+// advance consumes p up to the end of the current frame and returns the number
+// of bytes consumed
+func (f *frameTracker) advance(p []byte) int {
+	n := 0
+	for n < len(p) {
+		if f.inPayload {
+			k := uint64(len(p) - n)
+			if k > f.remaining {
+				k = f.remaining
+			}
+			n += int(k)
+			f.remaining -= k
+			if f.remaining == 0 {
+				f.inPayload = false
+				return n
+			}
+			continue
+		}
+
+		f.header = append(f.header, p[n])
+		n++
+		if size := frameHeaderSize(f.header); size > 0 && len(f.header) == size {
+			f.remaining = framePayloadLength(f.header)
+			f.header = f.header[:0]
+			if f.remaining == 0 {
+				return n
+			}
+			f.inPayload = true
+		}
+	}
+	return n
+}
+
+// frameHeaderSize returns the size of a frame header from its first two bytes,
+// or 0 when fewer have been seen
+func frameHeaderSize(header []byte) int {
+	if len(header) < 2 {
+		return 0
+	}
+	size := 2
+	switch header[1] & 0x7f {
+	case 126:
+		size += 2
+	case 127:
+		size += 8
+	}
+	if header[1]&0x80 != 0 {
+		size += 4 // masking key
+	}
+	return size
+}
+
+// framePayloadLength returns the payload length of a complete frame header
+func framePayloadLength(header []byte) uint64 {
+	switch length := header[1] & 0x7f; length {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(header[2:4]))
+	case 127:
+		return binary.BigEndian.Uint64(header[2:10])
+	default:
+		return uint64(length)
+	}
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+)
+
+// textFrame is a masked client text frame carrying "hello"
+var textFrame = []byte{0x81, 0x85, 0x01, 0x02, 0x03, 0x04, 'h' ^ 0x01, 'e' ^ 0x02, 'l' ^ 0x03, 'l' ^ 0x04, 'o' ^ 0x01}
+
+// setupWebSocketProxy proxies /ws to an upstream that echoes WebSocket frames.
+// The X-Test-User header stands in for the authenticated user.
+func setupWebSocketProxy(t *testing.T, cfg config.ProxyConfig) (*ProxyHandler, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !IsWebSocketUpgrade(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		io.Copy(conn, brw)
+	}))
+	t.Cleanup(backend.Close)
+
+	proxyHandler, err := NewProxyHandler(config.ServicesConfig{
+		"order_service": {BaseURL: backend.URL},
+	}, cfg)
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("user_id", c.GetHeader("X-Test-User"))
+	}, proxyHandler.ProxyToService("order_service", "/orders/updates", 0, nil))
+
+	gateway := httptest.NewServer(router)
+	t.Cleanup(gateway.Close)
+	return proxyHandler, gateway
+}
+
+// dialWebSocket sends an upgrade request for /ws and returns the connection and handshake response
+func dialWebSocket(t *testing.T, gateway *httptest.Server, user string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(gateway.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	req, _ := http.NewRequest(http.MethodGet, gateway.URL+"/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("X-Test-User", user)
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Failed to send upgrade request: %v", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	return conn, reader, resp
+}
+
+func TestWebSocket_Tunnel(t *testing.T) {
+	_, gateway := setupWebSocketProxy(t, config.ProxyConfig{})
+
+	conn, reader, resp := dialWebSocket(t, gateway, "user-1")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	if _, err := conn.Write(textFrame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	echoed := make([]byte, len(textFrame))
+	if _, err := io.ReadFull(reader, echoed); err != nil {
+		t.Fatalf("Failed to read echoed frame: %v", err)
+	}
+	if !bytes.Equal(echoed, textFrame) {
+		t.Errorf("Expected the frame echoed, got %v", echoed)
+	}
+}
+
+func TestWebSocket_ConnectionLimit(t *testing.T) {
+	_, gateway := setupWebSocketProxy(t, config.ProxyConfig{
+		WebSocket: config.WebSocketConfig{MaxConnectionsPerUser: 1},
+	})
+
+	first, _, resp := dialWebSocket(t, gateway, "user-1")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	if _, _, resp := dialWebSocket(t, gateway, "user-1"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 over the limit, got %d", resp.StatusCode)
+	}
+	if _, _, resp := dialWebSocket(t, gateway, "user-2"); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("Expected another user to connect, got %d", resp.StatusCode)
+	}
+
+	// Closing the first connection frees its slot
+	first.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, _, resp := dialWebSocket(t, gateway, "user-1")
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the slot to be released, got %d", resp.StatusCode)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWebSocket_IdleTimeout(t *testing.T) {
+	proxyHandler, gateway := setupWebSocketProxy(t, config.ProxyConfig{})
+	proxyHandler.idleTimeout = 100 * time.Millisecond
+
+	conn, reader, resp := dialWebSocket(t, gateway, "user-1")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, got %v", err)
+	}
+}
+
+func TestWebSocket_ShutdownTunnels(t *testing.T) {
+	proxyHandler, gateway := setupWebSocketProxy(t, config.ProxyConfig{})
+
+	conn, reader, resp := dialWebSocket(t, gateway, "user-1")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected status 101, got %d", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	proxyHandler.ShutdownTunnels(ctx)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	closeFrame, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Failed to read close frame: %v", err)
+	}
+	if !bytes.Equal(closeFrame, goingAwayFrame) {
+		t.Errorf("Expected a going away close frame, got %v", closeFrame)
+	}
+
+	if _, _, resp := dialWebSocket(t, gateway, "user-1"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 after shutdown, got %d", resp.StatusCode)
+	}
+}
+
+func TestFrameTracker(t *testing.T) {
+	extended := append([]byte{0x82, 126, 0x01, 0x2c}, make([]byte, 300)...)
+	frames := [][]byte{
+		{0x81, 0x03, 'a', 'b', 'c'},
+		textFrame,
+		{0x89, 0x00}, // ping without payload
+		extended,
+	}
+
+	var stream []byte
+	var boundaries []int
+	for _, frame := range frames {
+		stream = append(stream, frame...)
+		boundaries = append(boundaries, len(stream))
+	}
+
+	t.Run("byte by byte", func(t *testing.T) {
+		var tracker frameTracker
+		var got []int
+		for i := range stream {
+			tracker.consume(stream[i : i+1])
+			if tracker.atBoundary() {
+				got = append(got, i+1)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(boundaries) {
+			t.Errorf("Expected boundaries %v, got %v", boundaries, got)
+		}
+	})
+
+	t.Run("one frame at a time", func(t *testing.T) {
+		var tracker frameTracker
+		var got []int
+		for offset := 0; offset < len(stream); {
+			offset += tracker.advance(stream[offset:])
+			if !tracker.atBoundary() {
+				t.Fatalf("Expected a boundary at %d", offset)
+			}
+			got = append(got, offset)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(boundaries) {
+			t.Errorf("Expected boundaries %v, got %v", boundaries, got)
+		}
+	})
+}
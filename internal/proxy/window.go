@@ -0,0 +1,43 @@
+package proxy
+
+import "time"
+
+// windowSeconds is the number of seconds retry budgets and circuit breakers look back over
+const windowSeconds = 10
+
+// rollingWindow keeps counts per second over the last windowSeconds seconds
+type rollingWindow[T any] struct {
+	buckets [windowSeconds]windowBucket[T]
+}
+
+type windowBucket[T any] struct {
+	second int64
+	counts T
+}
+
+// current returns the counts of the current second, clearing the bucket if it
+// last counted an older one
+func (w *rollingWindow[T]) current(now time.Time) *T {
+	second := now.Unix()
+	bucket := &w.buckets[second%windowSeconds]
+	if bucket.second != second {
+		*bucket = windowBucket[T]{second: second}
+	}
+	return &bucket.counts
+}
+
+// recent returns the counts of the seconds still in the window
+func (w *rollingWindow[T]) recent(now time.Time) []T {
+	counts := make([]T, 0, windowSeconds)
+	for _, bucket := range w.buckets {
+		if age := now.Unix() - bucket.second; age >= 0 && age < windowSeconds {
+			counts = append(counts, bucket.counts)
+		}
+	}
+	return counts
+}
+
+// reset clears all counts
+func (w *rollingWindow[T]) reset() {
+	*w = rollingWindow[T]{}
+}
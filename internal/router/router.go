@@ -2,31 +2,69 @@ package router
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/audit"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/mailer"
 	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/middleware"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/proxy"
 )
 
 // SetupRouter sets up the main router with all routes and middleware
-func SetupRouter(db *sql.DB, cfg *config.Config) *gin.Engine {
+func SetupRouter(db *sql.DB, cfg *config.Config, auditLogger *audit.Logger, mail mailer.Mailer, proxyHandler *proxy.ProxyHandler) *gin.Engine {
 	// Create Gin router
 	r := gin.New()
 
+	// Only the load balancers in front of the gateway may report the client
+	// address in forwarding headers. The list is validated by config.Load.
+	r.SetTrustedProxies(cfg.Proxy.TrustedProxies)
+	trustedProxies, _ := config.ParseTrustedProxies(cfg.Proxy.TrustedProxies)
+
 	// Add middleware
 	r.Use(middleware.Logger())
 	r.Use(middleware.CORS())
 	r.Use(middleware.RequestID())
+	r.Use(middleware.TrustedProxies(trustedProxies))
+	r.Use(middleware.StripIdentityHeaders())
 	r.Use(gin.Recovery())
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler()
-	authHandler := handlers.NewAuthHandler(db, cfg)
+	healthHandler := handlers.NewHealthHandler(proxyHandler)
+	authHandler := handlers.NewAuthHandler(db, cfg, auditLogger, mail)
+	tenantHandler := handlers.NewTenantHandler(db)
+	auditHandler := handlers.NewAuditHandler(db)
+	scimHandler := handlers.NewSCIMHandler(db)
+	userHandler := handlers.NewUserHandler(db, mail)
 
 	// Health check routes
 	r.GET("/health", healthHandler.HealthCheck)
 	r.GET("/ready", healthHandler.ReadinessCheck)
+	r.GET("/metrics", middleware.MetricsAuthMiddleware(cfg.Metrics.BearerToken), proxyHandler.Metrics)
+
+	// Keys services verify the gateway's internal identity tokens with
+	r.GET("/.well-known/internal-jwks.json", proxyHandler.InternalKeys)
+
+	// SCIM 2.0 provisioning routes for the HR system
+	scim := r.Group("/scim/v2")
+	scim.Use(middleware.SCIMAuthMiddleware(cfg.Auth.SCIM.BearerToken))
+	{
+		scim.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+
+		scim.GET("/Users", scimHandler.ListUsers)
+		scim.POST("/Users", scimHandler.CreateUser)
+		scim.GET("/Users/:id", scimHandler.GetUser)
+		scim.PUT("/Users/:id", scimHandler.ReplaceUser)
+		scim.PATCH("/Users/:id", scimHandler.PatchUser)
+		scim.DELETE("/Users/:id", scimHandler.DeleteUser)
+
+		scim.GET("/Groups", scimHandler.ListGroups)
+		scim.GET("/Groups/:id", scimHandler.GetGroup)
+		scim.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+		scim.PATCH("/Groups/:id", scimHandler.PatchGroup)
+	}
 
 	// API routes
 	api := r.Group("/api")
@@ -39,49 +77,90 @@ func SetupRouter(db *sql.DB, cfg *config.Config) *gin.Engine {
 				auth.POST("/login", authHandler.Login)
 				auth.POST("/register", authHandler.Register)
 				auth.POST("/logout", authHandler.Logout)
+				auth.POST("/refresh", authHandler.RefreshToken)
+				// Deprecated: GET with a JSON body, kept for existing clients. It
+				// never reads the refresh token cookie.
 				auth.GET("/refresh", authHandler.RefreshToken)
-			}
 
-			// Order routes (to be proxied to order service)
-			orders := v1.Group("/orders")
-			orders.Use(middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath))
-			{
-				orders.GET("/", placeholderHandler("orders", "list"))
-				orders.POST("/", placeholderHandler("orders", "create"))
-				orders.GET("/:id", placeholderHandler("orders", "get"))
-				orders.PUT("/:id", placeholderHandler("orders", "update"))
-				orders.DELETE("/:id", placeholderHandler("orders", "delete"))
-			}
+				// Kiosk device authentication and operator PIN quick-login
+				auth.POST("/device/token", authHandler.DeviceToken)
+				auth.POST("/pin-login", middleware.DeviceAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.PinLogin)
+				auth.PUT("/pin", middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.SetPin)
 
-			// Inventory routes (to be proxied to inventory service)
-			inventory := v1.Group("/inventory")
-			inventory.Use(middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath))
-			{
-				inventory.GET("/", placeholderHandler("inventory", "list"))
-				inventory.GET("/:id", placeholderHandler("inventory", "get"))
-				inventory.PUT("/:id", placeholderHandler("inventory", "update"))
+				// Store selection for users working in multiple stores
+				auth.GET("/stores", middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.ListStores)
+				auth.POST("/switch-store", middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.SwitchStore)
+
+				// Anonymous guest sessions for kiosk customers
+				auth.POST("/guest", middleware.DeviceAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.StartGuestSession)
+				auth.POST("/guest/upgrade", middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath), authHandler.UpgradeGuestSession)
+
+				// Federated sign-in through configured OIDC providers
+				auth.GET("/oidc/:provider/login", authHandler.OIDCLogin)
+				auth.GET("/oidc/:provider/callback", authHandler.OIDCCallback)
+
+				// Passwordless sign-in links, when enabled for the environment
+				auth.POST("/magic-link", authHandler.RequestMagicLink)
+				auth.POST("/magic-link/verify", authHandler.VerifyMagicLink)
 			}
 
-			// Payment routes (to be proxied to payment service)
-			payments := v1.Group("/payments")
+			// Admin routes
+			admin := v1.Group("/admin")
+			admin.Use(middleware.JWTAuthMiddleware(cfg.Keys.PublicKeyPath))
+			admin.Use(middleware.RequireRole(handlers.RoleAdmin))
 			{
-				payments.POST("/", placeholderHandler("payments", "create"))
-				payments.GET("/:id", placeholderHandler("payments", "get"))
-				payments.POST("/:id/refund", placeholderHandler("payments", "refund"))
+				admin.POST("/devices", authHandler.RegisterDevice)
+				admin.DELETE("/devices/:id", authHandler.DeactivateDevice)
+
+				admin.POST("/tenants", tenantHandler.CreateTenant)
+				admin.POST("/stores", tenantHandler.CreateStore)
+				admin.PUT("/stores/:id/access-hours", tenantHandler.SetStoreAccessHours)
+				admin.POST("/stores/:id/members", tenantHandler.AddStoreMember)
+				admin.DELETE("/stores/:id/members/:user_id", tenantHandler.RemoveStoreMember)
+
+				admin.GET("/audit/events", auditHandler.ListEvents)
+
+				admin.POST("/invites", authHandler.CreateInvite)
+				admin.GET("/invites", authHandler.ListInvites)
+				admin.DELETE("/invites/:id", authHandler.RevokeInvite)
+
+				admin.PUT("/users/:id/session-limit", authHandler.SetSessionLimit)
+
+				admin.POST("/users/import", userHandler.ImportUsers)
+				admin.GET("/users/export", userHandler.ExportUsers)
 			}
 		}
 	}
 
+	// Service routes from the route table, authenticated here and proxied
+	// to their service
+	for _, route := range cfg.Routes {
+		r.Handle(route.Method, route.Path, routeHandlers(route, cfg.Keys.PublicKeyPath, proxyHandler)...)
+	}
+
 	return r
 }
 
-// placeholderHandler is a temporary handler for routes that will be implemented later
-func placeholderHandler(service, action string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"message": "This endpoint will proxy to " + service + " service",
-			"action":  action,
-			"status":  "not_implemented",
-		})
+// routeHandlers returns the middleware enforcing the route's auth requirement
+// and permissions, followed by the proxy to its service
+func routeHandlers(route config.RouteConfig, publicKeyPath string, proxyHandler *proxy.ProxyHandler) []gin.HandlerFunc {
+	var chain []gin.HandlerFunc
+	switch route.Auth {
+	case config.RouteAuthGuest:
+		chain = append(chain, middleware.GuestAuthMiddleware(publicKeyPath))
+	case config.RouteAuthNone:
+	default:
+		// Guests get 403 Sign in required rather than 401, so a kiosk knows to
+		// ask for a login instead of restarting the guest session
+		chain = append(chain, middleware.GuestAuthMiddleware(publicKeyPath), middleware.RequireUser())
+	}
+
+	if len(route.Roles) > 0 {
+		chain = append(chain, middleware.RequireRole(route.Roles...))
+	}
+	if len(route.Scopes) > 0 {
+		chain = append(chain, middleware.RequireScope(route.Scopes...))
 	}
+
+	return append(chain, proxyHandler.ProxyToService(route.Service, route.UpstreamPath, time.Duration(route.Timeout)*time.Second, route.Retry))
 }
@@ -0,0 +1,309 @@
+package router
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/config"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/handlers"
+	"github.com/harrywijaya/mini-kiosk-central-gateway/internal/proxy"
+)
+
+// upstreamRequest is what a test upstream saw of a proxied request
+type upstreamRequest struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Query  string `json:"query"`
+	Body   string `json:"body"`
+	UserID string `json:"user_id"`
+	Scope  string `json:"scope"`
+}
+
+// newTestUpstream starts a service that echoes the requests it receives
+func newTestUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Service", name)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(upstreamRequest{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Body:   string(body),
+			UserID: r.Header.Get("X-User-ID"),
+			Scope:  r.Header.Get("X-Scope"),
+		})
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// setupTestGateway starts test upstreams for every service and returns a
+// router forwarding to them along the route table, with the key to sign access
+// tokens. Without routes the default route table is used.
+func setupTestGateway(t *testing.T, routes ...config.RouteConfig) (*gin.Engine, *rsa.PrivateKey) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	publicKeyPath := filepath.Join(t.TempDir(), "publicKey.pem")
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes})
+	if err := os.WriteFile(publicKeyPath, publicKeyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Keys.PublicKeyPath = publicKeyPath
+	cfg.Services = config.ServicesConfig{}
+	for _, name := range []string{"order", "inventory", "payment", "loyalty"} {
+		cfg.Services[name+"_service"] = config.ServiceConfig{BaseURL: newTestUpstream(t, name).URL}
+	}
+	cfg.Routes = routes
+	if len(routes) == 0 {
+		cfg.Routes = config.DefaultRoutes()
+	}
+
+	proxyHandler, err := proxy.NewProxyHandler(cfg.Services, cfg.Proxy)
+	if err != nil {
+		t.Fatalf("Failed to set up proxy: %v", err)
+	}
+	return SetupRouter(nil, cfg, nil, nil, proxyHandler), privateKey
+}
+
+// signTestToken signs a user or guest access token with the given scope
+func signTestToken(t *testing.T, privateKey *rsa.PrivateKey, subjectType, scope string) string {
+	t.Helper()
+
+	claims := &handlers.Claims{
+		Username:    "existinguser",
+		SubjectType: subjectType,
+		Scope:       scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "subject-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return token
+}
+
+func TestServiceRoutes_Proxy(t *testing.T) {
+	router, privateKey := setupTestGateway(t)
+	token := signTestToken(t, privateKey, handlers.SubjectTypeUser, strings.Join(handlers.AllScopes, " "))
+
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		service  string
+		wantPath string
+	}{
+		{"GET", "/api/v1/orders/?status=open", "", "order", "/orders/"},
+		{"POST", "/api/v1/orders/", `{"items":[]}`, "order", "/orders/"},
+		{"GET", "/api/v1/orders/42", "", "order", "/orders/42"},
+		{"PUT", "/api/v1/orders/42", `{"status":"paid"}`, "order", "/orders/42"},
+		{"DELETE", "/api/v1/orders/42", "", "order", "/orders/42"},
+		{"GET", "/api/v1/inventory/", "", "inventory", "/inventory/"},
+		{"PUT", "/api/v1/inventory/7", `{"quantity":3}`, "inventory", "/inventory/7"},
+		{"POST", "/api/v1/payments/", `{"amount":100}`, "payment", "/payments/"},
+		{"GET", "/api/v1/payments/9", "", "payment", "/payments/9"},
+		{"POST", "/api/v1/payments/9/refund", "", "payment", "/payments/9/refund"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Expected the upstream status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("X-Service"); got != tt.service {
+				t.Errorf("Expected the %s service, got %q", tt.service, got)
+			}
+
+			var seen upstreamRequest
+			if err := json.Unmarshal(w.Body.Bytes(), &seen); err != nil {
+				t.Fatalf("Failed to decode upstream response: %v", err)
+			}
+			if seen.Method != tt.method || seen.Path != tt.wantPath || seen.Body != tt.body {
+				t.Errorf("Unexpected upstream request %+v", seen)
+			}
+			if _, query, _ := strings.Cut(tt.path, "?"); seen.Query != query {
+				t.Errorf("Expected query %q, got %q", query, seen.Query)
+			}
+			if seen.UserID != "existinguser" || seen.Scope == "" {
+				t.Errorf("Expected the identity headers to be forwarded, got %+v", seen)
+			}
+		})
+	}
+}
+
+func TestServiceRoutes_AuthenticatedBeforeProxy(t *testing.T) {
+	router, privateKey := setupTestGateway(t)
+	guestToken := signTestToken(t, privateKey, handlers.SubjectTypeGuest, strings.Join(handlers.AllScopes, " "))
+	readOnlyToken := signTestToken(t, privateKey, handlers.SubjectTypeUser, handlers.ScopeOrdersRead)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no token", "GET", "/api/v1/orders/42", "", http.StatusUnauthorized},
+		{"invalid token", "GET", "/api/v1/orders/42", "not-a-token", http.StatusUnauthorized},
+		{"missing scope", "POST", "/api/v1/orders/", readOnlyToken, http.StatusForbidden},
+		{"guest refund", "POST", "/api/v1/payments/9/refund", guestToken, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if w.Header().Get("X-Service") != "" {
+				t.Error("Expected the request not to reach the upstream")
+			}
+		})
+	}
+}
+
+func TestServiceRoutes_RouteTable(t *testing.T) {
+	router, privateKey := setupTestGateway(t,
+		config.RouteConfig{Method: "GET", Path: "/api/v1/loyalty/members/:id/points", Service: "loyalty_service", UpstreamPath: "/v2/points/:id", Auth: config.RouteAuthNone},
+		config.RouteConfig{Method: "POST", Path: "/api/v1/loyalty/rewards/*path", Service: "loyalty_service", UpstreamPath: "/rewards/*path", Auth: config.RouteAuthUser, Roles: []string{handlers.RoleManager}},
+	)
+
+	managerClaims := &handlers.Claims{
+		Username:    "manager",
+		Role:        handlers.RoleManager,
+		SubjectType: handlers.SubjectTypeUser,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "manager-id",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
+		},
+	}
+	managerToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, managerClaims).SignedString(privateKey)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	cashierToken := signTestToken(t, privateKey, handlers.SubjectTypeUser, "")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		want     int
+		wantPath string
+	}{
+		{"public route", "GET", "/api/v1/loyalty/members/m1/points", "", http.StatusCreated, "/v2/points/m1"},
+		{"catch-all route", "POST", "/api/v1/loyalty/rewards/seasonal/redeem", managerToken, http.StatusCreated, "/rewards/seasonal/redeem"},
+		{"missing role", "POST", "/api/v1/loyalty/rewards/seasonal/redeem", cashierToken, http.StatusForbidden, ""},
+		{"not in the table", "GET", "/api/v1/orders/42", managerToken, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+			if tt.wantPath == "" {
+				return
+			}
+
+			var seen upstreamRequest
+			if err := json.Unmarshal(w.Body.Bytes(), &seen); err != nil {
+				t.Fatalf("Failed to decode upstream response: %v", err)
+			}
+			if w.Header().Get("X-Service") != "loyalty" || seen.Path != tt.wantPath {
+				t.Errorf("Expected %s on the loyalty service, got %s on %q", tt.wantPath, seen.Path, w.Header().Get("X-Service"))
+			}
+		})
+	}
+}
+
+func TestServiceRoutes_IdentityHeadersFromClaimsOnly(t *testing.T) {
+	router, privateKey := setupTestGateway(t,
+		config.RouteConfig{Method: "GET", Path: "/api/v1/loyalty/members/:id/points", Service: "loyalty_service", UpstreamPath: "/v2/points/:id", Auth: config.RouteAuthNone},
+		config.RouteConfig{Method: "GET", Path: "/api/v1/loyalty/rewards", Service: "loyalty_service", UpstreamPath: "/rewards", Auth: config.RouteAuthUser},
+	)
+	token := signTestToken(t, privateKey, handlers.SubjectTypeUser, "")
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantUserID string
+	}{
+		{"public route", "/api/v1/loyalty/members/m1/points", "", ""},
+		{"authenticated route", "/api/v1/loyalty/rewards", token, "existinguser"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			req.Header.Set("X-User-ID", "forged-admin")
+			req.Header.Set("X-Scope", "payments:refund")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var seen upstreamRequest
+			if err := json.Unmarshal(w.Body.Bytes(), &seen); err != nil {
+				t.Fatalf("Failed to decode upstream response: %v", err)
+			}
+			if seen.UserID != tt.wantUserID {
+				t.Errorf("Expected X-User-ID %q, got %q", tt.wantUserID, seen.UserID)
+			}
+			if seen.Scope != "" {
+				t.Errorf("Expected the forged X-Scope to be removed, got %q", seen.Scope)
+			}
+		})
+	}
+}
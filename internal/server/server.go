@@ -18,6 +18,7 @@ import (
 type Server struct {
 	httpServer *http.Server
 	config     *config.Config
+	onShutdown []func(context.Context)
 }
 
 // NewServer creates a new server instance
@@ -34,6 +35,12 @@ func NewServer(router *gin.Engine, cfg *config.Config) *Server {
 	}
 }
 
+// RegisterOnShutdown registers a function to call on shutdown, once the server
+// has stopped accepting connections. It is given the shutdown deadline.
+func (s *Server) RegisterOnShutdown(f func(context.Context)) {
+	s.onShutdown = append(s.onShutdown, f)
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	// Create a channel to listen for interrupt signals
@@ -58,7 +65,11 @@ func (s *Server) Start() error {
 	defer cancel()
 
 	// Attempt graceful shutdown
-	if err := s.httpServer.Shutdown(ctx); err != nil {
+	err := s.httpServer.Shutdown(ctx)
+	for _, f := range s.onShutdown {
+		f(ctx)
+	}
+	if err != nil {
 		log.Printf("Server forced to shutdown: %v", err)
 		return err
 	}